@@ -4,10 +4,16 @@ import (
 	_ "embed"
 	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -26,29 +32,84 @@ type Response struct {
 	PropertyType `json:"property_type"`
 }
 
-func NewServer(db *gorm.DB) *server {
-	dc := NewDawaCacher(db)
+type serverConfig struct {
+	dawaTTLs map[string]time.Duration
+	bbr      BBRSource
+	tiles    MapTiles
+	listings bool
+}
+
+type ServerOption func(*serverConfig)
+
+// WithDawaTTL overrides how long DAWA requests of the given kind are cached.
+func WithDawaTTL(kind string, ttl time.Duration) ServerOption {
+	return func(c *serverConfig) {
+		c.dawaTTLs[kind] = ttl
+	}
+}
+
+// WithBBR enriches the addresses of lookups with building data from src.
+func WithBBR(src BBRSource) ServerOption {
+	return func(c *serverConfig) {
+		c.bbr = src
+	}
+}
+
+// WithMapTiles draws the maps of lookups on tiles of the given tile server.
+func WithMapTiles(tiles MapTiles) ServerOption {
+	return func(c *serverConfig) {
+		c.tiles = tiles
+	}
+}
+
+// WithLiveListings fetches the listings of the postal codes of a lookup
+// from Boliga during the lookup, unless they were collected within the
+// last day. Without it, lookups compare the stored listings only, which
+// are collected by the fetch-listings command.
+func WithLiveListings() ServerOption {
+	return func(c *serverConfig) {
+		c.listings = true
+	}
+}
+
+func NewServer(db *gorm.DB, opts ...ServerOption) *server {
+	conf := serverConfig{
+		dawaTTLs: map[string]time.Duration{},
+	}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	dc := NewDawaCacher(db, conf.dawaTTLs)
 	bc := NewBoligaCacher(db, 4)
 
+	var lc ListingCacher
+	if conf.listings {
+		lc = NewListingCacher(db)
+	}
+
+	var bbr *bbrEnricher
+	if conf.bbr != nil {
+		bbr = newBBREnricher(db, conf.bbr, 4)
+	}
+
 	return &server{
-		dc, bc,
+		db, dc, bc, lc, bbr, conf.tiles,
 	}
 }
 
 type server struct {
-	dc DawaCacher
-	bc BoligaCacher
+	db    *gorm.DB
+	dc    DawaCacher
+	bc    BoligaCacher
+	lc    ListingCacher
+	bbr   *bbrEnricher
+	tiles MapTiles
 }
 
 func (s *server) handleLookup() http.HandlerFunc {
-	type Request struct {
-		Query  string `json:"q"`
-		Ranges []int  `json:"ranges"`
-		Filter int    `json:"filter_below_std"`
-	}
-
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req Request
+		var req LookupRequest
 		body := http.MaxBytesReader(w, r.Body, maxBytesLimit)
 		defer body.Close()
 
@@ -58,129 +119,835 @@ func (s *server) handleLookup() http.HandlerFunc {
 			return
 		}
 
-		addrs, err := s.dc.Do(DawaFuzzySearch{
-			Query: req.Query,
-		})
+		luResp, err := s.lookup(req)
 		if err != nil {
-			replyJSONErr(w, err, http.StatusBadRequest)
+			replyJSONErr(w, err, lookupErrStatus(err))
 			return
 		}
 
-		if len(addrs) > 1 {
-			replyJSONErr(w, fmt.Errorf("non-unique address, be more specific"), http.StatusBadRequest)
-			return
+		replyJSON(w, luResp, http.StatusOK)
+	}
+}
+
+// ParamError is a missing or invalid parameter of a request. Value is
+// empty when the parameter is missing.
+type ParamError struct {
+	Name  string
+	Value string
+}
+
+func (e ParamError) Error() string {
+	if e.Value == "" {
+		return "missing parameter: " + e.Name
+	}
+
+	return fmt.Sprintf("invalid %s: %s", e.Name, e.Value)
+}
+
+// lookupFromQuery performs the lookup described by the "q" and "range"
+// parameters of a download request.
+func (s *server) lookupFromQuery(params url.Values) (*LookupResponse, error) {
+	query := params.Get("q")
+	if query == "" {
+		return nil, ParamError{Name: "q"}
+	}
+
+	ranges, err := rangesFromQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.lookup(LookupRequest{
+		Query:  query,
+		Ranges: ranges,
+	})
+}
+
+func rangesFromQuery(params url.Values) ([]int, error) {
+	var ranges []int
+	for _, v := range params["range"] {
+		rang, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, ParamError{Name: "range", Value: v}
 		}
 
-		if len(addrs) == 0 {
-			replyJSONErr(w, fmt.Errorf("no found address"), http.StatusBadRequest)
+		ranges = append(ranges, rang)
+	}
+
+	return ranges, nil
+}
+
+func (s *server) handleCSVDownload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := s.lookupFromQuery(r.URL.Query())
+		if err != nil {
+			replyJSONErr(w, err, lookupErrStatus(err))
 			return
 		}
-		addr := addrs[0]
 
-		ranges, err := s.constructRanges(addr, req.Ranges)
+		premiums := map[int]float64{}
+		for _, fp := range info.Floors {
+			premiums[fp.Floor] = fp.Premium
+		}
+
+		w.Header().Add("Content-Type", "text/csv")
+		csvWriter := csv.NewWriter(w)
+		for i, s := range info.Sales {
+			a := info.Addrs[s.AddrIndex]
+			if i == 0 {
+				row := append(a.Headers(), s.Headers()...)
+				row = append(row, "floor_level", "floor_premium")
+				if err := csvWriter.Write(row); err != nil {
+					log.Printf("unable to write csv: %s", err)
+					return
+				}
+			}
+
+			var level, premium string
+			if floor, ok := a.FloorLevel(); ok {
+				level = strconv.Itoa(floor)
+				if p, ok := premiums[floor]; ok {
+					premium = strconv.FormatFloat(p, 'f', 4, 64)
+				}
+			}
+
+			row := append(a.ToSlice(), s.ToSlice()...)
+			row = append(row, level, premium)
+			if err := csvWriter.Write(row); err != nil {
+				log.Printf("unable to write csv: %s", err)
+				return
+			}
+		}
+
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			log.Printf("unable to write csv: %s", err)
+		}
+	}
+}
+
+func (s *server) handleGeoJSONDownload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := s.lookupFromQuery(r.URL.Query())
 		if err != nil {
-			replyJSONErr(w, err, http.StatusBadRequest)
+			replyJSONErr(w, err, lookupErrStatus(err))
 			return
 		}
 
-		for _, addrsInRange := range ranges {
-			addrs = append(addrs, addrsInRange...)
+		fc, err := LookupGeoJSON(info)
+		if err != nil {
+			replyJSONErr(w, err, http.StatusInternalServerError)
+			return
 		}
 
-		sales, err := s.bc.FetchSales(addrs)
+		w.Header().Add("Content-Type", "application/geo+json")
+		json.NewEncoder(w).Encode(fc)
+	}
+}
+
+func (s *server) handleXLSXDownload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := s.lookupFromQuery(r.URL.Query())
 		if err != nil {
-			replyJSONErr(w, err, http.StatusBadRequest)
+			replyJSONErr(w, err, lookupErrStatus(err))
 			return
 		}
 
-		addrs, sales = FilterAddressesByProperty(addr.BoligaPropertyKind, addrs, sales)
+		w.Header().Add("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Add("Content-Disposition", `attachment; filename="hjem.xlsx"`)
+		if err := WriteXLSX(w, LookupWorkbook(info)); err != nil {
+			log.Printf("unable to write workbook: %s", err)
+		}
+	}
+}
 
-		luResp, err := FormatLookupResponse(addrs, ranges, sales, req.Filter)
-		if err != nil {
-			replyJSONErr(w, err, http.StatusBadRequest)
+const (
+	mapWidth  = 800
+	mapHeight = 600
+)
+
+var (
+	ErrInvalidMap = errors.New("the lookup must have an address, a primary address and a summary of each address")
+)
+
+// mapLookup reads the lookup result of the body of a map request. Only the
+// addresses, ranges and summaries of the result are used, such that the map
+// of a lookup can be drawn without performing the lookup again.
+func mapLookup(body io.Reader) (*LookupResponse, error) {
+	var resp LookupResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Addrs) == 0 || len(resp.Summaries) != len(resp.Addrs) ||
+		resp.PrimaryIndex < 0 || resp.PrimaryIndex >= len(resp.Addrs) {
+		return nil, ErrInvalidMap
+	}
+
+	for i, sum := range resp.Summaries {
+		if resp.Addrs[i] == nil || (sum.LatestSquareMeterPrice != 0 && sum.LatestSale == nil) {
+			return nil, ErrInvalidMap
+		}
+	}
+
+	return &resp, nil
+}
+
+// handleMap replies with the map of a lookup as SVG. On GET the lookup of
+// the query is performed, e.g. "/map?q=...&range=500", while on POST the
+// body holds the result of a lookup which was already performed.
+func (s *server) handleMap() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var info *LookupResponse
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			info, err = s.lookupFromQuery(r.URL.Query())
+			if err != nil {
+				replyJSONErr(w, err, lookupErrStatus(err))
+				return
+			}
+		case http.MethodPost:
+			body := http.MaxBytesReader(w, r.Body, maxBytesLimit)
+			defer body.Close()
+
+			info, err = mapLookup(body)
+			if err != nil {
+				replyJSONErr(w, err, http.StatusBadRequest)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
-		replyJSON(w, luResp, http.StatusOK)
+		w.Header().Add("Content-Type", "image/svg+xml")
+		io.WriteString(w, RenderMap(info, s.tiles, mapWidth, mapHeight))
 	}
 }
 
-func (s *server) handleCSVDownload() http.HandlerFunc {
+var (
+	ErrNoEstimate = errors.New("unable to estimate the price of the address, provide a price")
+)
+
+// affordability calculates the financing of p. Without a price, the price
+// is estimated by looking up query within ranges, and the owner expense of
+// the address is used unless provided.
+func (s *server) affordability(query string, ranges []int, p LoanParameters) (*Affordability, error) {
+	if p.Price == 0 && query != "" {
+		if len(ranges) == 0 {
+			ranges = []int{500}
+		}
+
+		resp, err := s.lookup(LookupRequest{
+			Query:  query,
+			Ranges: ranges,
+			Filter: 1,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.Estimate == nil {
+			return nil, ErrNoEstimate
+		}
+		p.Price = resp.Estimate.AmountDKK
+
+		if p.OwnerExpense == 0 {
+			p.OwnerExpense = resp.Addrs[resp.PrimaryIndex].BoligaMonthlyOwnerExpense
+		}
+	}
+
+	if p.DownPayment == 0 {
+		p.DownPayment = int(math.Ceil(float64(p.Price) * MinDownPaymentRatio))
+	}
+
+	return CalculateAffordability(p)
+}
+
+func (s *server) handleAffordability() http.HandlerFunc {
 	type Request struct {
 		Query  string `json:"q"`
 		Ranges []int  `json:"ranges"`
+		LoanParameters
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		params, _ := url.ParseQuery(r.URL.RawQuery)
-		queries, ok := params["q"]
-		if !ok {
-			// handle error
+		req := Request{
+			LoanParameters: DefaultLoanParameters(),
 		}
-		query := queries[0]
-		reqranges, ok := params["range"]
-		if !ok {
-			// handle error
+		body := http.MaxBytesReader(w, r.Body, maxBytesLimit)
+		defer body.Close()
+
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			replyJSONErr(w, err, http.StatusBadRequest)
+			return
 		}
-		rang, err := strconv.Atoi(reqranges[0])
+
+		aff, err := s.affordability(req.Query, req.Ranges, req.LoanParameters)
 		if err != nil {
-			// handle error
+			replyJSONErr(w, err, lookupErrStatus(err))
+			return
 		}
 
-		addrs, err := s.dc.Do(DawaFuzzySearch{
-			Query: query,
-		})
+		replyJSON(w, aff, http.StatusOK)
+	}
+}
+
+// loanParametersFromQuery reads the loan parameters of a download request,
+// e.g. "?price=3000000&bond_rate=4&bank_rate=7".
+func loanParametersFromQuery(params url.Values) (LoanParameters, error) {
+	p := DefaultLoanParameters()
+
+	ints := map[string]*int{
+		"price":               &p.Price,
+		"down_payment":        &p.DownPayment,
+		"term_years":          &p.TermYears,
+		"bank_term_years":     &p.BankTermYears,
+		"interest_only_years": &p.InterestOnlyYears,
+		"owner_expense":       &p.OwnerExpense,
+	}
+	for k, v := range ints {
+		if params.Get(k) == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(params.Get(k))
 		if err != nil {
-			// handle error
+			return p, ParamError{Name: k, Value: params.Get(k)}
+		}
+		*v = i
+	}
+
+	floats := map[string]*float64{
+		"bond_rate":          &p.BondRate,
+		"bank_rate":          &p.BankRate,
+		"tax_deduction_rate": &p.TaxDeductionRate,
+	}
+	for k, v := range floats {
+		if params.Get(k) == "" {
+			continue
 		}
 
-		if len(addrs) > 1 {
-			// handle error
+		f, err := strconv.ParseFloat(params.Get(k), 64)
+		if err != nil {
+			return p, ParamError{Name: k, Value: params.Get(k)}
+		}
+		*v = f
+	}
+
+	return p, nil
+}
+
+func (s *server) handleAffordabilityCSVDownload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		p, err := loanParametersFromQuery(params)
+		if err != nil {
+			replyJSONErr(w, err, http.StatusBadRequest)
 			return
 		}
-		addr := addrs[0]
 
-		ranges, err := s.constructRanges(addr, []int{rang})
+		ranges, err := rangesFromQuery(params)
 		if err != nil {
-			// handle error
+			replyJSONErr(w, err, http.StatusBadRequest)
+			return
 		}
 
-		for _, addrsInRange := range ranges {
-			addrs = append(addrs, addrsInRange...)
+		aff, err := s.affordability(params.Get("q"), ranges, p)
+		if err != nil {
+			replyJSONErr(w, err, lookupErrStatus(err))
+			return
+		}
+
+		w.Header().Add("Content-Type", "text/csv")
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Write(AmortizationRow{}.Headers())
+		for _, row := range aff.Schedule {
+			csvWriter.Write(row.ToSlice())
+		}
+		csvWriter.Flush()
+	}
+}
+
+func (s *server) handleCompare() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CompareRequest
+		body := http.MaxBytesReader(w, r.Body, maxBytesLimit)
+		defer body.Close()
+
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			replyJSONErr(w, err, http.StatusBadRequest)
+			return
 		}
 
-		sales, err := s.bc.FetchSales(addrs)
+		cmp, err := s.compare(req)
 		if err != nil {
-			// handle error
+			replyJSONErr(w, err, lookupErrStatus(err))
+			return
 		}
 
-		addrs, sales = FilterAddressesByProperty(addr.BoligaPropertyKind, addrs, sales)
+		replyJSON(w, cmp, http.StatusOK)
+	}
+}
 
-		info, err := FormatLookupResponse(addrs, ranges, sales, 0)
+// handleCompareCSVDownload replies with a comparison of the addresses of
+// the "q" parameters as CSV, e.g.
+// "?q=<address>&q=<address>&range=500&filter_below_std=1".
+func (s *server) handleCompareCSVDownload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		ranges, err := rangesFromQuery(params)
 		if err != nil {
 			replyJSONErr(w, err, http.StatusBadRequest)
 			return
 		}
 
+		var filter int
+		if v := params.Get("filter_below_std"); v != "" {
+			filter, err = strconv.Atoi(v)
+			if err != nil {
+				replyJSONErr(w, ParamError{Name: "filter_below_std", Value: v}, http.StatusBadRequest)
+				return
+			}
+		}
+
+		cmp, err := s.compare(CompareRequest{
+			Queries: params["q"],
+			Ranges:  ranges,
+			Filter:  filter,
+		})
+		if err != nil {
+			replyJSONErr(w, err, lookupErrStatus(err))
+			return
+		}
+
 		w.Header().Add("Content-Type", "text/csv")
 		csvWriter := csv.NewWriter(w)
-		for i, s := range info.Sales {
-			a := info.Addrs[s.AddrIndex]
-			if i == 0 {
-				row := append(a.Headers(), s.Headers()...)
-				if err := csvWriter.Write(row); err != nil {
-					// handle error
+		csvWriter.Write(cmp.Headers())
+		csvWriter.WriteAll(cmp.Rows())
+	}
+}
+
+// handleSavedLookups lists the saved lookups, newest first, on GET, and
+// performs and saves a lookup on POST.
+func (s *server) handleSavedLookups() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			var saved []SavedLookup
+			if err := s.db.Order("created_at DESC").Find(&saved).Error; err != nil {
+				replyJSONErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			out := make([]SavedLookupJSON, len(saved))
+			for i, sl := range saved {
+				out[i] = sl.JSON(false)
+			}
+
+			replyJSON(w, out, http.StatusOK)
+		case http.MethodPost:
+			var req LookupRequest
+			body := http.MaxBytesReader(w, r.Body, maxBytesLimit)
+			defer body.Close()
+
+			if err := json.NewDecoder(body).Decode(&req); err != nil {
+				replyJSONErr(w, err, http.StatusBadRequest)
+				return
+			}
+
+			resp, err := s.lookup(req)
+			if err != nil {
+				replyJSONErr(w, err, lookupErrStatus(err))
+				return
+			}
+
+			sl, err := SaveLookup(s.db, req, resp)
+			if err != nil {
+				replyJSONErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			out := sl.JSON(true)
+			out.Token = sl.Token
+			replyJSON(w, out, http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleSavedLookup replies with the saved lookup of the path, e.g.
+// "/api/lookups/<id>", including its result, on GET, and deletes it on
+// DELETE, given the token of its creator by "?token=".
+func (s *server) handleSavedLookup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/lookups/")
+
+		switch r.Method {
+		case http.MethodGet:
+			sl, err := SavedLookupByID(s.db, id)
+			if errors.Is(err, ErrNoSavedLookup) {
+				replyJSONErr(w, err, http.StatusNotFound)
+				return
+			}
+
+			if err != nil {
+				replyJSONErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			replyJSON(w, sl.JSON(true), http.StatusOK)
+		case http.MethodDelete:
+			err := DeleteSavedLookup(s.db, id, r.URL.Query().Get("token"))
+			var pe ParamError
+			switch {
+			case errors.As(err, &pe):
+				replyJSONErr(w, err, http.StatusBadRequest)
+				return
+			case errors.Is(err, ErrNoSavedLookup):
+				replyJSONErr(w, err, http.StatusNotFound)
+				return
+			case err != nil:
+				replyJSONErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handlePermalink serves the frontend for a saved lookup, e.g. "/l/<id>",
+// which renders the result saved under the id.
+func (s *server) handlePermalink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/l/")
+		if _, err := SavedLookupByID(s.db, id); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write(indexBytes)
+	}
+}
+
+// watchByToken returns the watch of the "token" parameter of r.
+func (s *server) watchByToken(r *http.Request) (*Watch, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return nil, ParamError{Name: "token"}
+	}
+
+	var watch Watch
+	err := s.db.Where("token = ?", token).First(&watch).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoWatch
+	}
+
+	return &watch, err
+}
+
+// watchErrStatus returns the status code to reply with, when a watch
+// cannot be found by its token.
+func watchErrStatus(err error) int {
+	var pe ParamError
+	switch {
+	case errors.As(err, &pe):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNoWatch):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// handleWatches creates a watch on POST, and replies with or deletes the
+// watch given by "?token=" on GET and DELETE. The token is only replied
+// when the watch is created.
+func (s *server) handleWatches() http.HandlerFunc {
+	type Request struct {
+		Query   string `json:"q"`
+		Radius  int    `json:"radius"`
+		Kind    string `json:"kind"`
+		Webhook string `json:"webhook"`
+		Email   string `json:"email"`
+	}
+
+	type Created struct {
+		Watch *Watch `json:"watch"`
+		Token string `json:"token"`
+		Feed  string `json:"feed"`
+	}
+
+	resolve := AddressResolver(s.db, s.dc)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			watch, err := s.watchByToken(r)
+			if err != nil {
+				replyJSONErr(w, err, watchErrStatus(err))
+				return
+			}
+
+			replyJSON(w, watch, http.StatusOK)
+		case http.MethodPost:
+			var req Request
+			body := http.MaxBytesReader(w, r.Body, maxBytesLimit)
+			defer body.Close()
+
+			if err := json.NewDecoder(body).Decode(&req); err != nil {
+				replyJSONErr(w, err, http.StatusBadRequest)
+				return
+			}
+
+			if req.Radius <= 0 || req.Radius > maxWatchRadius {
+				replyJSONErr(w, ErrInvalidRadius, http.StatusBadRequest)
+				return
+			}
+
+			if req.Webhook != "" {
+				if err := ValidateWebhook(req.Webhook); err != nil {
+					replyJSONErr(w, err, http.StatusBadRequest)
+					return
 				}
 			}
 
-			row := append(a.ToSlice(), s.ToSlice()...)
-			if err := csvWriter.Write(row); err != nil {
+			if req.Email != "" {
+				if err := ValidateEmail(req.Email); err != nil {
+					replyJSONErr(w, err, http.StatusBadRequest)
+					return
+				}
+			}
 
+			kind, err := PropertyKindFromName(req.Kind)
+			if err != nil {
+				replyJSONErr(w, err, http.StatusBadRequest)
+				return
 			}
+
+			addr, err := resolve(req.Query)
+			if err != nil {
+				replyJSONErr(w, err, http.StatusBadRequest)
+				return
+			}
+
+			token, err := newWatchToken()
+			if err != nil {
+				replyJSONErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			watch := Watch{
+				Token:   token,
+				AddrID:  addr.ID,
+				Address: addr.DawaID,
+				Radius:  req.Radius,
+				Kind:    kind,
+				Webhook: req.Webhook,
+				Email:   req.Email,
+			}
+			if err := s.db.Create(&watch).Error; err != nil {
+				replyJSONErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			replyJSON(w, Created{
+				Watch: &watch,
+				Token: token,
+				Feed:  "/feed/watch?token=" + token,
+			}, http.StatusCreated)
+		case http.MethodDelete:
+			watch, err := s.watchByToken(r)
+			if err != nil {
+				replyJSONErr(w, err, watchErrStatus(err))
+				return
+			}
+
+			err = s.db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Where("watch_id = ?", watch.ID).Delete(&WatchSale{}).Error; err != nil {
+					return err
+				}
+
+				return tx.Delete(watch).Error
+			})
+			if err != nil {
+				replyJSONErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	}
 }
 
+// handleWatchFeed replies with the Atom feed of the watch given by
+// "?token=".
+func (s *server) handleWatchFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		watch, err := s.watchByToken(r)
+		if err != nil {
+			replyJSONErr(w, err, watchErrStatus(err))
+			return
+		}
+
+		var sales []WatchSale
+		err = s.db.Where("watch_id = ?", watch.ID).
+			Order("found_at DESC, date DESC").
+			Limit(100).
+			Find(&sales).Error
+		if err != nil {
+			replyJSONErr(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(WatchFeed(watch, sales))
+	}
+}
+
+// salesQueryFromParams reads the filters and page of a sales query, e.g.
+// "?zip=2000-2500&type=house,apartment&from=2020-01-01&limit=50". Polygons
+// are given as longitude and latitude pairs, e.g.
+// "?polygon=12.5,55.6,12.6,55.6,12.6,55.7".
+func salesQueryFromParams(params url.Values) (SalesQuery, error) {
+	var q SalesQuery
+
+	if v := params.Get("zip"); v != "" {
+		conf, err := NewConfig(v, "")
+		if err != nil {
+			return q, ParamError{Name: "zip", Value: v}
+		}
+		q.ZipFrom, q.ZipTo = *conf.ZipCodeFrom, *conf.ZipCodeTo
+	}
+
+	for _, v := range params["municipality"] {
+		q.Municipalities = append(q.Municipalities, strings.Split(v, ",")...)
+	}
+
+	for _, v := range params["type"] {
+		for _, name := range strings.Split(v, ",") {
+			kind, err := PropertyKindFromName(name)
+			if err != nil {
+				return q, ParamError{Name: "type", Value: name}
+			}
+
+			if kind == 0 {
+				continue
+			}
+			q.Kinds = append(q.Kinds, kind)
+		}
+	}
+
+	dates := map[string]*time.Time{
+		"from": &q.From,
+		"to":   &q.To,
+	}
+	for k, d := range dates {
+		if params.Get(k) == "" {
+			continue
+		}
+
+		t, err := time.Parse("2006-01-02", params.Get(k))
+		if err != nil {
+			return q, ParamError{Name: k, Value: params.Get(k)}
+		}
+		*d = t
+	}
+
+	ints := map[string]*int{
+		"min_price": &q.MinPrice,
+		"max_price": &q.MaxPrice,
+		"min_size":  &q.MinSize,
+		"max_size":  &q.MaxSize,
+		"offset":    &q.Offset,
+		"limit":     &q.Limit,
+	}
+	for k, v := range ints {
+		if params.Get(k) == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(params.Get(k))
+		if err != nil || i < 0 {
+			return q, ParamError{Name: k, Value: params.Get(k)}
+		}
+		*v = i
+	}
+
+	floats := func(k string) ([]float64, error) {
+		var out []float64
+		for _, f := range strings.Split(params.Get(k), ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+			if err != nil {
+				return nil, ParamError{Name: k, Value: params.Get(k)}
+			}
+			out = append(out, v)
+		}
+
+		return out, nil
+	}
+
+	if params.Get("bbox") != "" {
+		bbox, err := floats("bbox")
+		if err != nil {
+			return q, err
+		}
+
+		if len(bbox) != 4 || bbox[0] > bbox[2] || bbox[1] > bbox[3] {
+			return q, ParamError{Name: "bbox", Value: params.Get("bbox")}
+		}
+		q.BBox = bbox
+	}
+
+	if params.Get("polygon") != "" {
+		coords, err := floats("polygon")
+		if err != nil {
+			return q, err
+		}
+
+		if len(coords)%2 != 0 || len(coords) < 6 {
+			return q, ParamError{Name: "polygon", Value: params.Get("polygon")}
+		}
+
+		for i := 0; i < len(coords); i += 2 {
+			q.Polygon = append(q.Polygon, [2]float64{coords[i], coords[i+1]})
+		}
+	}
+
+	return q, nil
+}
+
+// SalesPage is a page of the sales matching a query. Next is the URL of
+// the following page, unless this is the last page.
+type SalesPage struct {
+	Sales  []StoredSale `json:"sales"`
+	Offset int          `json:"offset"`
+	Limit  int          `json:"limit"`
+	Next   string       `json:"next,omitempty"`
+}
+
+func (s *server) handleCacheStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := s.dc.Stats()
+		if err != nil {
+			replyJSONErr(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		replyJSON(w, struct {
+			Dawa DawaCacheStats `json:"dawa"`
+		}{stats}, http.StatusOK)
+	}
+}
+
 //go:embed frontend/index.html
 var indexBytes []byte
 
@@ -205,7 +972,21 @@ func (s *server) Routes() *http.ServeMux {
 	mux.HandleFunc("/", s.handleIndex())
 	mux.HandleFunc("/dist/app.bundle.js", s.handleBundle())
 	mux.HandleFunc("/api/lookup", s.handleLookup())
+	mux.HandleFunc(apiV1Prefix+"/", s.handleAPIV1())
 	mux.HandleFunc("/download/csv", s.handleCSVDownload())
+	mux.HandleFunc("/download/geojson", s.handleGeoJSONDownload())
+	mux.HandleFunc("/download/xlsx", s.handleXLSXDownload())
+	mux.HandleFunc("/map", s.handleMap())
+	mux.HandleFunc("/api/cache/stats", s.handleCacheStats())
+	mux.HandleFunc("/api/affordability", s.handleAffordability())
+	mux.HandleFunc("/download/affordability", s.handleAffordabilityCSVDownload())
+	mux.HandleFunc("/api/compare", s.handleCompare())
+	mux.HandleFunc("/download/compare", s.handleCompareCSVDownload())
+	mux.HandleFunc("/api/lookups", s.handleSavedLookups())
+	mux.HandleFunc("/api/lookups/", s.handleSavedLookup())
+	mux.HandleFunc("/l/", s.handlePermalink())
+	mux.HandleFunc("/api/watches", s.handleWatches())
+	mux.HandleFunc("/feed/watch", s.handleWatchFeed())
 
 	return mux
 }
@@ -253,23 +1034,53 @@ type SquareMeterPrices struct {
 }
 
 type LookupResponse struct {
-	PrimaryIndex int               `json:"primary_idx"`
-	Addrs        []*Address        `json:"addresses"`
-	Sales        []*JSONSale       `json:"sales"`
-	Ranges       map[int][]int     `json:"ranges,omitempty"`
-	SquareMeters SquareMeterPrices `json:"sqmeters"`
+	PrimaryIndex int                  `json:"primary_idx"`
+	Addrs        []*Address           `json:"addresses"`
+	Sales        []*JSONSale          `json:"sales"`
+	Ranges       map[int][]int        `json:"ranges,omitempty"`
+	SquareMeters SquareMeterPrices    `json:"sqmeters"`
+	Floors       []FloorPremium       `json:"floors,omitempty"`
+	Energy       *EnergyAnalysis      `json:"energy,omitempty"`
+	Estimate     *ValueEstimate       `json:"estimate,omitempty"`
+	Valuation    *ValuationComparison `json:"valuation,omitempty"`
+	Listings     *ListingComparison   `json:"listings,omitempty"`
+	Spread       *AskingSpread        `json:"spread,omitempty"`
+	Buildings    []*BuildingSummary   `json:"buildings,omitempty"`
+	Summaries    []AddressSummary     `json:"summaries"`
+	Warnings     []Warning            `json:"warnings,omitempty"`
+}
+
+// Warning describes data which was left out of a lookup, since it could
+// not be fetched. Address is the address, or postal code, it concerns.
+type Warning struct {
+	Address string `json:"address"`
+	Message string `json:"message"`
 }
 
+// JSONSale is a sale of the address at AddrIndex, along with the building
+// size and energy label of the address at the time of the sale.
 type JSONSale struct {
-	AddrIndex int       `json:"addr_idx"`
-	Amount    int       `json:"amount"`
-	When      time.Time `json:"when"`
+	AddrIndex    int       `json:"addr_idx"`
+	Amount       int       `json:"amount"`
+	When         time.Time `json:"when"`
+	BuildingSize int       `json:"building_size"`
+	EnergyLabel  string    `json:"energy_label,omitempty"`
+
+	// ValuationRatio is the ratio of the amount to the public valuation of
+	// the address at the time of the sale, if known.
+	ValuationRatio float64 `json:"valuation_ratio,omitempty"`
+
+	// AskingPrice is the last asking price before the sale, if known.
+	AskingPrice int `json:"asking_price,omitempty"`
 }
 
 func (s JSONSale) ToSlice() []string {
 	return []string{
 		strconv.Itoa(s.Amount),
 		s.When.Format(time.RFC3339),
+		strconv.Itoa(s.BuildingSize),
+		s.EnergyLabel,
+		strconv.Itoa(s.AskingPrice),
 	}
 }
 
@@ -277,16 +1088,40 @@ func (s JSONSale) Headers() []string {
 	return []string{
 		"amount_dkk",
 		"sold_date",
+		"building_size_at_sale",
+		"energy_label_at_sale",
+		"asking_price_dkk",
+	}
+}
+
+// buildingSizeAt returns the building size of an address at t, falling
+// back to its current size when nothing was observed.
+func buildingSizeAt(a *Address, history AttributeHistory, t time.Time) int {
+	if obs := history.At(a.ID, t); obs != nil && obs.BuildingSize > 0 {
+		return obs.BuildingSize
+	}
+
+	return a.BoligaBuildingSize
+}
+
+// energyLabelAt returns the normalized energy label of an address at t,
+// falling back to its current label when nothing was observed.
+func energyLabelAt(a *Address, history AttributeHistory, t time.Time) string {
+	if obs := history.At(a.ID, t); obs != nil {
+		return NormalizeEnergyLabel(obs.EnergyMarking)
 	}
+
+	return NormalizeEnergyLabel(a.BoligaEnergyMarking)
 }
 
-func FormatLookupResponse(addrs []*Address, ranges map[int][]*Address, sales [][]Sale, stdf int) (*LookupResponse, error) {
+func FormatLookupResponse(addrs []*Address, ranges map[int][]*Address, sales [][]Sale, history AttributeHistory, stdf int) (*LookupResponse, error) {
 	m := map[string]int{}
 	var resp LookupResponse
 
 	var i int
 	for j, s := range sales {
-		if len(s) > 0 {
+		// the primary address is kept, even if it has no sales
+		if len(s) > 0 || j == resp.PrimaryIndex {
 			a := addrs[j]
 			m[a.DawaID] = i
 			resp.Addrs = append(resp.Addrs, a)
@@ -294,9 +1129,12 @@ func FormatLookupResponse(addrs []*Address, ranges map[int][]*Address, sales [][
 			tempsales := make([]*JSONSale, len(s))
 			for k, sale := range s {
 				tempsales[k] = &JSONSale{
-					AddrIndex: i,
-					Amount:    sale.AmountDKK,
-					When:      sale.Date,
+					AddrIndex:    i,
+					Amount:       sale.AmountDKK,
+					When:         sale.Date,
+					BuildingSize: buildingSizeAt(a, history, sale.Date),
+					EnergyLabel:  energyLabelAt(a, history, sale.Date),
+					AskingPrice:  sale.AskingPrice,
 				}
 			}
 			resp.Sales = append(resp.Sales, tempsales...)
@@ -321,7 +1159,7 @@ func FormatLookupResponse(addrs []*Address, ranges map[int][]*Address, sales [][
 	}
 	resp.Ranges = r
 
-	normalSales, global := SalesStatistics(resp.Addrs, resp.Sales, stdf)
+	normalSales, global := SalesStatistics(resp.Sales, stdf)
 	resp.Sales = normalSales
 
 	resp.SquareMeters = SquareMeterPrices{
@@ -329,9 +1167,16 @@ func FormatLookupResponse(addrs []*Address, ranges map[int][]*Address, sales [][
 	}
 
 	var projections []map[time.Time]int
+	var latestSale time.Time
+	var latestProjection map[time.Time]int
 	for _, s := range sales[resp.PrimaryIndex] {
+		size := buildingSizeAt(addrs[0], history, s.Date)
+		if size == 0 {
+			continue
+		}
+
 		m := map[time.Time]int{}
-		sqMeterPrice := s.AmountDKK / addrs[0].BoligaBuildingSize
+		sqMeterPrice := s.AmountDKK / size
 		yearInt, _, _ := s.Date.Date()
 		saleYear, _ := time.Parse("2-1-2006", fmt.Sprintf("1-1-%d", yearInt))
 
@@ -346,8 +1191,26 @@ func FormatLookupResponse(addrs []*Address, ranges map[int][]*Address, sales [][
 		}
 
 		projections = append(projections, m)
+		if s.Date.After(latestSale) {
+			latestSale, latestProjection = s.Date, m
+		}
 	}
 	resp.SquareMeters.Projections = projections
+	resp.Estimate = EstimateValue(addrs[0], global, latestProjection)
+	resp.Buildings = SummarizeBuildings(&resp)
+
+	if len(addrs) > 0 {
+		resp.Energy = AnalyseEnergyLabels(addrs[0], resp.Sales, resp.SquareMeters.Global)
+	}
+
+	if len(addrs) > 0 && addrs[0].BoligaPropertyKind == PropertyApartment {
+		resp.Floors = FloorPremiums(resp.Addrs, resp.Sales, resp.SquareMeters.Global)
+		for _, b := range resp.Buildings {
+			b.Floors = FloorPremiums(resp.Addrs, b.sales, resp.SquareMeters.Global)
+		}
+	}
+
+	resp.Summaries = SummarizeAddresses(&resp)
 
 	return &resp, nil
 }
@@ -0,0 +1,614 @@
+package hjem
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiV1Prefix = "/api/v1"
+
+// Error codes of the v1 API. Unlike the messages of errors, the codes are
+// stable, so clients should act on them.
+const (
+	CodeMissingParameter = "missing_parameter"
+	CodeInvalidParameter = "invalid_parameter"
+	CodeInvalidBody      = "invalid_body"
+	CodeNonUniqueAddress = "non_unique_address"
+	CodeAddressNotFound  = "address_not_found"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeUpstream         = "upstream_error"
+	CodeInternal         = "internal_error"
+)
+
+// APIError is an error of the v1 API, where Code is one of the Code
+// constants and Message describes the error to humans.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e APIError) Error() string {
+	return e.Message
+}
+
+// APIErrorResponse is the body of every error reply of the v1 API.
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// bodyError is a request body which could not be decoded.
+type bodyError struct {
+	err error
+}
+
+func (e bodyError) Error() string {
+	return "invalid body: " + e.err.Error()
+}
+
+// apiErrorStatus returns the error code and status code of err.
+func apiErrorStatus(err error) (string, int) {
+	var pe ParamError
+	var be bodyError
+	var ae AddrError
+
+	switch {
+	case errors.As(err, &pe) && pe.Value == "":
+		return CodeMissingParameter, http.StatusBadRequest
+	case errors.As(err, &pe):
+		return CodeInvalidParameter, http.StatusBadRequest
+	case errors.As(err, &be):
+		return CodeInvalidBody, http.StatusBadRequest
+	case errors.Is(err, ErrNonUniqueAddr):
+		return CodeNonUniqueAddress, http.StatusBadRequest
+	case errors.Is(err, ErrNoAddr):
+		return CodeAddressNotFound, http.StatusNotFound
+	case errors.Is(err, ErrNoSavedLookup):
+		return CodeNotFound, http.StatusNotFound
+	case errors.As(err, &ae):
+		return CodeUpstream, http.StatusBadGateway
+	}
+
+	return CodeInternal, http.StatusInternalServerError
+}
+
+func replyAPIErr(w http.ResponseWriter, err error) {
+	code, sc := apiErrorStatus(err)
+	replyAPIError(w, APIError{code, err.Error()}, sc)
+}
+
+func replyAPIError(w http.ResponseWriter, e APIError, sc int) {
+	replyJSON(w, APIErrorResponse{e}, sc)
+}
+
+// apiParam is a parameter of a route of the v1 API. Type is its JSON
+// schema type, and Multi tells whether it may be repeated.
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string
+	Required    bool
+	Multi       bool
+	Description string
+}
+
+// apiHandler handles a request of the v1 API, given the parameters of its
+// path, replying with the status code and value, or an error.
+type apiHandler func(s *server, r *http.Request, vars map[string]string) (interface{}, int, error)
+
+// apiStream is replied by handlers which write the response themselves,
+// such as the sales streamed as CSV.
+type apiStream func(w http.ResponseWriter)
+
+// apiRoute is an endpoint of the v1 API. Path is relative to apiV1Prefix,
+// with path parameters in braces, e.g. "/lookups/{id}". Body and Response
+// are values of the types of the request and response bodies, if any.
+// Streams lists the content types the route can reply with besides JSON.
+type apiRoute struct {
+	Method    string
+	Path      string
+	Operation string
+	Summary   string
+	Params    []apiParam
+	Body      interface{}
+	Status    int
+	Response  interface{}
+	Streams   []string
+	handle    apiHandler
+}
+
+// match returns the path parameters of path, if it matches the route.
+func (route apiRoute) match(path string) (map[string]string, bool) {
+	want := strings.Split(strings.Trim(route.Path, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return nil, false
+	}
+
+	vars := map[string]string{}
+	for i, seg := range want {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if got[i] == "" {
+				return nil, false
+			}
+
+			vars[seg[1:len(seg)-1]] = got[i]
+			continue
+		}
+
+		if seg != got[i] {
+			return nil, false
+		}
+	}
+
+	return vars, true
+}
+
+var lookupParams = []apiParam{
+	{Name: "q", In: "query", Type: "string", Required: true, Description: "the address to look up"},
+	{Name: "range", In: "query", Type: "integer", Multi: true, Description: "radius in meters of a range around the address"},
+	{Name: "filter_below_std", In: "query", Type: "integer", Description: "leave out sales further than this many standard deviations from the mean price per square meter"},
+}
+
+// apiV1Routes returns the endpoints of the v1 API, from which both its
+// routing and its OpenAPI document are derived.
+func apiV1Routes() []apiRoute {
+	return []apiRoute{
+		{
+			Method:    http.MethodGet,
+			Path:      "/addresses",
+			Operation: "searchAddresses",
+			Summary:   "Search for addresses",
+			Params:    lookupParams[:1],
+			Status:    http.StatusOK,
+			Response:  AddressesResponse{},
+			handle:    (*server).v1SearchAddresses,
+		},
+		{
+			Method:    http.MethodGet,
+			Path:      "/sales",
+			Operation: "listSales",
+			Summary:   "List the sales of an address and the addresses around it",
+			Params:    lookupParams,
+			Status:    http.StatusOK,
+			Response:  SalesResponse{},
+			handle:    (*server).v1Sales,
+		},
+		{
+			Method:    http.MethodGet,
+			Path:      "/sales/search",
+			Operation: "searchSales",
+			Summary:   "Search the stored sales, in pages of JSON or in full as CSV or NDJSON",
+			Params: []apiParam{
+				{Name: "zip", In: "query", Type: "string", Description: "postal code or range of postal codes, e.g. 2000-2500"},
+				{Name: "municipality", In: "query", Type: "string", Multi: true, Description: "municipality codes, separated by commas"},
+				{Name: "type", In: "query", Type: "string", Multi: true, Description: "property types, separated by commas, e.g. house,apartment"},
+				{Name: "from", In: "query", Type: "string", Description: "earliest date of sale, e.g. 2020-01-01"},
+				{Name: "to", In: "query", Type: "string", Description: "latest date of sale, e.g. 2020-12-31"},
+				{Name: "min_price", In: "query", Type: "integer", Description: "least amount of the sale"},
+				{Name: "max_price", In: "query", Type: "integer", Description: "largest amount of the sale"},
+				{Name: "min_size", In: "query", Type: "integer", Description: "least building size in square meters"},
+				{Name: "max_size", In: "query", Type: "integer", Description: "largest building size in square meters"},
+				{Name: "bbox", In: "query", Type: "string", Description: "bounding box of the addresses, as min_lon,min_lat,max_lon,max_lat"},
+				{Name: "polygon", In: "query", Type: "string", Description: "polygon around the addresses, as lon,lat pairs separated by commas"},
+				{Name: "limit", In: "query", Type: "integer", Description: fmt.Sprintf("sales per page, at most %d, default: %d", maxSalesLimit, defaultSalesLimit)},
+				{Name: "offset", In: "query", Type: "integer", Description: "sales to skip"},
+				{Name: "format", In: "query", Type: "string", Description: "json, csv or ndjson, default: json. csv and ndjson reply every sale"},
+			},
+			Status:   http.StatusOK,
+			Response: SalesPage{},
+			Streams:  []string{"text/csv", "application/x-ndjson"},
+			handle:   (*server).v1SearchSales,
+		},
+		{
+			Method:    http.MethodGet,
+			Path:      "/statistics",
+			Operation: "getStatistics",
+			Summary:   "Get the price statistics of an address and the addresses around it",
+			Params:    lookupParams,
+			Status:    http.StatusOK,
+			Response:  LookupStatistics{},
+			handle:    (*server).v1Statistics,
+		},
+		{
+			Method:    http.MethodGet,
+			Path:      "/lookups",
+			Operation: "lookup",
+			Summary:   "Look up an address, without saving the lookup",
+			Params:    lookupParams,
+			Status:    http.StatusOK,
+			Response:  LookupResponse{},
+			handle:    (*server).v1Lookup,
+		},
+		{
+			Method:    http.MethodPost,
+			Path:      "/lookups",
+			Operation: "createLookup",
+			Summary:   "Look up an address, and save the lookup",
+			Body:      LookupRequest{},
+			Status:    http.StatusCreated,
+			Response:  SavedLookupJSON{},
+			handle:    (*server).v1CreateLookup,
+		},
+		{
+			Method:    http.MethodGet,
+			Path:      "/lookups/{id}",
+			Operation: "getLookup",
+			Summary:   "Get a saved lookup, including its result",
+			Params:    []apiParam{{Name: "id", In: "path", Type: "string", Description: "id of the saved lookup"}},
+			Status:    http.StatusOK,
+			Response:  SavedLookupJSON{},
+			handle:    (*server).v1GetLookup,
+		},
+		{
+			Method:    http.MethodDelete,
+			Path:      "/lookups/{id}",
+			Operation: "deleteLookup",
+			Summary:   "Delete a saved lookup",
+			Params: []apiParam{
+				{Name: "id", In: "path", Type: "string", Description: "id of the saved lookup"},
+				{Name: "token", In: "query", Type: "string", Required: true, Description: "token replied when the lookup was saved"},
+			},
+			Status:   http.StatusOK,
+			Response: SavedLookupJSON{},
+			handle:   (*server).v1DeleteLookup,
+		},
+		{
+			Method:    http.MethodGet,
+			Path:      "/areas",
+			Operation: "getAreaReports",
+			Summary:   "Get reports of the sales of postal codes or municipalities by year",
+			Params: []apiParam{
+				{Name: AreaZip, In: "query", Type: "string", Multi: true, Description: "postal codes to report on, separated by commas"},
+				{Name: AreaMunicipality, In: "query", Type: "string", Multi: true, Description: "municipality codes to report on, separated by commas, when no postal codes are given"},
+			},
+			Status:   http.StatusOK,
+			Response: []AreaReport{},
+			handle:   (*server).v1AreaReports,
+		},
+		{
+			Method:    http.MethodGet,
+			Path:      "/openapi.json",
+			Operation: "getOpenAPI",
+			Summary:   "Get the OpenAPI document of the API",
+			Status:    http.StatusOK,
+			handle: func(*server, *http.Request, map[string]string) (interface{}, int, error) {
+				return OpenAPI(), http.StatusOK, nil
+			},
+		},
+	}
+}
+
+// handleAPIV1 routes the requests of the v1 API to the handlers of
+// apiV1Routes, limiting the size of their bodies to maxBytesLimit.
+func (s *server) handleAPIV1() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, apiV1Prefix)
+
+		var allowed []string
+		for _, route := range apiV1Routes() {
+			vars, ok := route.match(path)
+			if !ok {
+				continue
+			}
+
+			if route.Method != r.Method {
+				allowed = append(allowed, route.Method)
+				continue
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytesLimit)
+			out, sc, err := route.handle(s, r, vars)
+			if err != nil {
+				replyAPIErr(w, err)
+				return
+			}
+
+			if stream, ok := out.(apiStream); ok {
+				stream(w)
+				return
+			}
+
+			replyJSON(w, out, sc)
+			return
+		}
+
+		if allowed != nil {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			replyAPIError(w, APIError{CodeMethodNotAllowed, "method not allowed: " + r.Method}, http.StatusMethodNotAllowed)
+			return
+		}
+
+		replyAPIError(w, APIError{CodeNotFound, "not found: " + r.URL.Path}, http.StatusNotFound)
+	}
+}
+
+// lookupRequestFromQuery reads a lookup request from the parameters of
+// lookupParams.
+func lookupRequestFromQuery(r *http.Request) (LookupRequest, error) {
+	params := r.URL.Query()
+	req := LookupRequest{Query: params.Get("q")}
+	if req.Query == "" {
+		return req, ParamError{Name: "q"}
+	}
+
+	ranges, err := rangesFromQuery(params)
+	if err != nil {
+		return req, err
+	}
+	req.Ranges = ranges
+
+	if v := params.Get("filter_below_std"); v != "" {
+		filter, err := strconv.Atoi(v)
+		if err != nil {
+			return req, ParamError{Name: "filter_below_std", Value: v}
+		}
+		req.Filter = filter
+	}
+
+	return req, nil
+}
+
+// AddressesResponse holds the addresses matching a search.
+type AddressesResponse struct {
+	Addresses []*Address `json:"addresses"`
+}
+
+func (s *server) v1SearchAddresses(r *http.Request, _ map[string]string) (interface{}, int, error) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		return nil, 0, ParamError{Name: "q"}
+	}
+
+	addrs, err := s.dc.Do(DawaFuzzySearch{Query: q})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if addrs == nil {
+		addrs = []*Address{}
+	}
+
+	return AddressesResponse{addrs}, http.StatusOK, nil
+}
+
+// SalesResponse holds the sales of a lookup, where the sales refer to the
+// addresses by their index in Addrs.
+type SalesResponse struct {
+	PrimaryIndex int              `json:"primary_idx"`
+	Addrs        []*Address       `json:"addresses"`
+	Sales        []*JSONSale      `json:"sales"`
+	Summaries    []AddressSummary `json:"summaries"`
+	Warnings     []Warning        `json:"warnings,omitempty"`
+}
+
+func (s *server) v1Sales(r *http.Request, _ map[string]string) (interface{}, int, error) {
+	req, err := lookupRequestFromQuery(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := s.lookup(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return SalesResponse{
+		PrimaryIndex: resp.PrimaryIndex,
+		Addrs:        resp.Addrs,
+		Sales:        resp.Sales,
+		Summaries:    resp.Summaries,
+		Warnings:     resp.Warnings,
+	}, http.StatusOK, nil
+}
+
+// v1SearchSales queries the stored sales. The sales are replied in pages
+// of JSON, or streamed in full as CSV or newline-delimited JSON given
+// "?format=csv" or "?format=ndjson".
+func (s *server) v1SearchSales(r *http.Request, _ map[string]string) (interface{}, int, error) {
+	params := r.URL.Query()
+	q, err := salesQueryFromParams(params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	format := params.Get("format")
+	switch format {
+	case "", "json":
+		if q.Limit == 0 {
+			q.Limit = defaultSalesLimit
+		}
+
+		if q.Limit > maxSalesLimit {
+			return nil, 0, ParamError{Name: "limit", Value: params.Get("limit")}
+		}
+
+		page := SalesPage{Sales: []StoredSale{}, Offset: q.Offset, Limit: q.Limit}
+		err := QuerySales(s.db, q, func(sale StoredSale) error {
+			page.Sales = append(page.Sales, sale)
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(page.Sales) == q.Limit {
+			next := r.URL.Query()
+			next.Set("offset", strconv.Itoa(q.Offset+q.Limit))
+			next.Set("limit", strconv.Itoa(q.Limit))
+			page.Next = r.URL.Path + "?" + next.Encode()
+		}
+
+		return page, http.StatusOK, nil
+	case "csv", "ndjson":
+		return apiStream(func(w http.ResponseWriter) {
+			// the status is only known once the first sale is written
+			var write func(StoredSale) error
+			var wrote bool
+			switch format {
+			case "csv":
+				csvWriter := csv.NewWriter(w)
+				defer csvWriter.Flush()
+				write = func(sale StoredSale) error {
+					if !wrote {
+						w.Header().Add("Content-Type", "text/csv")
+						if err := csvWriter.Write(sale.Headers()); err != nil {
+							return err
+						}
+					}
+					return csvWriter.Write(sale.ToSlice())
+				}
+			case "ndjson":
+				enc := json.NewEncoder(w)
+				write = func(sale StoredSale) error {
+					if !wrote {
+						w.Header().Add("Content-Type", "application/x-ndjson")
+					}
+					return enc.Encode(sale)
+				}
+			}
+
+			err := QuerySales(s.db, q, func(sale StoredSale) error {
+				err := write(sale)
+				wrote = true
+				return err
+			})
+			if err != nil && !wrote {
+				replyAPIErr(w, err)
+			}
+		}), http.StatusOK, nil
+	}
+
+	return nil, 0, ParamError{Name: "format", Value: format}
+}
+
+// LookupStatistics holds the statistics of a lookup, without its sales.
+type LookupStatistics struct {
+	Address      *Address             `json:"address"`
+	SquareMeters SquareMeterPrices    `json:"sqmeters"`
+	Floors       []FloorPremium       `json:"floors,omitempty"`
+	Energy       *EnergyAnalysis      `json:"energy,omitempty"`
+	Estimate     *ValueEstimate       `json:"estimate,omitempty"`
+	Valuation    *ValuationComparison `json:"valuation,omitempty"`
+	Listings     *ListingComparison   `json:"listings,omitempty"`
+	Spread       *AskingSpread        `json:"spread,omitempty"`
+	Warnings     []Warning            `json:"warnings,omitempty"`
+}
+
+func (s *server) v1Statistics(r *http.Request, _ map[string]string) (interface{}, int, error) {
+	req, err := lookupRequestFromQuery(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := s.lookup(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return LookupStatistics{
+		Address:      resp.Addrs[resp.PrimaryIndex],
+		SquareMeters: resp.SquareMeters,
+		Floors:       resp.Floors,
+		Energy:       resp.Energy,
+		Estimate:     resp.Estimate,
+		Valuation:    resp.Valuation,
+		Listings:     resp.Listings,
+		Spread:       resp.Spread,
+		Warnings:     resp.Warnings,
+	}, http.StatusOK, nil
+}
+
+func (s *server) v1Lookup(r *http.Request, _ map[string]string) (interface{}, int, error) {
+	req, err := lookupRequestFromQuery(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := s.lookup(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return resp, http.StatusOK, nil
+}
+
+func (s *server) v1CreateLookup(r *http.Request, _ map[string]string) (interface{}, int, error) {
+	var req LookupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, 0, bodyError{err}
+	}
+
+	if req.Query == "" {
+		return nil, 0, ParamError{Name: "q"}
+	}
+
+	resp, err := s.lookup(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sl, err := SaveLookup(s.db, req, resp)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out := sl.JSON(true)
+	out.Token = sl.Token
+	return out, http.StatusCreated, nil
+}
+
+func (s *server) v1GetLookup(_ *http.Request, vars map[string]string) (interface{}, int, error) {
+	sl, err := SavedLookupByID(s.db, vars["id"])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return sl.JSON(true), http.StatusOK, nil
+}
+
+func (s *server) v1DeleteLookup(r *http.Request, vars map[string]string) (interface{}, int, error) {
+	sl, err := SavedLookupByID(s.db, vars["id"])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := DeleteSavedLookup(s.db, sl.ID, r.URL.Query().Get("token")); err != nil {
+		return nil, 0, err
+	}
+
+	return sl.JSON(false), http.StatusOK, nil
+}
+
+func (s *server) v1AreaReports(r *http.Request, _ map[string]string) (interface{}, int, error) {
+	params := r.URL.Query()
+	for _, kind := range []string{AreaZip, AreaMunicipality} {
+		var codes []string
+		for _, v := range params[kind] {
+			for _, code := range strings.Split(v, ",") {
+				if code != "" {
+					codes = append(codes, code)
+				}
+			}
+		}
+
+		if len(codes) == 0 {
+			continue
+		}
+
+		reports, err := AreaReports(s.db, kind, codes, time.Now())
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return reports, http.StatusOK, nil
+	}
+
+	return nil, 0, ParamError{Name: AreaZip + " or " + AreaMunicipality}
+}
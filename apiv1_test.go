@@ -0,0 +1,289 @@
+package hjem
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateOpenAPI = flag.Bool("update-openapi", false, "write the generated OpenAPI document to openapi.json")
+
+// TestOpenAPIDocument ensures that openapi.json matches the document
+// generated from the routes of the v1 API. Regenerate it with
+// "go test -run TestOpenAPIDocument -update-openapi".
+func TestOpenAPIDocument(t *testing.T) {
+	generated, err := json.MarshalIndent(OpenAPI(), "", "  ")
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+	generated = append(generated, '\n')
+
+	if *updateOpenAPI {
+		if err := ioutil.WriteFile("openapi.json", generated, 0644); err != nil {
+			t.Fatalf("received unexpected error: %s", err)
+		}
+	}
+
+	committed, err := ioutil.ReadFile("openapi.json")
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	if !bytes.Equal(generated, committed) {
+		t.Fatalf("openapi.json is out of date, regenerate it with: go test -run TestOpenAPIDocument -update-openapi")
+	}
+}
+
+// validateSchema checks that v, as decoded from JSON, is valid according to
+// the subset of JSON schema which OpenAPI generates.
+func validateSchema(doc map[string]interface{}, schema map[string]interface{}, v interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		return validateSchema(doc, schemas[name].(map[string]interface{}), v, path)
+	}
+
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		for _, s := range oneOf {
+			if validateSchema(doc, s.(map[string]interface{}), v, path) == nil {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%s: %v matches none of %v", path, v, oneOf)
+	}
+
+	types := map[string]bool{}
+	switch t := schema["type"].(type) {
+	case nil:
+		return nil
+	case string:
+		types[t] = true
+	case []interface{}:
+		for _, s := range t {
+			types[s.(string)] = true
+		}
+	}
+
+	var typ string
+	switch v := v.(type) {
+	case nil:
+		typ = "null"
+	case bool:
+		typ = "boolean"
+	case string:
+		typ = "string"
+	case float64:
+		typ = "number"
+		if v == float64(int64(v)) && types["integer"] {
+			typ = "integer"
+		}
+	case []interface{}:
+		typ = "array"
+		items := schema["items"].(map[string]interface{})
+		for i, item := range v {
+			if err := validateSchema(doc, items, item, path+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		typ = "object"
+		props, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		if props == nil && additional == nil {
+			// a free-form object
+			break
+		}
+
+		for k, item := range v {
+			s, ok := props[k].(map[string]interface{})
+			if !ok {
+				s = additional
+			}
+
+			if s == nil {
+				return fmt.Errorf("%s: unexpected property %s", path, k)
+			}
+
+			if err := validateSchema(doc, s, item, path+"/"+k); err != nil {
+				return err
+			}
+		}
+
+		required, _ := schema["required"].([]interface{})
+		for _, k := range required {
+			if _, ok := v[k.(string)]; !ok {
+				return fmt.Errorf("%s: missing property %s", path, k)
+			}
+		}
+	}
+
+	if !types[typ] {
+		return fmt.Errorf("%s: unexpected type %s (expected: %v)", path, typ, schema["type"])
+	}
+
+	return nil
+}
+
+func TestAPIV1(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := []*Address{
+				{DawaID: "Vej 1, 1000 By", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+				{DawaID: "Vej 2, 1000 By", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+			}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+
+			bc := fakeBoligaCacher{sales: map[uint][]Sale{
+				addrs[1].ID: {{AddrID: addrs[1].ID, AmountDKK: 2000000, Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}},
+			}}
+			s := &server{db: db, dc: fakeDawaCacher{addrs: addrs}, bc: bc}
+			routes := s.Routes()
+
+			// the document is round-tripped to get the types of decoded JSON
+			var doc map[string]interface{}
+			b, _ := json.Marshal(OpenAPI())
+			json.Unmarshal(b, &doc)
+			paths := doc["paths"].(map[string]interface{})
+
+			// check performs a request of the operation at path, and
+			// validates the body of its reply against the schema of the
+			// operation
+			check := func(method, path, url, body string, sc int) map[string]interface{} {
+				w := httptest.NewRecorder()
+				routes.ServeHTTP(w, httptest.NewRequest(method, apiV1Prefix+url, strings.NewReader(body)))
+				if w.Code != sc {
+					t.Fatalf("unexpected status code of %s %s: %d (expected: %d): %s", method, url, w.Code, sc, w.Body)
+				}
+
+				op := paths[path].(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+				resp, ok := op["responses"].(map[string]interface{})[strconv.Itoa(sc)].(map[string]interface{})
+				if !ok {
+					resp = op["responses"].(map[string]interface{})["default"].(map[string]interface{})
+				}
+				schema := resp["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+
+				var out interface{}
+				if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+					t.Fatalf("received unexpected error: %s", err)
+				}
+
+				if err := validateSchema(doc, schema, out, ""); err != nil {
+					t.Fatalf("reply of %s %s does not match the schema: %s", method, url, err)
+				}
+
+				obj, _ := out.(map[string]interface{})
+				return obj
+			}
+
+			out := check("GET", "/addresses", "/addresses?q=Vej", "", http.StatusOK)
+			if len(out["addresses"].([]interface{})) != 1 {
+				t.Fatalf("unexpected addresses: %v", out)
+			}
+
+			out = check("GET", "/sales", "/sales?q=Vej+1&range=500", "", http.StatusOK)
+			if len(out["sales"].([]interface{})) != 1 || len(out["addresses"].([]interface{})) != 2 {
+				t.Fatalf("unexpected sales: %v", out)
+			}
+
+			check("GET", "/sales/search", "/sales/search?zip=1000", "", http.StatusOK)
+
+			check("GET", "/statistics", "/statistics?q=Vej+1&range=500", "", http.StatusOK)
+			check("GET", "/lookups", "/lookups?q=Vej+1&range=500&filter_below_std=1", "", http.StatusOK)
+
+			out = check("POST", "/lookups", "/lookups", `{"q": "Vej 1", "ranges": [500]}`, http.StatusCreated)
+			id, token := out["id"].(string), out["token"].(string)
+
+			out = check("GET", "/lookups/{id}", "/lookups/"+id, "", http.StatusOK)
+			if out["result"] == nil {
+				t.Fatalf("unexpected saved lookup without result: %v", out)
+			}
+
+			// only the creator of a saved lookup may delete it
+			for _, tc := range []struct {
+				token string
+				sc    int
+			}{{"", http.StatusBadRequest}, {"wrong", http.StatusNotFound}} {
+				w := httptest.NewRecorder()
+				routes.ServeHTTP(w, httptest.NewRequest("DELETE", apiV1Prefix+"/lookups/"+id+"?token="+tc.token, nil))
+				if w.Code != tc.sc {
+					t.Fatalf("unexpected status code of delete with token %q: %d (expected: %d)", tc.token, w.Code, tc.sc)
+				}
+			}
+
+			check("DELETE", "/lookups/{id}", "/lookups/"+id+"?token="+token, "", http.StatusOK)
+			check("GET", "/areas", "/areas?zip=1000", "", http.StatusOK)
+			check("GET", "/openapi.json", "/openapi.json", "", http.StatusOK)
+
+			tests := []struct {
+				name   string
+				method string
+				url    string
+				body   string
+				sc     int
+				code   string
+			}{
+				{name: "missing query", method: "GET", url: "/lookups?range=500", sc: http.StatusBadRequest, code: CodeMissingParameter},
+				{name: "invalid range", method: "GET", url: "/sales?q=Vej+1&range=far", sc: http.StatusBadRequest, code: CodeInvalidParameter},
+				{name: "invalid body", method: "POST", url: "/lookups", body: "{", sc: http.StatusBadRequest, code: CodeInvalidBody},
+				{name: "oversized body", method: "POST", url: "/lookups", body: `{"q": "` + strings.Repeat("a", maxBytesLimit) + `"}`, sc: http.StatusBadRequest, code: CodeInvalidBody},
+				{name: "unknown lookup", method: "GET", url: "/lookups/" + id, sc: http.StatusNotFound, code: CodeNotFound},
+				{name: "missing area", method: "GET", url: "/areas", sc: http.StatusBadRequest, code: CodeMissingParameter},
+				{name: "invalid zip", method: "GET", url: "/areas?zip=abc", sc: http.StatusBadRequest, code: CodeInvalidParameter},
+				{name: "unknown method", method: "PUT", url: "/lookups", sc: http.StatusMethodNotAllowed, code: CodeMethodNotAllowed},
+				{name: "unknown path", method: "GET", url: "/unknown", sc: http.StatusNotFound, code: CodeNotFound},
+			}
+
+			for _, tc := range tests {
+				t.Run(tc.name, func(t *testing.T) {
+					w := httptest.NewRecorder()
+					routes.ServeHTTP(w, httptest.NewRequest(tc.method, apiV1Prefix+tc.url, strings.NewReader(tc.body)))
+					if w.Code != tc.sc {
+						t.Fatalf("unexpected status code: %d (expected: %d): %s", w.Code, tc.sc, w.Body)
+					}
+
+					var out APIErrorResponse
+					if err := json.NewDecoder(w.Body).Decode(&out); err != nil {
+						t.Fatalf("received unexpected error: %s", err)
+					}
+
+					if out.Error.Code != tc.code || out.Error.Message == "" {
+						t.Fatalf("unexpected error: %+v (expected code: %s)", out.Error, tc.code)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestAPIErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+		sc   int
+	}{
+		{ParamError{Name: "q"}, CodeMissingParameter, http.StatusBadRequest},
+		{ParamError{Name: "range", Value: "far"}, CodeInvalidParameter, http.StatusBadRequest},
+		{ErrNonUniqueAddr, CodeNonUniqueAddress, http.StatusBadRequest},
+		{fmt.Errorf("Vej 1: %w", ErrNoAddr), CodeAddressNotFound, http.StatusNotFound},
+		{AddrError{Addr: &Address{}, Err: fmt.Errorf("timeout")}, CodeUpstream, http.StatusBadGateway},
+		{fmt.Errorf("disk full"), CodeInternal, http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		code, sc := apiErrorStatus(tc.err)
+		if code != tc.code || sc != tc.sc {
+			t.Fatalf("unexpected code of %v: %s, %d (expected: %s, %d)", tc.err, code, sc, tc.code, tc.sc)
+		}
+	}
+}
@@ -1,27 +1,309 @@
 package main
 
 import (
+	"encoding/csv"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/tpanum/hjem"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 func main() {
-	dbFile := flag.String("db-file", "hjem.db", "file for the database. default: hjem.db.")
+	dbFile := flag.String("db-file", "hjem.db", "file for the database when using sqlite. default: hjem.db.")
+	dbDriver := flag.String("db-driver", hjem.DriverSQLite, "database driver to use: sqlite or postgres. default: sqlite.")
+	dbDSN := flag.String("db-dsn", "", "data source name of the database, e.g. \"host=localhost user=hjem dbname=hjem\" for postgres. default: -db-file.")
 	port := flag.Int("port", 8080, "port to use for the webserver. default: 8080")
+	fuzzyTTL := flag.Duration("dawa-fuzzy-ttl", hjem.DawaFuzzySearch{}.MaxAge(), "how long address searches are cached.")
+	nearbyTTL := flag.Duration("dawa-nearby-ttl", hjem.DawaNearbySearch{}.MaxAge(), "how long nearby address searches are cached.")
+	bbrFile := flag.String("bbr-file", "", "file with an extract of BBR records, one JSON record per line, to enrich addresses with.")
+	bbrEndpoint := flag.String("bbr-endpoint", "", "URL of a BBR-compatible endpoint to enrich addresses with, used when -bbr-file is not given.")
+	watchInterval := flag.Duration("watch-interval", time.Hour, "how often watches are checked for new sales while serving, 0 disables checks.")
+	smtpAddr := flag.String("smtp-addr", "", "address of the SMTP server to mail watch notifications through, e.g. localhost:25.")
+	smtpFrom := flag.String("smtp-from", "hjem@localhost", "sender of watch notification mails.")
+	smtpUser := flag.String("smtp-user", "", "username for the SMTP server, if it requires authentication.")
+	smtpPassword := flag.String("smtp-password", "", "password for the SMTP server.")
+	mapTiles := flag.String("map-tiles", "", "URL template of the tile server to draw maps on, e.g. \"http://localhost:8081/{z}/{x}/{y}.png\". default: no tiles.")
+	liveListings := flag.Bool("live-listings", false, "fetch the properties for sale near an address from Boliga during lookups, instead of using those collected by fetch-listings.")
+	mapAttribution := flag.String("map-attribution", "", "attribution shown on maps, as required by the tiles of -map-tiles.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [serve|prune-cache|dedupe-sales|import-valuations <file>|fetch-listings <zipcode>...|check-watches|area-report <zip|municipality> <code>...|link-buildings|migrate]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	db, err := gorm.Open(sqlite.Open(*dbFile), &gorm.Config{})
+	dsn := *dbDSN
+	if dsn == "" {
+		dsn = *dbFile
+	}
+
+	db, err := hjem.OpenDB(*dbDriver, dsn)
 	if err != nil {
-		panic("failed to connect database")
+		panic(fmt.Sprintf("failed to connect database: %s", err))
+	}
+
+	ttls := map[string]time.Duration{
+		hjem.DawaKindFuzzy:  *fuzzyTTL,
+		hjem.DawaKindNearby: *nearbyTTL,
+	}
+
+	cmd := flag.Arg(0)
+	if cmd == "migrate" {
+		if err := migrate(db, flag.Args()[1:]); err != nil {
+			fmt.Println("Error migrating database:", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if err := hjem.Migrate(db); err != nil {
+		fmt.Println("Error migrating database:", err)
+		os.Exit(1)
+	}
+
+	notifiers := []hjem.Notifier{hjem.WebhookNotifier{}}
+	if *smtpAddr != "" {
+		n := hjem.SMTPNotifier{Addr: *smtpAddr, From: *smtpFrom}
+		if *smtpUser != "" {
+			host, _, _ := net.SplitHostPort(*smtpAddr)
+			n.Auth = smtp.PlainAuth("", *smtpUser, *smtpPassword, host)
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	switch cmd {
+	case "", "serve":
+		opts := []hjem.ServerOption{}
+		for kind, ttl := range ttls {
+			opts = append(opts, hjem.WithDawaTTL(kind, ttl))
+		}
+
+		bbr, err := bbrSource(*bbrFile, *bbrEndpoint)
+		if err != nil {
+			fmt.Println("Error reading BBR file:", err)
+			os.Exit(1)
+		}
+
+		if bbr != nil {
+			opts = append(opts, hjem.WithBBR(bbr))
+		}
+
+		if *liveListings {
+			opts = append(opts, hjem.WithLiveListings())
+		}
+
+		if *mapTiles != "" {
+			opts = append(opts, hjem.WithMapTiles(hjem.MapTiles{URL: *mapTiles, Attribution: *mapAttribution}))
+		}
+
+		s := hjem.NewServer(db, opts...)
+		if *watchInterval > 0 {
+			go s.WatchChecker(notifiers...).Run(*watchInterval, nil)
+		}
+
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), s.Routes()); err != nil {
+			fmt.Println("Error starting server:", err)
+		}
+	case "prune-cache":
+		n, err := hjem.NewDawaCacher(db, ttls).Prune()
+		if err != nil {
+			fmt.Println("Error pruning cache:", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Pruned %d cached address queries\n", n)
+	case "import-valuations":
+		if err := importValuations(db, hjem.NewDawaCacher(db, ttls), flag.Arg(1)); err != nil {
+			fmt.Println("Error importing valuations:", err)
+			os.Exit(1)
+		}
+	case "fetch-listings":
+		if err := fetchListings(db, flag.Args()[1:]); err != nil {
+			fmt.Println("Error fetching listings:", err)
+			os.Exit(1)
+		}
+	case "check-watches":
+		wc := hjem.NewWatchChecker(db, hjem.NewDawaCacher(db, ttls), hjem.NewBoligaCacher(db, 4), notifiers...)
+		if err := wc.CheckAll(); err != nil {
+			fmt.Println("Error checking watches:", err)
+			os.Exit(1)
+		}
+	case "area-report":
+		if err := areaReport(db, flag.Args()[1:]); err != nil {
+			fmt.Println("Error reporting on areas:", err)
+			os.Exit(1)
+		}
+	case "link-buildings":
+		n, addrErrs, err := hjem.LinkStoredBuildings(db, hjem.DawaAccessAddress)
+		for _, ae := range addrErrs {
+			fmt.Println("Failed to find the building of", ae)
+		}
+		if err != nil {
+			fmt.Println("Error linking buildings:", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Linked %d addresses to their buildings\n", n)
+	case "dedupe-sales":
+		n, err := hjem.DedupeSales(db)
+		if err != nil {
+			fmt.Println("Error deduplicating sales:", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Removed %d duplicated sales\n", n)
+	default:
+		fmt.Printf("Unknown command: %s\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// migrate runs the migrate command: "migrate [up]" applies every pending
+// migration, "migrate down <version>" reverts migrations until the schema
+// is at the given version, and "migrate status" lists the migrations.
+func migrate(db *gorm.DB, args []string) error {
+	sub := "up"
+	if len(args) > 0 {
+		sub = args[0]
+	}
+
+	switch sub {
+	case "up":
+		return hjem.Migrate(db)
+	case "down":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: migrate down <version>")
+		}
+
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+
+		return hjem.MigrateTo(db, version)
+	case "status":
+		statuses, err := hjem.MigrationStatuses(db)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			fmt.Println(s)
+		}
+
+		return nil
 	}
 
-	s := hjem.NewServer(db)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), s.Routes()); err != nil {
-		fmt.Println("Error starting server:", err)
+	return fmt.Errorf("unknown migrate command: %s", sub)
+}
+
+// bbrSource returns the source of BBR records configured by the flags, or
+// nil if none is configured.
+func bbrSource(path, endpoint string) (hjem.BBRSource, error) {
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		bbr, err := hjem.ReadBBRFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		return bbr, nil
 	}
+
+	if endpoint != "" {
+		return hjem.BBRClient{Endpoint: endpoint}, nil
+	}
+
+	return nil, nil
+}
+
+// importValuations imports the public property valuations of a valuation
+// file, see hjem.ReadValuations for its format.
+func importValuations(db *gorm.DB, dc hjem.DawaCacher, path string) error {
+	if path == "" {
+		return fmt.Errorf("usage: import-valuations <file>")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := hjem.ReadValuations(f)
+	if err != nil {
+		return err
+	}
+
+	n, errs := hjem.ImportValuations(db, records, hjem.AddressResolver(db, dc))
+	for _, err := range errs {
+		fmt.Println("Skipped valuation:", err)
+	}
+	fmt.Printf("Imported %d of %d valuations\n", n, len(records))
+
+	return nil
+}
+
+// areaReport writes the reports of the postal codes or municipalities of
+// args, e.g. "zip 2000 2100", to stdout as CSV.
+func areaReport(db *gorm.DB, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: area-report <zip|municipality> <code>...")
+	}
+
+	reports, err := hjem.AreaReports(db, args[0], args[1:], time.Now())
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write(hjem.AreaReportHeaders())
+	for _, r := range reports {
+		w.WriteAll(r.Rows())
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// fetchListings collects the properties for sale in each of the postal
+// codes, unless they were collected within the last day.
+func fetchListings(db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fetch-listings <zipcode>...")
+	}
+
+	zips := make([]int, len(args))
+	for i, arg := range args {
+		zip, err := strconv.Atoi(arg)
+		if err != nil {
+			return err
+		}
+		zips[i] = zip
+	}
+
+	listings, zipErrs, err := hjem.NewListingCacher(db).Listings(zips)
+	if err != nil {
+		return err
+	}
+
+	for _, ze := range zipErrs {
+		fmt.Println("Failed to fetch listings of", ze)
+	}
+	fmt.Printf("%d properties for sale\n", len(listings))
+
+	return nil
 }
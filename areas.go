@@ -0,0 +1,338 @@
+package hjem
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of areas of an area report.
+const (
+	AreaZip          = "zip"
+	AreaMunicipality = "municipality"
+)
+
+var (
+	ErrUnknownAreaKind = errors.New("unknown area kind, use zip or municipality")
+)
+
+// AreaStats summarizes the sales of an area in a year. Change is the
+// relative change of the median price per square meter since the previous
+// year, if both years have sales of a known size.
+type AreaStats struct {
+	Sales                  int      `json:"sales"`
+	VolumeDKK              int64    `json:"volume_dkk"`
+	MedianSquareMeterPrice int      `json:"median_sqmeter_price,omitempty"`
+	Change                 *float64 `json:"yoy_change,omitempty"`
+}
+
+// AreaKindStats summarizes the sales of a property type.
+type AreaKindStats struct {
+	Kind string `json:"property_type"`
+	AreaStats
+}
+
+// AreaYear summarizes the sales of an area in Year, both in total and by
+// property type. DaysOnMarket is the median time on the market of the
+// listings of the area taken down during the year, if any are known.
+type AreaYear struct {
+	Year int `json:"year"`
+	AreaStats
+	Kinds        []AreaKindStats `json:"kinds"`
+	Listings     int             `json:"listings,omitempty"`
+	DaysOnMarket *int            `json:"median_days_on_market,omitempty"`
+}
+
+// AreaReport summarizes the stored sales of the postal code or
+// municipality given by Code, by year.
+type AreaReport struct {
+	AreaKind string     `json:"area_kind"`
+	Code     string     `json:"code"`
+	Years    []AreaYear `json:"years"`
+}
+
+// areaSales collects the sales of an area in a year.
+type areaSales struct {
+	volume int64
+	n      int
+	prices []float64
+}
+
+// add counts the sale s, priced by the building size of its address at the
+// time of the sale.
+func (as *areaSales) add(s StoredSale, history AttributeHistory) {
+	as.n += 1
+	as.volume += int64(s.Amount)
+	if size := buildingSizeAt(s.Address, history, s.When); size > 0 {
+		as.prices = append(as.prices, float64(s.Amount)/float64(size))
+	}
+}
+
+func (as *areaSales) stats(prev *areaSales) AreaStats {
+	st := AreaStats{
+		Sales:     as.n,
+		VolumeDKK: as.volume,
+	}
+
+	if len(as.prices) == 0 {
+		return st
+	}
+
+	m := median(as.prices)
+	st.MedianSquareMeterPrice = int(m)
+	if prev != nil && len(prev.prices) > 0 {
+		pm := median(prev.prices)
+		change := (m - pm) / pm
+		st.Change = &change
+	}
+
+	return st
+}
+
+// AreaReports returns a report of each of the postal codes or
+// municipalities of codes, depending on the kind of area, computed from
+// the stored sales and listings.
+func AreaReports(db *gorm.DB, kind string, codes []string, now time.Time) ([]AreaReport, error) {
+	var reports []AreaReport
+	for _, code := range codes {
+		r, err := areaReport(db, kind, code, now)
+		if err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, *r)
+	}
+
+	return reports, nil
+}
+
+func areaReport(db *gorm.DB, kind, code string, now time.Time) (*AreaReport, error) {
+	var q SalesQuery
+	switch kind {
+	case AreaZip:
+		zip, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, ParamError{Name: AreaZip, Value: code}
+		}
+		q.ZipFrom, q.ZipTo = zip, zip
+	case AreaMunicipality:
+		q.Municipalities = []string{code}
+	default:
+		return nil, ErrUnknownAreaKind
+	}
+
+	var sales []StoredSale
+	var addrs []*Address
+	seen := map[uint]bool{}
+	err := QuerySales(db, q, func(s StoredSale) error {
+		sales = append(sales, s)
+		if !seen[s.Address.ID] {
+			seen[s.Address.ID] = true
+			addrs = append(addrs, s.Address)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := attributeHistory(db, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	total := map[int]*areaSales{}
+	byKind := map[int]map[PropertyType]*areaSales{}
+	for _, s := range sales {
+		y := s.When.Year()
+		if total[y] == nil {
+			total[y] = &areaSales{}
+			byKind[y] = map[PropertyType]*areaSales{}
+		}
+		total[y].add(s, history)
+
+		k := s.Address.BoligaPropertyKind
+		if PropertyToName[k] == "" {
+			continue
+		}
+
+		if byKind[y][k] == nil {
+			byKind[y][k] = &areaSales{}
+		}
+		byKind[y][k].add(s, history)
+	}
+
+	days, err := areaDaysOnMarket(db, kind, code, now)
+	if err != nil {
+		return nil, err
+	}
+
+	years := map[int]bool{}
+	for y := range total {
+		years[y] = true
+	}
+
+	for y := range days {
+		years[y] = true
+	}
+
+	report := AreaReport{
+		AreaKind: kind,
+		Code:     code,
+		Years:    []AreaYear{},
+	}
+	for y := range years {
+		ay := AreaYear{Year: y, Kinds: []AreaKindStats{}}
+		if total[y] != nil {
+			ay.AreaStats = total[y].stats(total[y-1])
+		}
+
+		for k, as := range byKind[y] {
+			ay.Kinds = append(ay.Kinds, AreaKindStats{
+				Kind:      PropertyToName[k],
+				AreaStats: as.stats(byKind[y-1][k]),
+			})
+		}
+		sort.Slice(ay.Kinds, func(i, j int) bool {
+			return ay.Kinds[i].Kind < ay.Kinds[j].Kind
+		})
+
+		if d := days[y]; len(d) > 0 {
+			m := int(median(d))
+			ay.Listings, ay.DaysOnMarket = len(d), &m
+		}
+
+		report.Years = append(report.Years, ay)
+	}
+	sort.Slice(report.Years, func(i, j int) bool {
+		return report.Years[i].Year < report.Years[j].Year
+	})
+
+	return &report, nil
+}
+
+// areaDaysOnMarket returns the days on the market of the listings of an
+// area which have been taken down, by the year they were taken down.
+// Listings only know their postal code, so the listings of a municipality
+// are those of the addresses stored within it.
+func areaDaysOnMarket(db *gorm.DB, kind, code string, now time.Time) (map[int][]float64, error) {
+	var zips []int
+	texts := map[string]bool{}
+	switch kind {
+	case AreaZip:
+		zip, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, ParamError{Name: AreaZip, Value: code}
+		}
+		zips = []int{zip}
+	case AreaMunicipality:
+		var addrs []Address
+		err := db.Select("street_name", "street_number", "floor", "door", "postal_code").
+			Where("municipality_code = ?", code).
+			Find(&addrs).Error
+		if err != nil {
+			return nil, err
+		}
+
+		seen := map[int]bool{}
+		for _, a := range addrs {
+			texts[a.Short()+"/"+a.PostalCode] = true
+
+			zip, err := strconv.Atoi(a.PostalCode)
+			if err != nil || seen[zip] {
+				continue
+			}
+
+			seen[zip] = true
+			zips = append(zips, zip)
+		}
+	}
+
+	days := map[int][]float64{}
+	err := inBatches(len(zips), maxBatchSize(db), func(start, end int) error {
+		var listings []Listing
+		err := db.Where("zip_code IN ? AND active = ?", zips[start:end], false).
+			Find(&listings).Error
+		if err != nil {
+			return err
+		}
+
+		for _, l := range listings {
+			if kind == AreaMunicipality && !texts[l.Address+"/"+strconv.Itoa(l.ZipCode)] {
+				continue
+			}
+
+			y := l.LastSeenAt.Year()
+			days[y] = append(days[y], float64(l.DaysOnMarket(now)))
+		}
+
+		return nil
+	})
+
+	return days, err
+}
+
+// AreaReportHeaders returns the columns of the CSV rows of area reports.
+func AreaReportHeaders() []string {
+	return []string{
+		"area_kind",
+		"code",
+		"year",
+		"property_type",
+		"sales",
+		"volume_dkk",
+		"median_sqmeter_price",
+		"yoy_change",
+		"listings",
+		"median_days_on_market",
+	}
+}
+
+// Rows returns a CSV row of each year of the report, followed by a row of
+// each property type sold in the year. The property type of the yearly
+// rows is "all".
+func (r AreaReport) Rows() [][]string {
+	statsRow := func(y int, kind string, st AreaStats) []string {
+		row := []string{
+			r.AreaKind,
+			r.Code,
+			strconv.Itoa(y),
+			kind,
+			strconv.Itoa(st.Sales),
+			strconv.FormatInt(st.VolumeDKK, 10),
+			"",
+			"",
+		}
+
+		if st.MedianSquareMeterPrice > 0 {
+			row[6] = strconv.Itoa(st.MedianSquareMeterPrice)
+		}
+
+		if st.Change != nil {
+			row[7] = fmt.Sprintf("%.4f", *st.Change)
+		}
+
+		return row
+	}
+
+	var rows [][]string
+	for _, ay := range r.Years {
+		row := statsRow(ay.Year, "all", ay.AreaStats)
+		var listings, days string
+		if ay.DaysOnMarket != nil {
+			listings, days = strconv.Itoa(ay.Listings), strconv.Itoa(*ay.DaysOnMarket)
+		}
+		rows = append(rows, append(row, listings, days))
+
+		for _, ks := range ay.Kinds {
+			rows = append(rows, append(statsRow(ay.Year, ks.Kind, ks.AreaStats), "", ""))
+		}
+	}
+
+	return rows
+}
@@ -0,0 +1,110 @@
+package hjem
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAreaReports(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			seedSales(t, db)
+
+			listings := []Listing{
+				{BoligaID: 1, Address: "Vej 1", ZipCode: 2000, ListedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), LastSeenAt: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+				{BoligaID: 2, Address: "Vej 9", ZipCode: 2000, ListedAt: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), LastSeenAt: time.Date(2020, 2, 21, 0, 0, 0, 0, time.UTC)},
+				{BoligaID: 3, Address: "Vej 2", ZipCode: 2000, ListedAt: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), LastSeenAt: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), Active: true},
+			}
+			if err := db.Create(&listings).Error; err != nil {
+				t.Fatalf("unable to create listings: %s", err)
+			}
+
+			now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+			zips, err := AreaReports(db, AreaZip, []string{"2000", "2500"}, now)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(zips) != 2 || len(zips[0].Years) != 3 || zips[1].Code != "2500" {
+				t.Fatalf("unexpected reports: %+v", zips)
+			}
+
+			first, y := zips[0].Years[0], zips[0].Years[1]
+			if first.Change != nil {
+				t.Fatalf("unexpected change of first year: %f", *first.Change)
+			}
+
+			if y.Year != 2019 || y.Sales != 2 || y.VolumeDKK != 6057000 || y.MedianSquareMeterPrice != 43745 {
+				t.Fatalf("unexpected year: %+v", y)
+			}
+
+			expected := (43745 - (20180+4036000.0/60)/2) / ((20180 + 4036000.0/60) / 2)
+			if y.Change == nil || math.Abs(*y.Change-expected) > 1e-9 {
+				t.Fatalf("unexpected change: %v (expected: %f)", y.Change, expected)
+			}
+
+			if len(y.Kinds) != 2 || y.Kinds[1].Kind != "house" || y.Kinds[1].MedianSquareMeterPrice != 20190 || y.Kinds[1].Sales != 1 {
+				t.Fatalf("unexpected property types: %+v", y.Kinds)
+			}
+
+			last := zips[0].Years[2]
+			if last.DaysOnMarket == nil || *last.DaysOnMarket != 40 || last.Listings != 2 {
+				t.Fatalf("unexpected days on market: %v of %d listings (expected: 40 of 2)", last.DaysOnMarket, last.Listings)
+			}
+
+			if y.DaysOnMarket != nil {
+				t.Fatalf("unexpected days on market of year without listings: %d", *y.DaysOnMarket)
+			}
+
+			munis, err := AreaReports(db, AreaMunicipality, []string{"0147"}, now)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			last = munis[0].Years[2]
+			if last.Sales != 2 || last.DaysOnMarket == nil || *last.DaysOnMarket != 60 || last.Listings != 1 {
+				t.Fatalf("unexpected year of municipality: %+v", last)
+			}
+
+			rows := zips[1].Rows()
+			if len(rows) != 6 || rows[0][3] != "all" || rows[0][6] != "40360" || rows[1][3] != "house" {
+				t.Fatalf("unexpected rows: %v", rows)
+			}
+
+			if _, err := AreaReports(db, "street", []string{"Vej"}, now); err != ErrUnknownAreaKind {
+				t.Fatalf("unexpected error: %v (expected: %s)", err, ErrUnknownAreaKind)
+			}
+		})
+	}
+}
+
+func TestAreaReportsBuildingSizeAtSale(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := seedSales(t, db)
+
+			// the house of 2500 was extended from 100 to 150 square meters
+			// in 2020
+			obs := []AddressAttributes{
+				{AddrID: addrs[2].ID, ObservedAt: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), BuildingSize: 100},
+				{AddrID: addrs[2].ID, ObservedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), BuildingSize: 150},
+			}
+			if err := db.Create(&obs).Error; err != nil {
+				t.Fatalf("unable to create attributes: %s", err)
+			}
+
+			reports, err := AreaReports(db, AreaZip, []string{"2500"}, time.Now())
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			expected := map[int]int{2018: 60540, 2019: 60570, 2020: 40400}
+			for _, y := range reports[0].Years {
+				if y.MedianSquareMeterPrice != expected[y.Year] {
+					t.Fatalf("unexpected price per square meter of %d: %d (expected: %d)", y.Year, y.MedianSquareMeterPrice, expected[y.Year])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package hjem
+
+import (
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AddressAttributes is an observation of the attributes of an address, as
+// collected from Boliga at ObservedAt.
+type AddressAttributes struct {
+	AddrID              uint      `gorm:"primaryKey;autoIncrement:false"`
+	ObservedAt          time.Time `gorm:"primaryKey"`
+	Kind                PropertyType
+	BuildingSize        int
+	PropertySize        int
+	BasementSize        int
+	Rooms               int
+	BuiltYear           int
+	MonthlyOwnerExpense int
+	EnergyMarking       string
+}
+
+func AttributesOfAddress(a Address) AddressAttributes {
+	return AddressAttributes{
+		AddrID:              a.ID,
+		ObservedAt:          a.BoligaCollectedAt,
+		Kind:                a.BoligaPropertyKind,
+		BuildingSize:        a.BoligaBuildingSize,
+		PropertySize:        a.BoligaPropertySize,
+		BasementSize:        a.BoligaBasementSize,
+		Rooms:               a.BoligaRooms,
+		BuiltYear:           a.BoligaBuiltYear,
+		MonthlyOwnerExpense: a.BoligaMonthlyOwnerExpense,
+		EnergyMarking:       a.BoligaEnergyMarking,
+	}
+}
+
+// SameAs reports whether two observations hold the same attributes,
+// regardless of when they were observed.
+func (a AddressAttributes) SameAs(o AddressAttributes) bool {
+	a.ObservedAt, o.ObservedAt = time.Time{}, time.Time{}
+	return a == o
+}
+
+// AttributeHistory holds the observations of each address, by address id,
+// ordered by the time of observation.
+type AttributeHistory map[uint][]AddressAttributes
+
+// At returns the attributes of an address which were valid at t, i.e. the
+// latest observation made no later than t. Sales preceding the first
+// observation are assumed to have the attributes first observed.
+func (h AttributeHistory) At(addrID uint, t time.Time) *AddressAttributes {
+	obs := h[addrID]
+	if len(obs) == 0 {
+		return nil
+	}
+
+	i := sort.Search(len(obs), func(i int) bool {
+		return obs[i].ObservedAt.After(t)
+	})
+	if i == 0 {
+		return &obs[0]
+	}
+
+	return &obs[i-1]
+}
+
+func attributeHistory(db *gorm.DB, addrs []*Address) (AttributeHistory, error) {
+	ids := make([]uint, len(addrs))
+	for i, a := range addrs {
+		ids[i] = a.ID
+	}
+
+	h := AttributeHistory{}
+	err := inBatches(len(ids), maxBatchSize(db), func(start, end int) error {
+		var obs []AddressAttributes
+		err := db.Where("addr_id IN ?", ids[start:end]).
+			Order("observed_at").
+			Find(&obs).Error
+		if err != nil {
+			return err
+		}
+
+		for _, o := range obs {
+			h[o.AddrID] = append(h[o.AddrID], o)
+		}
+
+		return nil
+	})
+
+	return h, err
+}
@@ -0,0 +1,53 @@
+package hjem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttributeHistoryAt(t *testing.T) {
+	day := func(y int, m time.Month) time.Time {
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	h := AttributeHistory{
+		1: {
+			{AddrID: 1, ObservedAt: day(2015, 1), BuildingSize: 100},
+			{AddrID: 1, ObservedAt: day(2019, 6), BuildingSize: 140},
+		},
+	}
+
+	tt := []struct {
+		name string
+		addr uint
+		at   time.Time
+		size int
+		none bool
+	}{
+		{name: "before first observation", addr: 1, at: day(2010, 1), size: 100},
+		{name: "at first observation", addr: 1, at: day(2015, 1), size: 100},
+		{name: "between observations", addr: 1, at: day(2018, 1), size: 100},
+		{name: "after extension", addr: 1, at: day(2020, 1), size: 140},
+		{name: "unobserved address", addr: 2, at: day(2020, 1), none: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			obs := h.At(tc.addr, tc.at)
+			if tc.none {
+				if obs != nil {
+					t.Fatalf("unexpected observation: %+v", obs)
+				}
+				return
+			}
+
+			if obs == nil {
+				t.Fatalf("expected an observation")
+			}
+
+			if obs.BuildingSize != tc.size {
+				t.Fatalf("unexpected size: %d (expected: %d)", obs.BuildingSize, tc.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package hjem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SourceBoliga = "boliga"
+	SourceBBR    = "bbr"
+
+	bbrMaxAge = 12 * oneMonth
+)
+
+var (
+	ErrNoBBRRecord = errors.New("no BBR record of the address")
+)
+
+// BBRRecord is the building data of an address in BBR (Bygnings- og
+// Boligregistret), identified by the full text of the address.
+type BBRRecord struct {
+	Address        string `json:"address"`
+	BuildingSize   int    `json:"building_size"`
+	BasementSize   int    `json:"basement_size"`
+	Rooms          int    `json:"rooms"`
+	BuiltYear      int    `json:"built_year"`
+	RenovationYear int    `json:"renovation_year"`
+	RoofMaterial   string `json:"roof_material"`
+	WallMaterial   string `json:"wall_material"`
+	HeatingType    string `json:"heating_type"`
+}
+
+// BBRSource looks up the BBR record of an address, and returns
+// ErrNoBBRRecord if the address has none.
+type BBRSource interface {
+	Lookup(*Address) (*BBRRecord, error)
+}
+
+func bbrKey(text string) string {
+	return strings.ToLower(strings.TrimSpace(text))
+}
+
+// BBRFile is a local extract of BBR records, by address.
+type BBRFile map[string]BBRRecord
+
+// ReadBBRFile reads an extract of BBR records, with one JSON encoded
+// BBRRecord per line.
+func ReadBBRFile(r io.Reader) (BBRFile, error) {
+	f := BBRFile{}
+	dec := json.NewDecoder(r)
+	for {
+		var rec BBRRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return f, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		f[bbrKey(rec.Address)] = rec
+	}
+}
+
+func (f BBRFile) Lookup(addr *Address) (*BBRRecord, error) {
+	rec, ok := f[bbrKey(addr.DawaID)]
+	if !ok {
+		return nil, ErrNoBBRRecord
+	}
+
+	return &rec, nil
+}
+
+// BBRClient looks up BBR records from an HTTP endpoint, which is queried
+// as "<Endpoint>?address=<full text of address>" and replies with a JSON
+// encoded BBRRecord, or 404 if the address has none.
+type BBRClient struct {
+	Endpoint string
+}
+
+func (c BBRClient) Lookup(addr *Address) (*BBRRecord, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	q.Set("address", addr.DawaID)
+	u.RawQuery = q.Encode()
+
+	resp, err := DefaultClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNoBBRRecord
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from BBR: %d", resp.StatusCode)
+	}
+
+	var rec BBRRecord
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil {
+		return nil, err
+	}
+
+	return &rec, nil
+}
+
+// bbrEnricher enriches addresses with the BBR records of a source, looking
+// up at most n addresses at a time. Failed lookups are not retried within
+// bbrRetryAfter, such that a failing source neither slows down nor warns
+// about every lookup.
+type bbrEnricher struct {
+	db  *gorm.DB
+	src BBRSource
+	n   int
+
+	m      sync.Mutex
+	failed map[uint]time.Time
+}
+
+const bbrRetryAfter = 10 * time.Minute
+
+func newBBREnricher(db *gorm.DB, src BBRSource, n int) *bbrEnricher {
+	return &bbrEnricher{
+		db:     db,
+		src:    src,
+		n:      n,
+		failed: map[uint]time.Time{},
+	}
+}
+
+// Enrich looks up the BBR records of the addresses which have not been
+// enriched within bbrMaxAge, and stores them. Addresses without a record
+// are stored as enriched, leaving their BBR data empty. Lookups failing
+// otherwise are returned as AddrErrors, and retried at the first call after
+// bbrRetryAfter.
+func (e *bbrEnricher) Enrich(addrs []*Address) ([]AddrError, error) {
+	now := time.Now()
+
+	var lookups []*Address
+	e.m.Lock()
+	for _, addr := range addrs {
+		if !addr.BBRCollectedAt.IsZero() && now.Sub(addr.BBRCollectedAt) < bbrMaxAge {
+			continue
+		}
+
+		if at, ok := e.failed[addr.ID]; ok && now.Sub(at) < bbrRetryAfter {
+			continue
+		}
+
+		lookups = append(lookups, addr)
+	}
+	e.m.Unlock()
+
+	recs := make([]*BBRRecord, len(lookups))
+	errs := make([]error, len(lookups))
+	in := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < e.n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range in {
+				recs[j], errs[j] = e.src.Lookup(lookups[j])
+			}
+		}()
+	}
+
+	for j := range lookups {
+		in <- j
+	}
+	close(in)
+	wg.Wait()
+
+	var addrErrs []AddrError
+	for j, addr := range lookups {
+		rec, err := recs[j], errs[j]
+		if errors.Is(err, ErrNoBBRRecord) {
+			rec, err = &BBRRecord{}, nil
+		}
+
+		if err != nil {
+			e.m.Lock()
+			e.failed[addr.ID] = now
+			e.m.Unlock()
+
+			addrErrs = append(addrErrs, AddrError{Addr: addr, Err: err})
+			continue
+		}
+
+		updated := *addr
+		updated.BBRCollectedAt = now
+		updated.BBRBuildingSize = rec.BuildingSize
+		updated.BBRBasementSize = rec.BasementSize
+		updated.BBRRooms = rec.Rooms
+		updated.BBRBuiltYear = rec.BuiltYear
+		updated.BBRRenovationYear = rec.RenovationYear
+		updated.BBRRoofMaterial = rec.RoofMaterial
+		updated.BBRWallMaterial = rec.WallMaterial
+		updated.BBRHeatingType = rec.HeatingType
+
+		err = e.db.Model(&Address{ID: addr.ID}).
+			Select("BBRCollectedAt", "BBRBuildingSize", "BBRBasementSize", "BBRRooms", "BBRBuiltYear",
+				"BBRRenovationYear", "BBRRoofMaterial", "BBRWallMaterial", "BBRHeatingType").
+			Updates(&updated).Error
+		if err != nil {
+			return nil, err
+		}
+
+		e.m.Lock()
+		delete(e.failed, addr.ID)
+		e.m.Unlock()
+
+		*addr = updated
+	}
+
+	return addrErrs, nil
+}
+
+// ApplyBBR fills the attributes of the address which Boliga left out with
+// the BBR data of the address, and sets the source of each attribute in
+// Sources. The Boliga and BBR values are stored in separate columns, so
+// only the filled copy in memory is changed, and Sources is not stored.
+func (a *Address) ApplyBBR() {
+	a.Sources = map[string]string{}
+
+	fields := []struct {
+		name   string
+		boliga *int
+		bbr    int
+	}{
+		{"building_size", &a.BoligaBuildingSize, a.BBRBuildingSize},
+		{"basement_size", &a.BoligaBasementSize, a.BBRBasementSize},
+		{"rooms", &a.BoligaRooms, a.BBRRooms},
+		{"built_year", &a.BoligaBuiltYear, a.BBRBuiltYear},
+	}
+	for _, f := range fields {
+		switch {
+		case *f.boliga != 0:
+			a.Sources[f.name] = SourceBoliga
+		case f.bbr != 0:
+			*f.boliga = f.bbr
+			a.Sources[f.name] = SourceBBR
+		}
+	}
+
+	bbrOnly := map[string]bool{
+		"renovation_year": a.BBRRenovationYear != 0,
+		"roof_material":   a.BBRRoofMaterial != "",
+		"wall_material":   a.BBRWallMaterial != "",
+		"heating_type":    a.BBRHeatingType != "",
+	}
+	for name, ok := range bbrOnly {
+		if ok {
+			a.Sources[name] = SourceBBR
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package hjem
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingBBRSource struct {
+	src BBRSource
+
+	m       sync.Mutex
+	calls   int
+	running int
+	max     int
+}
+
+func (c *countingBBRSource) Lookup(addr *Address) (*BBRRecord, error) {
+	c.m.Lock()
+	c.calls += 1
+	c.running += 1
+	if c.running > c.max {
+		c.max = c.running
+	}
+	c.m.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	c.m.Lock()
+	c.running -= 1
+	c.m.Unlock()
+
+	return c.src.Lookup(addr)
+}
+
+type failingBBRSource struct{}
+
+func (failingBBRSource) Lookup(*Address) (*BBRRecord, error) {
+	return nil, errors.New("timeout")
+}
+
+func TestEnrichBBR(t *testing.T) {
+	f, err := ReadBBRFile(strings.NewReader(`{"address": "Vej 1, 1000 By", "building_size": 120, "rooms": 4, "renovation_year": 2005, "heating_type": "Fjernvarme"}
+{"address": "Vej 2, 1000 By", "building_size": 90, "roof_material": "Tegl"}
+`))
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := []*Address{
+				{DawaID: "Vej 1, 1000 By", StreetName: "Vej", StreetNumber: "1", BoligaBuildingSize: 110},
+				{DawaID: "vej 2, 1000 by", StreetName: "Vej", StreetNumber: "2"},
+				{DawaID: "Vej 3, 1000 By", StreetName: "Vej", StreetNumber: "3"},
+			}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+
+			src := &countingBBRSource{src: f}
+			e := newBBREnricher(db, src, 2)
+			if _, err := e.Enrich(addrs); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			var stored Address
+			db.First(&stored, addrs[0].ID)
+			if stored.BBRBuildingSize != 120 || stored.BBRHeatingType != "Fjernvarme" || stored.BoligaBuildingSize != 110 {
+				t.Fatalf("unexpected stored address: %+v", stored)
+			}
+
+			// addresses without a record are not looked up again
+			if _, err := e.Enrich(addrs); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if src.calls != 3 || src.max > 2 {
+				t.Fatalf("unexpected lookups: %d, %d at a time (expected: 3, at most 2 at a time)", src.calls, src.max)
+			}
+
+			for _, a := range addrs {
+				a.ApplyBBR()
+			}
+
+			tt := []struct {
+				addr   *Address
+				field  string
+				value  int
+				source string
+			}{
+				{addrs[0], "building_size", addrs[0].BoligaBuildingSize, SourceBoliga},
+				{addrs[0], "rooms", addrs[0].BoligaRooms, SourceBBR},
+				{addrs[0], "renovation_year", addrs[0].BBRRenovationYear, SourceBBR},
+				{addrs[1], "building_size", addrs[1].BoligaBuildingSize, SourceBBR},
+				{addrs[2], "building_size", addrs[2].BoligaBuildingSize, ""},
+			}
+
+			expected := []int{110, 4, 2005, 90, 0}
+			for i, tc := range tt {
+				if tc.value != expected[i] || tc.addr.Sources[tc.field] != tc.source {
+					t.Fatalf("unexpected %s of %s: %d from %q (expected: %d from %q)",
+						tc.field, tc.addr.DawaID, tc.value, tc.addr.Sources[tc.field], expected[i], tc.source)
+				}
+			}
+		})
+	}
+}
+
+func TestEnrichBBRFailures(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := []*Address{
+				{DawaID: "Vej 1, 1000 By", StreetName: "Vej", StreetNumber: "1"},
+				{DawaID: "Vej 2, 1000 By", StreetName: "Vej", StreetNumber: "2"},
+			}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+
+			src := &countingBBRSource{src: failingBBRSource{}}
+			e := newBBREnricher(db, src, 4)
+			addrErrs, err := e.Enrich(addrs)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(addrErrs) != 2 {
+				t.Fatalf("unexpected address errors: %v (expected: 2)", addrErrs)
+			}
+
+			// failures are neither looked up nor reported again until
+			// bbrRetryAfter has passed
+			addrErrs, err = e.Enrich(addrs)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(addrErrs) != 0 || src.calls != 2 {
+				t.Fatalf("unexpected retry: %v, %d lookups (expected: none, 2 lookups)", addrErrs, src.calls)
+			}
+
+			for id := range e.failed {
+				e.failed[id] = time.Now().Add(-bbrRetryAfter)
+			}
+
+			if addrErrs, _ = e.Enrich(addrs); len(addrErrs) != 2 || src.calls != 4 {
+				t.Fatalf("unexpected retry: %v, %d lookups (expected: 2 errors, 4 lookups)", addrErrs, src.calls)
+			}
+		})
+	}
+}
+
+func TestBBRClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("address") != "Vej 1, 1000 By" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(`{"address": "Vej 1, 1000 By", "built_year": 1932, "wall_material": "Mursten"}`))
+	}))
+	defer srv.Close()
+
+	c := BBRClient{Endpoint: srv.URL}
+	rec, err := c.Lookup(&Address{DawaID: "Vej 1, 1000 By"})
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	if rec.BuiltYear != 1932 || rec.WallMaterial != "Mursten" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+
+	if _, err := c.Lookup(&Address{DawaID: "Vej 2, 1000 By"}); !errors.Is(err, ErrNoBBRRecord) {
+		t.Fatalf("unexpected error: %v (expected: %v)", err, ErrNoBBRRecord)
+	}
+}
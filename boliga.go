@@ -52,29 +52,40 @@ type BoligaCacherResp struct {
 
 type BoligaCacher interface {
 	io.Closer
-	FetchSales([]*Address) ([][]Sale, error)
+	FetchSales([]*Address) ([][]Sale, []AddrError, error)
+	AttributeHistory([]*Address) (AttributeHistory, error)
 }
 
 type boligaCacher struct {
 	db *gorm.DB
 	in chan BoligaCacherTask
+
+	items    func([]*Address) ([]*BoligaSaleItem, []error)
+	property func(BoligaSaleItem) (*BoligaProperty, error)
 }
 
 func NewBoligaCacher(db *gorm.DB, n int) *boligaCacher {
-	in := make(chan BoligaCacherTask)
+	return newBoligaCacher(db, n, BoligaPropertiesFromAddrs, PropertyFromBoligaItem)
+}
+
+func newBoligaCacher(db *gorm.DB, n int, items func([]*Address) ([]*BoligaSaleItem, []error), property func(BoligaSaleItem) (*BoligaProperty, error)) *boligaCacher {
+	bc := &boligaCacher{
+		db:       db,
+		in:       make(chan BoligaCacherTask),
+		items:    items,
+		property: property,
+	}
 
 	for i := 0; i < n; i++ {
 		go func() {
-			for task := range in {
-				prop, err := PropertyFromBoligaItem(task.item)
+			for task := range bc.in {
+				prop, err := bc.property(task.item)
 				task.out <- BoligaCacherResp{task.index, prop, err}
 			}
 		}()
 	}
 
-	db.AutoMigrate(&Sale{})
-
-	return &boligaCacher{db, in}
+	return bc
 }
 
 func (bc *boligaCacher) Close() error {
@@ -84,10 +95,28 @@ func (bc *boligaCacher) Close() error {
 
 const oneMonth time.Duration = time.Hour * 24 * 31
 
-func (bc *boligaCacher) FetchSales(addrs []*Address) ([][]Sale, error) {
+// AddrError is the reason sales could not be fetched for an address. Stale
+// tells whether the previously stored sales of the address were returned
+// in place of the fetched sales.
+type AddrError struct {
+	Addr  *Address
+	Err   error
+	Stale bool
+}
+
+func (e AddrError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Addr.DawaID, e.Err)
+}
+
+// FetchSales returns the sales of each address in addrs. Addresses whose
+// Boliga data has expired are refreshed, but their previously stored sales
+// are kept until the refreshed sales have been committed. If a refresh
+// fails, the stale sales are returned instead and BoligaCollectedAt tells
+// how old they are. Every failed refresh is reported as an AddrError, while
+// the remaining addresses are still returned.
+func (bc *boligaCacher) FetchSales(addrs []*Address) ([][]Sale, []AddrError, error) {
 	cachedAddrs := map[int]*Address{}
 	fetchAddrs := map[int]*Address{}
-	var salesExpired []uint
 
 	for i, addr := range addrs {
 		if addr.BoligaCollectedAt.IsZero() {
@@ -97,135 +126,194 @@ func (bc *boligaCacher) FetchSales(addrs []*Address) ([][]Sale, error) {
 
 		if time.Now().Sub(addr.BoligaCollectedAt) >= oneMonth {
 			fetchAddrs[i] = addr
-			salesExpired = append(salesExpired, addr.ID)
 			continue
 		}
 
 		cachedAddrs[i] = addr
 	}
 
-	if len(salesExpired) > 0 {
-		bc.db.Delete(&Sale{}, salesExpired)
-	}
-
 	sales := make([][]Sale, len(addrs))
+	var addrErrs []AddrError
 	if len(fetchAddrs) > 0 {
-		fetchTime := time.Now()
-		addrsToFetch := make([]*Address, len(fetchAddrs))
-		addrIds := make([]uint, len(fetchAddrs))
-		ids := make([]int, len(fetchAddrs))
+		stored := map[int]bool{}
+		for i, addr := range fetchAddrs {
+			stored[i] = !addr.BoligaCollectedAt.IsZero()
+		}
+
+		refreshed, errs := bc.refreshSales(addrs, fetchAddrs, sales)
+
+		for i, addr := range addrs {
+			if _, ok := fetchAddrs[i]; !ok || refreshed[i] {
+				continue
+			}
+
+			err, failed := errs[i]
+			if failed {
+				addrErrs = append(addrErrs, AddrError{Addr: addr, Err: err, Stale: stored[i]})
+			}
+
+			// serve stale sales
+			if !failed || stored[i] {
+				cachedAddrs[i] = addr
+			}
+		}
+	}
+
+	if len(cachedAddrs) > 0 {
+		m := map[uint]int{}
+		addrIds := make([]uint, len(cachedAddrs))
 		var i int
-		for id, addr := range fetchAddrs {
+		for id, addr := range cachedAddrs {
+			m[addr.ID] = id
 			addrIds[i] = addr.ID
-			addrsToFetch[i] = addr
-			ids[i] = id
 			i += 1
 		}
 
-		items, err := BoligaPropertiesFromAddrs(addrsToFetch)
-		if err != nil {
-			return nil, err
-		}
+		err := inBatches(len(addrIds), maxBatchSize(bc.db), func(start, end int) error {
+			var dbsales []Sale
+			if err := bc.db.Where("addr_id IN ?", addrIds[start:end]).Find(&dbsales).Error; err != nil {
+				return err
+			}
 
-		var wg sync.WaitGroup
-		out := make(chan BoligaCacherResp)
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+			for _, s := range dbsales {
+				sid := m[s.AddrID]
+				sales[sid] = append(sales[sid], s)
+			}
 
-		go func() {
-			defer close(out)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
-			for i, item := range items {
-				if item == nil {
-					continue
-				}
+	return sales, addrErrs, nil
+}
 
-				if ctx.Err() != nil {
-					break
-				}
+// AttributeHistory returns the observed attributes of each address.
+func (bc *boligaCacher) AttributeHistory(addrs []*Address) (AttributeHistory, error) {
+	return attributeHistory(bc.db, addrs)
+}
 
-				wg.Add(1)
-				bc.in <- BoligaCacherTask{
-					item:  *item,
-					index: ids[i],
-					out:   out,
-				}
-			}
+// refreshSales fetches the sales of fetchAddrs from Boliga and replaces the
+// stored sales of each address in a transaction. It returns the indices of
+// the addresses which were refreshed, along with the errors of those which
+// failed. Addresses unknown to Boliga keep their stored sales, but the
+// attempt is recorded, such that they are not fetched again until expired.
+func (bc *boligaCacher) refreshSales(addrs []*Address, fetchAddrs map[int]*Address, sales [][]Sale) (map[int]bool, map[int]error) {
+	refreshed := map[int]bool{}
+	errs := map[int]error{}
+
+	fetchTime := time.Now()
+	addrsToFetch := make([]*Address, len(fetchAddrs))
+	ids := make([]int, len(fetchAddrs))
+	var i int
+	for id, addr := range fetchAddrs {
+		addrsToFetch[i] = addr
+		ids[i] = id
+		i += 1
+	}
 
-			wg.Wait()
-		}()
+	items, itemErrs := bc.items(addrsToFetch)
+	for i, err := range itemErrs {
+		if err != nil {
+			errs[ids[i]] = err
+			continue
+		}
 
-		var salesToStore []Sale
-		var rerr error
-		for resp := range out {
-			wg.Done()
-			if err := resp.err; err != nil {
-				rerr = err
-				cancel()
+		if items[i] == nil {
+			addr := addrs[ids[i]]
+			if err := bc.db.Model(addr).Update("boliga_collected_at", fetchTime).Error; err != nil {
+				errs[ids[i]] = err
 				continue
 			}
+			addr.BoligaCollectedAt = fetchTime
+		}
+	}
 
-			psales := make([]Sale, len(resp.prop.Sales))
-			addr := addrs[resp.index]
-			for i, sale := range resp.prop.Sales {
-				sale.AddrID = addr.ID
-				psales[i] = sale
-			}
+	var wg sync.WaitGroup
+	out := make(chan BoligaCacherResp)
 
-			sales[resp.index] = psales
-			salesToStore = append(salesToStore, psales...)
+	go func() {
+		defer close(out)
 
-			addr.BoligaCollectedAt = fetchTime
-			addr.BoligaBuiltYear = resp.prop.BuiltYear
-			addr.BoligaBasementSize = resp.prop.BasementSize
-			addr.BoligaBuildingSize = resp.prop.BuildingSize
-			addr.BoligaRooms = resp.prop.Rooms
-			addr.BoligaPropertySize = resp.prop.PropertySize
-			addr.BoligaMonthlyOwnerExpense = resp.prop.MonthlyOwnerExpense
-			addr.BoligaEnergyMarking = resp.prop.EnergyMarking
-			addr.BoligaPropertyKind = resp.prop.Kind
-
-			if err := bc.db.Save(&addr).Error; err != nil {
-				return nil, err
+		for i, item := range items {
+			if item == nil {
+				continue
 			}
 
+			wg.Add(1)
+			bc.in <- BoligaCacherTask{
+				item:  *item,
+				index: ids[i],
+				out:   out,
+			}
 		}
 
-		if rerr != nil {
-			return nil, rerr
-		}
+		wg.Wait()
+	}()
 
-		if err := bc.db.CreateInBatches(&salesToStore, 50).Error; err != nil {
-			return nil, err
+	for resp := range out {
+		wg.Done()
+		if err := resp.err; err != nil {
+			errs[resp.index] = err
+			continue
 		}
-	}
 
-	if len(cachedAddrs) > 0 {
-		m := map[uint]int{}
-		addrIds := make([]uint, len(cachedAddrs))
-		var i int
-		for id, addr := range cachedAddrs {
-			m[addr.ID] = id
-			addrIds[i] = addr.ID
-			i += 1
+		addr := addrs[resp.index]
+		psales := make([]Sale, len(resp.prop.Sales))
+		for i, sale := range resp.prop.Sales {
+			sale.AddrID = addr.ID
+			psales[i] = sale
 		}
 
-		var dbsales []Sale
-		bc.db.Where("addr_id IN ?", addrIds).Find(&dbsales)
+		updated := *addr
+		updated.BoligaCollectedAt = fetchTime
+		updated.BoligaBuiltYear = resp.prop.BuiltYear
+		updated.BoligaBasementSize = resp.prop.BasementSize
+		updated.BoligaBuildingSize = resp.prop.BuildingSize
+		updated.BoligaRooms = resp.prop.Rooms
+		updated.BoligaPropertySize = resp.prop.PropertySize
+		updated.BoligaMonthlyOwnerExpense = resp.prop.MonthlyOwnerExpense
+		updated.BoligaEnergyMarking = resp.prop.EnergyMarking
+		updated.BoligaPropertyKind = resp.prop.Kind
+
+		err := bc.db.Transaction(func(tx *gorm.DB) error {
+			if err := replaceSales(tx, addr.ID, psales); err != nil {
+				return err
+			}
 
-		for _, s := range dbsales {
-			sid := m[s.AddrID]
-			sales[sid] = append(sales[sid], s)
+			obs := AttributesOfAddress(updated)
+			if addr.BoligaCollectedAt.IsZero() || !obs.SameAs(AttributesOfAddress(*addr)) {
+				if err := tx.Create(&obs).Error; err != nil {
+					return err
+				}
+			}
+
+			return tx.Save(&updated).Error
+		})
+		if err != nil {
+			errs[resp.index] = err
+			continue
 		}
+
+		*addr = updated
+		sales[resp.index] = psales
+		refreshed[resp.index] = true
 	}
 
-	return sales, nil
+	return refreshed, errs
 }
 
 type Sale struct {
-	AddrID    uint      `json:"-"`
-	AmountDKK int       `json:"amount"`
-	Date      time.Time `json:"time"`
+	AddrID    uint      `json:"-" gorm:"uniqueIndex:idx_sales_unique"`
+	AmountDKK int       `json:"amount" gorm:"uniqueIndex:idx_sales_unique"`
+	Date      time.Time `json:"time" gorm:"uniqueIndex:idx_sales_unique"`
+	SaleType  string    `json:"sale_type" gorm:"uniqueIndex:idx_sales_unique;not null;default:''"`
+
+	// AskingPrice is the last asking price of the property before the sale,
+	// if known.
+	AskingPrice int `json:"asking_price,omitempty"`
 }
 
 type BoligaProperty struct {
@@ -270,7 +358,11 @@ type BoligaPageCrawl struct {
 	CreatedAt   time.Time
 }
 
-func BoligaPropertiesFromAddrs(addrs []*Address) ([]*BoligaSaleItem, error) {
+// BoligaPropertiesFromAddrs looks up the Boliga sale item of each address.
+// Addresses are searched for street by street, and a failing street search
+// only affects the addresses on that street: errs[i] holds the error for
+// addrs[i], if any.
+func BoligaPropertiesFromAddrs(addrs []*Address) ([]*BoligaSaleItem, []error) {
 	type K struct {
 		Municipality string
 		Street       string
@@ -278,7 +370,8 @@ func BoligaPropertiesFromAddrs(addrs []*Address) ([]*BoligaSaleItem, error) {
 	}
 
 	m := map[K]*BoligaPropertyRequest{}
-	for _, addr := range addrs {
+	members := map[K][]int{}
+	for i, addr := range addrs {
 		k := K{
 			Municipality: addr.MunicipalityCode,
 			Street:       addr.StreetName,
@@ -294,13 +387,19 @@ func BoligaPropertiesFromAddrs(addrs []*Address) ([]*BoligaSaleItem, error) {
 				MunicipalityID: mun,
 			}
 		}
+
+		members[k] = append(members[k], i)
 	}
 
+	errs := make([]error, len(addrs))
 	var totalSales []BoligaSaleItem
-	for _, req := range m {
+	for k, req := range m {
 		s, err := req.Fetch()
 		if err != nil {
-			return nil, err
+			for _, i := range members[k] {
+				errs[i] = err
+			}
+			continue
 		}
 
 		totalSales = append(totalSales, s...)
@@ -311,19 +410,16 @@ func BoligaPropertiesFromAddrs(addrs []*Address) ([]*BoligaSaleItem, error) {
 		z[addr.Short()] = i
 	}
 
-	var matches int
-
 	props := make([]*BoligaSaleItem, len(addrs))
 	for i, _ := range totalSales {
 		s := totalSales[i]
 		j, ok := z[s.Addr]
 		if ok {
-			matches += 1
 			props[j] = &s
 		}
 	}
 
-	return props, nil
+	return props, errs
 }
 
 type BoligaSalesResponse struct {
@@ -444,11 +540,15 @@ func PropertyFromBoligaItem(si BoligaSaleItem) (*BoligaProperty, error) {
 			uniqueSales[Sale{
 				AmountDKK: amount,
 				Date:      saleDate,
+				SaleType:  strings.ToLower(kind),
 			}] = struct{}{}
 		}
 	})
 
 	for sale, _ := range uniqueSales {
+		if si.PriceChange != 0 && sameDay(sale.Date, si.SoldDate) && sale.AmountDKK == si.AmountDKK {
+			sale.AskingPrice = AskingPriceFromChange(si.AmountDKK, si.PriceChange)
+		}
 		prop.Sales = append(prop.Sales, sale)
 	}
 
@@ -1,6 +1,8 @@
 package hjem
 
 import (
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -78,3 +80,110 @@ func TestDanishDateToTime(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchSales(t *testing.T) {
+	collected := time.Now().Add(-2 * oneMonth)
+	oldSale := Sale{AmountDKK: 1000000, Date: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)}
+	newSale := Sale{AmountDKK: 2000000, Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	tt := []struct {
+		name      string
+		itemErr   error
+		noItem    bool
+		propErr   error
+		sales     []int
+		errs      int
+		refreshed bool
+	}{
+		{name: "failing search", itemErr: errors.New("timeout"), sales: []int{1000000}, errs: 1},
+		{name: "failing property", propErr: errors.New("timeout"), sales: []int{1000000}, errs: 1},
+		{name: "unknown to boliga", noItem: true, sales: []int{1000000}, refreshed: true},
+		{name: "refreshed", sales: []int{2000000}, refreshed: true},
+	}
+
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, tc := range tt {
+				t.Run(tc.name, func(t *testing.T) {
+					addr := &Address{DawaID: tc.name + ", 1000 By", StreetName: "Vej", StreetNumber: "1", PostalCode: "1000", MunicipalityCode: "101", BoligaCollectedAt: collected}
+					db.Create(addr)
+					stored := oldSale
+					stored.AddrID = addr.ID
+					db.Create(&stored)
+
+					var searches int
+					items := func(addrs []*Address) ([]*BoligaSaleItem, []error) {
+						searches += 1
+						if tc.itemErr != nil {
+							return make([]*BoligaSaleItem, len(addrs)), []error{tc.itemErr}
+						}
+
+						if tc.noItem {
+							return make([]*BoligaSaleItem, len(addrs)), make([]error, len(addrs))
+						}
+
+						return []*BoligaSaleItem{{Addr: addr.Short()}}, make([]error, len(addrs))
+					}
+					property := func(BoligaSaleItem) (*BoligaProperty, error) {
+						if tc.propErr != nil {
+							return nil, tc.propErr
+						}
+
+						return &BoligaProperty{Kind: PropertyHouse, BuildingSize: 100, Sales: []Sale{newSale}}, nil
+					}
+
+					bc := newBoligaCacher(db, 1, items, property)
+					defer bc.Close()
+
+					for i := 0; i < 2; i++ {
+						sales, addrErrs, err := bc.FetchSales([]*Address{addr})
+						if err != nil {
+							t.Fatalf("received unexpected error: %s", err)
+						}
+
+						if len(addrErrs) != tc.errs {
+							t.Fatalf("unexpected address errors: %v (expected: %d)", addrErrs, tc.errs)
+						}
+
+						var amounts []int
+						for _, s := range sales[0] {
+							amounts = append(amounts, s.AmountDKK)
+						}
+
+						if !reflect.DeepEqual(amounts, tc.sales) {
+							t.Fatalf("unexpected sales: %v (expected: %v)", amounts, tc.sales)
+						}
+					}
+
+					var dbsales []Sale
+					db.Where("addr_id = ?", addr.ID).Find(&dbsales)
+					var amounts []int
+					for _, s := range dbsales {
+						amounts = append(amounts, s.AmountDKK)
+					}
+
+					if !reflect.DeepEqual(amounts, tc.sales) {
+						t.Fatalf("unexpected stored sales: %v (expected: %v)", amounts, tc.sales)
+					}
+
+					var a Address
+					db.First(&a, addr.ID)
+					if refreshed := a.BoligaCollectedAt.After(collected.Add(time.Hour)); refreshed != tc.refreshed {
+						t.Fatalf("unexpected collected at: %v (collected: %v, expected refreshed: %t)", a.BoligaCollectedAt, collected, tc.refreshed)
+					}
+
+					// a refreshed address is not searched for again until it
+					// expires
+					expected := 2
+					if tc.refreshed {
+						expected = 1
+					}
+
+					if searches != expected {
+						t.Fatalf("unexpected number of searches: %d (expected: %d)", searches, expected)
+					}
+				})
+			}
+		})
+	}
+}
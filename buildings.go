@@ -0,0 +1,237 @@
+package hjem
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Building is a DAWA access address (adgangsadresse), i.e. the entrance
+// shared by the unit addresses of e.g. an apartment building.
+type Building struct {
+	ID               uint   `gorm:"primaryKey"`
+	DawaID           string `gorm:"not null;uniqueIndex"`
+	StreetName       string `gorm:"not null"`
+	StreetNumber     string `gorm:"not null"`
+	PostalCode       string `gorm:"not null"`
+	MunicipalityCode string `gorm:"not null"`
+	Latitude         float64
+	Longitude        float64
+}
+
+// linkBuildings sets the BuildingID of addresses which know their access
+// address but are not yet linked to a building, creating the buildings
+// which do not exist. Linked addresses already stored are updated.
+func linkBuildings(db *gorm.DB, addrs []*Address) error {
+	unlinked := map[string][]*Address{}
+	for _, a := range addrs {
+		if a.BuildingID != nil || a.AccessAddressID == "" {
+			continue
+		}
+
+		unlinked[a.AccessAddressID] = append(unlinked[a.AccessAddressID], a)
+	}
+
+	if len(unlinked) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(unlinked))
+	for id := range unlinked {
+		ids = append(ids, id)
+	}
+
+	m := map[string]*Building{}
+	err := inBatches(len(ids), maxBatchSize(db), func(start, end int) error {
+		var buildings []*Building
+		if err := db.Where("dawa_id IN ?", ids[start:end]).Find(&buildings).Error; err != nil {
+			return err
+		}
+
+		for _, b := range buildings {
+			m[b.DawaID] = b
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var create []*Building
+	for _, id := range ids {
+		if _, ok := m[id]; ok {
+			continue
+		}
+
+		a := unlinked[id][0]
+		b := &Building{
+			DawaID:           id,
+			StreetName:       a.StreetName,
+			StreetNumber:     a.StreetNumber,
+			PostalCode:       a.PostalCode,
+			MunicipalityCode: a.MunicipalityCode,
+			Latitude:         a.Latitude,
+			Longitude:        a.Longitude,
+		}
+		m[id] = b
+		create = append(create, b)
+	}
+
+	if len(create) > 0 {
+		if err := db.CreateInBatches(&create, insertBatchSize(db, &Building{})).Error; err != nil {
+			return err
+		}
+	}
+
+	for id, as := range unlinked {
+		b := m[id]
+
+		var stored []uint
+		for _, a := range as {
+			a.BuildingID = &b.ID
+			if a.ID != 0 {
+				stored = append(stored, a.ID)
+			}
+		}
+
+		if len(stored) == 0 {
+			continue
+		}
+
+		err := inBatches(len(stored), maxBatchSize(db), func(start, end int) error {
+			return db.Model(&Address{}).
+				Where("id IN ?", stored[start:end]).
+				Update("building_id", b.ID).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DawaAccessAddress returns the DAWA access address of a stored address,
+// by searching DAWA for its designation. The cache is bypassed, since it
+// does not hold access addresses.
+func DawaAccessAddress(addr *Address) (string, error) {
+	addrs, err := DawaFuzzySearch{Query: addr.DawaID}.Fetch()
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range addrs {
+		if a.DawaID == addr.DawaID {
+			return a.AccessAddressID, nil
+		}
+	}
+
+	return "", ErrNoAddr
+}
+
+// LinkStoredBuildings links the stored addresses which are not yet linked
+// to a building, such as those stored before buildings were introduced,
+// using accessAddress to find the access address of each. It returns the
+// number of addresses which were linked, along with the addresses whose
+// access address could not be found.
+func LinkStoredBuildings(db *gorm.DB, accessAddress func(*Address) (string, error)) (int, []AddrError, error) {
+	var linked int
+	var addrErrs []AddrError
+	var last uint
+	for {
+		var addrs []*Address
+		err := db.Where("building_id IS NULL AND id > ?", last).
+			Order("id").
+			Limit(maxBatchSize(db)).
+			Find(&addrs).Error
+		if err != nil {
+			return linked, addrErrs, err
+		}
+
+		if len(addrs) == 0 {
+			return linked, addrErrs, nil
+		}
+		last = addrs[len(addrs)-1].ID
+
+		for _, a := range addrs {
+			id, err := accessAddress(a)
+			if err != nil {
+				addrErrs = append(addrErrs, AddrError{Addr: a, Err: err})
+				continue
+			}
+			a.AccessAddressID = id
+		}
+
+		if err := linkBuildings(db, addrs); err != nil {
+			return linked, addrErrs, err
+		}
+
+		for _, a := range addrs {
+			if a.BuildingID != nil {
+				linked += 1
+			}
+		}
+	}
+}
+
+// BuildingSummary aggregates the sales of the units of a building which are
+// part of a lookup.
+type BuildingSummary struct {
+	Name         string                    `json:"name"`
+	Units        []int                     `json:"units"`
+	Sales        int                       `json:"sales"`
+	SquareMeters map[time.Time]Aggregation `json:"sqmeters"`
+	Floors       []FloorPremium            `json:"floors,omitempty"`
+
+	sales []*JSONSale
+}
+
+// SummarizeBuildings groups the addresses of a lookup response by building,
+// and aggregates the price per square meter of the sales in each building.
+// Addresses which are not linked to a building are left out.
+func SummarizeBuildings(resp *LookupResponse) []*BuildingSummary {
+	byBuilding := map[uint]*BuildingSummary{}
+	var order []uint
+	for i, a := range resp.Addrs {
+		if a.BuildingID == nil {
+			continue
+		}
+
+		b, ok := byBuilding[*a.BuildingID]
+		if !ok {
+			b = &BuildingSummary{
+				Name: fmt.Sprintf("%s %s, %s", a.StreetName, a.StreetNumber, a.PostalCode),
+			}
+			byBuilding[*a.BuildingID] = b
+			order = append(order, *a.BuildingID)
+		}
+
+		b.Units = append(b.Units, i)
+	}
+
+	out := make([]*BuildingSummary, len(order))
+	for i, id := range order {
+		b := byBuilding[id]
+
+		units := map[int]bool{}
+		for _, u := range b.Units {
+			units[u] = true
+		}
+
+		var sales []*JSONSale
+		for _, s := range resp.Sales {
+			if units[s.AddrIndex] {
+				sales = append(sales, s)
+			}
+		}
+
+		b.sales = sales
+		b.Sales = len(sales)
+		_, b.SquareMeters = SalesStatistics(sales, 0)
+		out[i] = b
+	}
+
+	return out
+}
@@ -0,0 +1,122 @@
+package hjem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLinkBuildings(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			dc := NewDawaCacher(db, nil)
+
+			unit := func(floor, access string) *Address {
+				return &Address{
+					DawaID:          "Vej 1, " + floor + ", 1000 By",
+					StreetName:      "Vej",
+					StreetNumber:    "1",
+					Floor:           &floor,
+					AccessAddressID: access,
+				}
+			}
+
+			// stored before buildings were known
+			legacy := unit("st", "")
+			if err := dc.safeCreateOrGetAddrs([]*Address{legacy}); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			addrs := []*Address{unit("st", "a1"), unit("1", "a1"), unit("2", "a1")}
+			if err := dc.safeCreateOrGetAddrs(addrs); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			var buildings int64
+			db.Model(&Building{}).Count(&buildings)
+			if buildings != 1 {
+				t.Fatalf("unexpected amount of buildings: %d (expected: 1)", buildings)
+			}
+
+			var linked int64
+			db.Model(&Address{}).Where("building_id IS NOT NULL").Count(&linked)
+			if linked != 3 {
+				t.Fatalf("unexpected amount of linked addresses: %d (expected: 3)", linked)
+			}
+		})
+	}
+}
+
+func TestLinkStoredBuildings(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := []*Address{
+				{DawaID: "Vej 1, st, 1000 By", StreetName: "Vej", StreetNumber: "1"},
+				{DawaID: "Vej 1, 1, 1000 By", StreetName: "Vej", StreetNumber: "1"},
+				{DawaID: "Vej 2, 1000 By", StreetName: "Vej", StreetNumber: "2"},
+			}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+
+			access := map[string]string{
+				"Vej 1, st, 1000 By": "a1",
+				"Vej 1, 1, 1000 By":  "a1",
+			}
+			n, addrErrs, err := LinkStoredBuildings(db, func(a *Address) (string, error) {
+				id, ok := access[a.DawaID]
+				if !ok {
+					return "", ErrNoAddr
+				}
+
+				return id, nil
+			})
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if n != 2 || len(addrErrs) != 1 || addrErrs[0].Addr.DawaID != "Vej 2, 1000 By" {
+				t.Fatalf("unexpected linked addresses: %d, %v (expected: 2 and an error of Vej 2)", n, addrErrs)
+			}
+
+			var stored []*Address
+			db.Order("id").Find(&stored)
+			if stored[0].BuildingID == nil || stored[1].BuildingID == nil || *stored[0].BuildingID != *stored[1].BuildingID || stored[2].BuildingID != nil {
+				t.Fatalf("unexpected buildings of addresses: %v, %v, %v", stored[0].BuildingID, stored[1].BuildingID, stored[2].BuildingID)
+			}
+		})
+	}
+}
+
+func TestSummarizeBuildings(t *testing.T) {
+	b1, b2 := uint(1), uint(2)
+	when := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	resp := &LookupResponse{
+		Addrs: []*Address{
+			{StreetName: "Vej", StreetNumber: "1", PostalCode: "1000", BuildingID: &b1},
+			{StreetName: "Vej", StreetNumber: "3", PostalCode: "1000", BuildingID: &b2},
+			{StreetName: "Vej", StreetNumber: "1", PostalCode: "1000", BuildingID: &b1},
+			{StreetName: "Vej", StreetNumber: "5", PostalCode: "1000"},
+		},
+		Sales: []*JSONSale{
+			{AddrIndex: 0, Amount: 2000000, When: when, BuildingSize: 100},
+			{AddrIndex: 1, Amount: 3000000, When: when, BuildingSize: 100},
+			{AddrIndex: 2, Amount: 4000000, When: when, BuildingSize: 100},
+			{AddrIndex: 3, Amount: 5000000, When: when, BuildingSize: 100},
+		},
+	}
+
+	summaries := SummarizeBuildings(resp)
+	if len(summaries) != 2 {
+		t.Fatalf("unexpected amount of buildings: %d (expected: 2)", len(summaries))
+	}
+
+	b := summaries[0]
+	if len(b.Units) != 2 || b.Sales != 2 {
+		t.Fatalf("unexpected summary: %+v", b)
+	}
+
+	if mean := b.SquareMeters[when].Mean; mean != 30000 {
+		t.Fatalf("unexpected mean price per square meter: %d (expected: 30000)", mean)
+	}
+}
@@ -0,0 +1,246 @@
+// Package client is a client of the HTTP API of a hjem server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Error codes of the API, see Error.
+const (
+	CodeMissingParameter = "missing_parameter"
+	CodeInvalidParameter = "invalid_parameter"
+	CodeInvalidBody      = "invalid_body"
+	CodeNonUniqueAddress = "non_unique_address"
+	CodeAddressNotFound  = "address_not_found"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeUpstream         = "upstream_error"
+	CodeInternal         = "internal_error"
+)
+
+// Error is an error replied by the server. Code is one of the Code
+// constants, and is empty for the endpoints outside of the v1 API.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("hjem: %s (status code: %d)", e.Message, e.StatusCode)
+	}
+
+	return fmt.Sprintf("hjem: %s: %s", e.Code, e.Message)
+}
+
+// Client calls the API of the hjem server at BaseURL, e.g.
+// "http://localhost:8080".
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// do performs a request of the path, and returns the body of the reply,
+// which must be closed, unless the server replied with an error.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}) (io.ReadCloser, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	return resp.Body, nil
+}
+
+// decodeError reads the error of a reply, which is either the error
+// envelope of the v1 API or the plain error message of the other
+// endpoints.
+func decodeError(resp *http.Response) error {
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+	}
+
+	var out struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || out.Error == nil {
+		return e
+	}
+
+	var envelope struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(out.Error, &envelope); err == nil {
+		e.Code, e.Message = envelope.Code, envelope.Message
+		return e
+	}
+
+	json.Unmarshal(out.Error, &e.Message)
+	return e
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, body, out interface{}) error {
+	rc, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return json.NewDecoder(rc).Decode(out)
+}
+
+// query returns the parameters of a lookup request.
+func (req LookupRequest) query() string {
+	params := url.Values{}
+	params.Set("q", req.Query)
+	for _, r := range req.Ranges {
+		params.Add("range", strconv.Itoa(r))
+	}
+
+	if req.Filter != 0 {
+		params.Set("filter_below_std", strconv.Itoa(req.Filter))
+	}
+
+	return "?" + params.Encode()
+}
+
+// Addresses returns the addresses matching the query.
+func (c *Client) Addresses(ctx context.Context, query string) ([]*Address, error) {
+	var out struct {
+		Addresses []*Address `json:"addresses"`
+	}
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/addresses?q="+url.QueryEscape(query), nil, &out)
+
+	return out.Addresses, err
+}
+
+// Lookup performs a lookup, without saving it.
+func (c *Client) Lookup(ctx context.Context, req LookupRequest) (*LookupResponse, error) {
+	var out LookupResponse
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/lookups"+req.query(), nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// SaveLookup performs a lookup, and saves it on the server.
+func (c *Client) SaveLookup(ctx context.Context, req LookupRequest) (*SavedLookup, error) {
+	var out SavedLookup
+	if err := c.doJSON(ctx, http.MethodPost, "/api/v1/lookups", req, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// SavedLookup returns the saved lookup of the id, including its result.
+func (c *Client) SavedLookup(ctx context.Context, id string) (*SavedLookup, error) {
+	var out SavedLookup
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/lookups/"+url.PathEscape(id), nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// DeleteSavedLookup deletes the saved lookup of the id, given the token
+// replied when it was saved.
+func (c *Client) DeleteSavedLookup(ctx context.Context, id, token string) error {
+	var out SavedLookup
+	path := "/api/v1/lookups/" + url.PathEscape(id) + "?token=" + url.QueryEscape(token)
+	return c.doJSON(ctx, http.MethodDelete, path, nil, &out)
+}
+
+// DownloadCSV writes the sales of a lookup to w as CSV, as served by the
+// server.
+func (c *Client) DownloadCSV(ctx context.Context, req LookupRequest, w io.Writer) error {
+	rc, err := c.do(ctx, http.MethodGet, "/download/csv"+req.query(), nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// CSV returns the rows of the CSV of a lookup, starting with its headers.
+func (c *Client) CSV(ctx context.Context, req LookupRequest) ([][]string, error) {
+	rc, err := c.do(ctx, http.MethodGet, "/download/csv"+req.query(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return csv.NewReader(rc).ReadAll()
+}
+
+// GeoJSON returns the addresses and ranges of a lookup as GeoJSON.
+func (c *Client) GeoJSON(ctx context.Context, req LookupRequest) (*GeoJSONFeatureCollection, error) {
+	var out GeoJSONFeatureCollection
+	if err := c.doJSON(ctx, http.MethodGet, "/download/geojson"+req.query(), nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// Area kinds of AreaReports.
+const (
+	AreaZip          = "zip"
+	AreaMunicipality = "municipality"
+)
+
+// AreaReports returns a report of each of the postal codes or
+// municipalities of codes, depending on the kind of area.
+func (c *Client) AreaReports(ctx context.Context, kind string, codes ...string) ([]AreaReport, error) {
+	params := url.Values{}
+	params.Set(kind, strings.Join(codes, ","))
+
+	var out []AreaReport
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/areas?"+params.Encode(), nil, &out)
+
+	return out, err
+}
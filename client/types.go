@@ -0,0 +1,180 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// LookupRequest asks for the sales of the address matching Query, and of
+// the addresses of the same property type within each of the Ranges (in
+// meters). Sales further than Filter standard deviations from the mean
+// price per square meter of their year are left out, unless Filter is 0.
+type LookupRequest struct {
+	Query       string `json:"q"`
+	Ranges      []int  `json:"ranges"`
+	Filter      int    `json:"filter_below_std"`
+	AskingPrice int    `json:"asking_price,omitempty"`
+}
+
+type Address struct {
+	DawaID           string  `json:"full_txt"`
+	StreetName       string  `json:"street_name"`
+	StreetNumber     string  `json:"street_number"`
+	Floor            *string `json:"floor"`
+	Door             *string `json:"door"`
+	PostalCode       string  `json:"zipcode"`
+	MunicipalityCode string  `json:"municipality_code"`
+	Latitude         float64 `json:"lat"`
+	Longitude        float64 `json:"long"`
+
+	BoligaCollectedAt         time.Time `json:"collected_at"`
+	BoligaBuildingSize        int       `json:"building_size"`
+	BoligaPropertySize        int       `json:"property_size"`
+	BoligaBasementSize        int       `json:"basement_size"`
+	BoligaRooms               int       `json:"rooms"`
+	BoligaBuiltYear           int       `json:"built_year"`
+	BoligaMonthlyOwnerExpense int       `json:"monthly_owner_expense_dkk"`
+	BoligaEnergyMarking       string    `json:"energy_marking"`
+
+	BBRCollectedAt    time.Time `json:"bbr_collected_at"`
+	BBRRenovationYear int       `json:"renovation_year"`
+	BBRRoofMaterial   string    `json:"roof_material"`
+	BBRWallMaterial   string    `json:"wall_material"`
+	BBRHeatingType    string    `json:"heating_type"`
+
+	// Sources tells whether each attribute came from Boliga or BBR.
+	Sources map[string]string `json:"sources,omitempty"`
+}
+
+// LonLat returns the longitude and latitude of the address. As with the
+// server, DAWA's x and y are held by Latitude and Longitude respectively.
+func (addr Address) LonLat() (float64, float64) {
+	return addr.Latitude, addr.Longitude
+}
+
+// JSONSale is a sale of the address at AddrIndex, along with the building
+// size and energy label of the address at the time of the sale.
+type JSONSale struct {
+	AddrIndex      int       `json:"addr_idx"`
+	Amount         int       `json:"amount"`
+	When           time.Time `json:"when"`
+	BuildingSize   int       `json:"building_size"`
+	EnergyLabel    string    `json:"energy_label,omitempty"`
+	ValuationRatio float64   `json:"valuation_ratio,omitempty"`
+	AskingPrice    int       `json:"asking_price,omitempty"`
+}
+
+type Aggregation struct {
+	Mean int `json:"mean"`
+	Std  int `json:"std"`
+	N    int `json:"n"`
+}
+
+type SquareMeterPrices struct {
+	Global      map[time.Time]Aggregation `json:"global"`
+	Projections []map[time.Time]int       `json:"projections"`
+}
+
+type AddressSummary struct {
+	AddrIndex              int        `json:"addr_idx"`
+	Lon                    float64    `json:"lon"`
+	Lat                    float64    `json:"lat"`
+	Sales                  int        `json:"sales"`
+	LatestSale             *time.Time `json:"latest_sale,omitempty"`
+	LatestAmount           int        `json:"latest_amount,omitempty"`
+	LatestSquareMeterPrice int        `json:"latest_sqmeter_price,omitempty"`
+}
+
+// Warning describes data which was left out of a lookup, since it could
+// not be fetched. Address is the address, or postal code, it concerns.
+type Warning struct {
+	Address string `json:"address"`
+	Message string `json:"message"`
+}
+
+// LookupResponse is the result of a lookup. The analyses of the lookup are
+// left undecoded, and may be decoded into the types of the hjem package.
+type LookupResponse struct {
+	PrimaryIndex int               `json:"primary_idx"`
+	Addrs        []*Address        `json:"addresses"`
+	Sales        []*JSONSale       `json:"sales"`
+	Ranges       map[int][]int     `json:"ranges,omitempty"`
+	SquareMeters SquareMeterPrices `json:"sqmeters"`
+	Summaries    []AddressSummary  `json:"summaries"`
+	Warnings     []Warning         `json:"warnings,omitempty"`
+
+	Floors    json.RawMessage `json:"floors,omitempty"`
+	Energy    json.RawMessage `json:"energy,omitempty"`
+	Estimate  json.RawMessage `json:"estimate,omitempty"`
+	Valuation json.RawMessage `json:"valuation,omitempty"`
+	Listings  json.RawMessage `json:"listings,omitempty"`
+	Spread    json.RawMessage `json:"spread,omitempty"`
+	Buildings json.RawMessage `json:"buildings,omitempty"`
+}
+
+// Primary returns the address which was looked up.
+func (resp *LookupResponse) Primary() *Address {
+	return resp.Addrs[resp.PrimaryIndex]
+}
+
+// SavedLookup is a lookup saved by the server, which can be shared by its
+// URL. Result is only set when a single saved lookup is requested, and
+// Token, which is required to delete it, only when the lookup is saved.
+type SavedLookup struct {
+	ID        string          `json:"id"`
+	URL       string          `json:"url"`
+	Query     string          `json:"q"`
+	DawaID    string          `json:"dawa_id"`
+	Ranges    []int           `json:"ranges"`
+	Filter    int             `json:"filter_below_std"`
+	CreatedAt time.Time       `json:"created_at"`
+	Result    *LookupResponse `json:"result,omitempty"`
+	Token     string          `json:"token,omitempty"`
+}
+
+type GeoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// AreaStats summarizes the sales of an area in a year. Change is the
+// relative change of the median price per square meter since the previous
+// year, if known.
+type AreaStats struct {
+	Sales                  int      `json:"sales"`
+	VolumeDKK              int64    `json:"volume_dkk"`
+	MedianSquareMeterPrice int      `json:"median_sqmeter_price,omitempty"`
+	Change                 *float64 `json:"yoy_change,omitempty"`
+}
+
+type AreaKindStats struct {
+	Kind string `json:"property_type"`
+	AreaStats
+}
+
+type AreaYear struct {
+	Year int `json:"year"`
+	AreaStats
+	Kinds        []AreaKindStats `json:"kinds"`
+	Listings     int             `json:"listings,omitempty"`
+	DaysOnMarket *int            `json:"median_days_on_market,omitempty"`
+}
+
+// AreaReport summarizes the sales of the postal code or municipality given
+// by Code, by year.
+type AreaReport struct {
+	AreaKind string     `json:"area_kind"`
+	Code     string     `json:"code"`
+	Years    []AreaYear `json:"years"`
+}
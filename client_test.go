@@ -0,0 +1,244 @@
+package hjem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tpanum/hjem/client"
+)
+
+// TestClientMirrorsServerTypes ensures that the types of the client decode
+// every field of the replies of the server.
+func TestClientMirrorsServerTypes(t *testing.T) {
+	floor := "1"
+	when := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp := &LookupResponse{
+		Addrs: []*Address{{
+			DawaID:  "Vej 1, 1. th, 1000 By",
+			Floor:   &floor,
+			Sources: map[string]string{"building_size": SourceBBR},
+		}},
+		Sales:  []*JSONSale{{Amount: 2000000, When: when, EnergyLabel: "a", ValuationRatio: 1.1, AskingPrice: 2100000}},
+		Ranges: map[int][]int{250: {0}},
+		SquareMeters: SquareMeterPrices{
+			Global:      map[time.Time]Aggregation{when: {Mean: 20000, Std: 100, N: 1}},
+			Projections: []map[time.Time]int{{when: 2000000}},
+		},
+		Floors:    []FloorPremium{{Floor: 1}},
+		Energy:    &EnergyAnalysis{},
+		Estimate:  &ValueEstimate{},
+		Valuation: &ValuationComparison{},
+		Listings:  &ListingComparison{},
+		Spread:    &AskingSpread{},
+		Buildings: []*BuildingSummary{{}},
+		Summaries: []AddressSummary{{LatestSale: &when}},
+		Warnings:  []Warning{{Address: "Vej 2", Message: "timeout"}},
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	var out client.LookupResponse
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&out); err != nil {
+		t.Fatalf("client does not mirror the lookup response: %s", err)
+	}
+
+	if *out.Primary().Floor != "1" || out.SquareMeters.Global[when].Mean != 20000 || out.Sales[0].AskingPrice != 2100000 {
+		t.Fatalf("unexpected decoded response: %+v", out)
+	}
+
+	change, days := 0.05, 30
+	report := AreaReport{
+		AreaKind: AreaZip,
+		Code:     "2000",
+		Years: []AreaYear{{
+			Year:         2020,
+			AreaStats:    AreaStats{Sales: 1, VolumeDKK: 2000000, MedianSquareMeterPrice: 20000, Change: &change},
+			Kinds:        []AreaKindStats{{Kind: "house", AreaStats: AreaStats{Sales: 1}}},
+			Listings:     1,
+			DaysOnMarket: &days,
+		}},
+	}
+
+	b, _ = json.Marshal(report)
+	var outReport client.AreaReport
+	dec = json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&outReport); err != nil {
+		t.Fatalf("client does not mirror the area report: %s", err)
+	}
+
+	if *outReport.Years[0].Change != change || outReport.Years[0].Kinds[0].Kind != "house" {
+		t.Fatalf("unexpected decoded report: %+v", outReport)
+	}
+}
+
+func TestClientErrorCodes(t *testing.T) {
+	codes := map[string]string{
+		CodeMissingParameter: client.CodeMissingParameter,
+		CodeInvalidParameter: client.CodeInvalidParameter,
+		CodeInvalidBody:      client.CodeInvalidBody,
+		CodeNonUniqueAddress: client.CodeNonUniqueAddress,
+		CodeAddressNotFound:  client.CodeAddressNotFound,
+		CodeNotFound:         client.CodeNotFound,
+		CodeMethodNotAllowed: client.CodeMethodNotAllowed,
+		CodeUpstream:         client.CodeUpstream,
+		CodeInternal:         client.CodeInternal,
+	}
+
+	for server, c := range codes {
+		if server != c {
+			t.Fatalf("unexpected error code of client: %s (expected: %s)", c, server)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := []*Address{
+				{DawaID: "Vej 1, 1000 By", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+				{DawaID: "Vej 2, 1000 By", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+			}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+
+			bc := fakeBoligaCacher{sales: map[uint][]Sale{
+				addrs[1].ID: {{AddrID: addrs[1].ID, AmountDKK: 2000000, Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}},
+			}}
+			db.Create(bc.sales[addrs[1].ID])
+			s := &server{db: db, dc: fakeDawaCacher{addrs: addrs}, bc: bc}
+			ts := httptest.NewServer(s.Routes())
+			defer ts.Close()
+
+			ctx := context.Background()
+			c := client.New(ts.URL)
+			req := client.LookupRequest{Query: "Vej 1", Ranges: []int{500}}
+
+			found, err := c.Addresses(ctx, "Vej")
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(found) != 1 || found[0].DawaID != addrs[0].DawaID {
+				t.Fatalf("unexpected addresses: %+v", found)
+			}
+
+			resp, err := c.Lookup(ctx, req)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if resp.Primary().DawaID != addrs[0].DawaID || len(resp.Sales) != 1 || resp.Sales[0].Amount != 2000000 {
+				t.Fatalf("unexpected lookup: %+v", resp)
+			}
+
+			rows, err := c.CSV(ctx, req)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(rows) != 2 || rows[1][0] != addrs[1].DawaID {
+				t.Fatalf("unexpected csv: %v", rows)
+			}
+
+			fc, err := c.GeoJSON(ctx, req)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(fc.Features) != 3 || fc.Features[2].Geometry.Type != "Polygon" {
+				t.Fatalf("unexpected geojson: %+v", fc)
+			}
+
+			reports, err := c.AreaReports(ctx, client.AreaZip, "1000")
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(reports) != 1 || reports[0].Code != "1000" || len(reports[0].Years) != 1 || reports[0].Years[0].Sales != 1 {
+				t.Fatalf("unexpected area reports: %+v", reports)
+			}
+
+			saved, err := c.SaveLookup(ctx, req)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			got, err := c.SavedLookup(ctx, saved.ID)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if got.Result == nil || len(got.Result.Sales) != 1 {
+				t.Fatalf("unexpected saved lookup: %+v", got)
+			}
+
+			if saved.Token == "" {
+				t.Fatalf("expected a token of the saved lookup")
+			}
+
+			if err := c.DeleteSavedLookup(ctx, saved.ID, saved.Token); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			tests := []struct {
+				name string
+				do   func() error
+				sc   int
+				code string
+			}{
+				{
+					name: "deleted lookup",
+					do: func() error {
+						_, err := c.SavedLookup(ctx, saved.ID)
+						return err
+					},
+					sc:   http.StatusNotFound,
+					code: client.CodeNotFound,
+				},
+				{
+					name: "missing query",
+					do: func() error {
+						_, err := c.Lookup(ctx, client.LookupRequest{})
+						return err
+					},
+					sc:   http.StatusBadRequest,
+					code: client.CodeMissingParameter,
+				},
+				{
+					name: "download without query",
+					do: func() error {
+						_, err := c.CSV(ctx, client.LookupRequest{})
+						return err
+					},
+					sc: http.StatusBadRequest,
+				},
+			}
+
+			for _, tc := range tests {
+				t.Run(tc.name, func(t *testing.T) {
+					var e *client.Error
+					if err := tc.do(); !errors.As(err, &e) {
+						t.Fatalf("unexpected error: %v (expected: *client.Error)", err)
+					}
+
+					if e.StatusCode != tc.sc || e.Code != tc.code || e.Message == "" {
+						t.Fatalf("unexpected error: %+v (expected status code: %d, code: %q)", e, tc.sc, tc.code)
+					}
+				})
+			}
+		})
+	}
+}
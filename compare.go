@@ -0,0 +1,174 @@
+package hjem
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	MaxCompareAddresses = 5
+)
+
+var (
+	ErrCompareCount = fmt.Errorf("compare between 1 and %d addresses", MaxCompareAddresses)
+)
+
+// CompareRequest asks for a comparison of the addresses matching each of
+// the Queries, each looked up as a LookupRequest with Ranges and Filter.
+type CompareRequest struct {
+	Queries []string `json:"q"`
+	Ranges  []int    `json:"ranges"`
+	Filter  int      `json:"filter_below_std"`
+}
+
+// ComparedAddress is an address of a comparison. SquareMeters is the mean
+// price per square meter around the address in each of the years of the
+// comparison, or nil for years without sales.
+type ComparedAddress struct {
+	Address      *Address           `json:"address"`
+	Estimate     *ValueEstimate     `json:"estimate,omitempty"`
+	Valuation    *PropertyValuation `json:"valuation,omitempty"`
+	SquareMeters []*int             `json:"sqmeters"`
+}
+
+// CompareResponse holds the compared addresses, in the order they were
+// requested, with their price series aligned by Years.
+type CompareResponse struct {
+	Years     []int             `json:"years"`
+	Addresses []ComparedAddress `json:"addresses"`
+	Warnings  []Warning         `json:"warnings,omitempty"`
+}
+
+// compare performs a lookup of each query of req. The lookups run one
+// after another, so data fetched by one, such as the sales of addresses
+// near several of the compared addresses, is served from the caches to
+// the next.
+func (s *server) compare(req CompareRequest) (*CompareResponse, error) {
+	if len(req.Queries) == 0 || len(req.Queries) > MaxCompareAddresses {
+		return nil, ErrCompareCount
+	}
+
+	resps := make([]*LookupResponse, len(req.Queries))
+	for i, q := range req.Queries {
+		resp, err := s.lookup(LookupRequest{
+			Query:  q,
+			Ranges: req.Ranges,
+			Filter: req.Filter,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q, err)
+		}
+
+		resps[i] = resp
+	}
+
+	return CompareLookups(resps), nil
+}
+
+// CompareLookups aligns the results of several lookups by year.
+func CompareLookups(resps []*LookupResponse) *CompareResponse {
+	years := map[int]bool{}
+	for _, resp := range resps {
+		for t := range resp.SquareMeters.Global {
+			years[t.Year()] = true
+		}
+	}
+
+	var out CompareResponse
+	for y := range years {
+		out.Years = append(out.Years, y)
+	}
+	sort.Ints(out.Years)
+
+	for _, resp := range resps {
+		ca := ComparedAddress{
+			Address:      resp.Addrs[resp.PrimaryIndex],
+			Estimate:     resp.Estimate,
+			SquareMeters: make([]*int, len(out.Years)),
+		}
+
+		if resp.Valuation != nil {
+			ca.Valuation = resp.Valuation.PrimaryValuation
+		}
+
+		for i, y := range out.Years {
+			agg, ok := resp.SquareMeters.Global[time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)]
+			if ok {
+				mean := agg.Mean
+				ca.SquareMeters[i] = &mean
+			}
+		}
+
+		out.Addresses = append(out.Addresses, ca)
+		out.Warnings = append(out.Warnings, resp.Warnings...)
+	}
+
+	return &out
+}
+
+// Headers returns the columns of the CSV rows of the comparison.
+func (c CompareResponse) Headers() []string {
+	row := []string{
+		"address",
+		"building_size",
+		"monthly_owner_expense_dkk",
+		"energy_marking",
+		"estimate_dkk",
+		"valuation_dkk",
+		"valuation_year",
+	}
+
+	for _, y := range c.Years {
+		row = append(row, fmt.Sprintf("sqmeter_price_%d", y))
+	}
+
+	return row
+}
+
+// Rows returns a CSV row of each compared address.
+func (c CompareResponse) Rows() [][]string {
+	itoa := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+
+		return strconv.Itoa(n)
+	}
+
+	var rows [][]string
+	for _, ca := range c.Addresses {
+		a := ca.Address
+		var estimate, valuation, valuationYear int
+		if ca.Estimate != nil {
+			estimate = ca.Estimate.AmountDKK
+		}
+
+		if ca.Valuation != nil {
+			valuation, valuationYear = ca.Valuation.PropertyValue, ca.Valuation.Year
+		}
+
+		row := []string{
+			a.DawaID,
+			itoa(a.BoligaBuildingSize),
+			itoa(a.BoligaMonthlyOwnerExpense),
+			NormalizeEnergyLabel(a.BoligaEnergyMarking),
+			itoa(estimate),
+			itoa(valuation),
+			itoa(valuationYear),
+		}
+
+		for _, p := range ca.SquareMeters {
+			var v string
+			if p != nil {
+				v = strconv.Itoa(*p)
+			}
+			row = append(row, v)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
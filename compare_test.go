@@ -0,0 +1,100 @@
+package hjem
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareLookups(t *testing.T) {
+	year := func(y int) time.Time {
+		return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	resps := []*LookupResponse{
+		{
+			Addrs:        []*Address{{DawaID: "Vej 1, 1000 By", BoligaBuildingSize: 100, BoligaEnergyMarking: "c"}},
+			SquareMeters: SquareMeterPrices{Global: map[time.Time]Aggregation{year(2019): {Mean: 20000}, year(2020): {Mean: 21000}}},
+			Estimate:     &ValueEstimate{Year: 2020, SquareMeterPrice: 21000, AmountDKK: 2100000},
+		},
+		{
+			Addrs:        []*Address{{DawaID: "Vej 2, 1000 By", BoligaBuildingSize: 80}},
+			SquareMeters: SquareMeterPrices{Global: map[time.Time]Aggregation{year(2020): {Mean: 30000}, year(2021): {Mean: 32000}}},
+			Valuation:    &ValuationComparison{PrimaryValuation: &PropertyValuation{Year: 2020, PropertyValue: 1800000}},
+		},
+	}
+
+	cmp := CompareLookups(resps)
+	if len(cmp.Years) != 3 || cmp.Years[0] != 2019 || cmp.Years[2] != 2021 {
+		t.Fatalf("unexpected years: %v (expected: [2019 2020 2021])", cmp.Years)
+	}
+
+	second := cmp.Addresses[1].SquareMeters
+	if second[0] != nil || *second[1] != 30000 || *second[2] != 32000 {
+		t.Fatalf("unexpected aligned series of second address")
+	}
+
+	rows := cmp.Rows()
+	expected := [][]string{
+		{"Vej 1, 1000 By", "100", "", "C", "2100000", "", "", "20000", "21000", ""},
+		{"Vej 2, 1000 By", "80", "", "", "", "1800000", "2020", "", "30000", "32000"},
+	}
+	for i, row := range rows {
+		if strings.Join(row, ",") != strings.Join(expected[i], ",") {
+			t.Fatalf("unexpected row: %v (expected: %v)", row, expected[i])
+		}
+	}
+
+	if len(cmp.Headers()) != len(rows[0]) {
+		t.Fatalf("unexpected amount of headers: %d (expected: %d)", len(cmp.Headers()), len(rows[0]))
+	}
+}
+
+func TestCompareCSVDownload(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := []*Address{
+				{DawaID: "Vej 1, 1000 By", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+				{DawaID: "Vej 2, 1000 By", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+			}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+
+			bc := fakeBoligaCacher{sales: map[uint][]Sale{
+				addrs[1].ID: {{AddrID: addrs[1].ID, AmountDKK: 2000000, Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}},
+			}}
+			s := &server{db: db, dc: fakeDawaCacher{addrs: addrs}, bc: bc}
+
+			w := httptest.NewRecorder()
+			s.Routes().ServeHTTP(w, httptest.NewRequest("GET", "/download/compare?q=a&q=b&range=500&filter_below_std=1", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status code: %d (expected: %d): %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			records, err := csv.NewReader(w.Body).ReadAll()
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(records) != 3 || records[1][len(records[1])-1] != "20000" {
+				t.Fatalf("unexpected comparison: %v", records)
+			}
+
+			w = httptest.NewRecorder()
+			s.Routes().ServeHTTP(w, httptest.NewRequest("GET", "/download/compare?q=a&range=500&filter_below_std=x", nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("unexpected status code: %d (expected: %d)", w.Code, http.StatusBadRequest)
+			}
+
+			w = httptest.NewRecorder()
+			s.Routes().ServeHTTP(w, httptest.NewRequest("POST", "/api/compare", strings.NewReader(`{"q": []}`)))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("unexpected status code: %d (expected: %d)", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
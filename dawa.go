@@ -4,13 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const (
@@ -22,7 +22,7 @@ var (
 )
 
 type DAWAAddress struct {
-	FullText         string  `json:"betegnelse""`
+	FullText         string  `json:"betegnelse"`
 	StreetName       string  `json:"vejnavn"`
 	StreetNumber     string  `json:"husnr"`
 	Floor            *string `json:"etage"`
@@ -30,7 +30,8 @@ type DAWAAddress struct {
 	PostalCode       string  `json:"postnr"`
 	MunicipalityCode string  `json:"kommunekode"`
 	Latitude         float64 `json:"x"`
-	Longtitude       float64 `json:"y"`
+	Longitude        float64 `json:"y"`
+	AccessAddressID  string  `json:"adgangsadresseid"`
 }
 
 type Address struct {
@@ -43,9 +44,14 @@ type Address struct {
 	PostalCode       string  `json:"zipcode" gorm:"not null"`
 	MunicipalityCode string  `json:"municipality_code" gorm:"not null"`
 	Latitude         float64 `json:"lat" gorm:"not null"`
-	Longtitude       float64 `json:"long" gorm:"not null"`
+	Longitude        float64 `json:"long" gorm:"not null"`
+	BuildingID       *uint   `json:"-" gorm:"index"`
 
-	BoligaCollectedAt         time.Time    `json:"-"`
+	// AccessAddressID is the DAWA access address (adgangsadresse) of a
+	// freshly fetched address, used to link it to its Building.
+	AccessAddressID string `json:"-" gorm:"-"`
+
+	BoligaCollectedAt         time.Time    `json:"collected_at"`
 	BoligaPropertyKind        PropertyType `json:"-"`
 	BoligaBuildingSize        int          `json:"building_size"`
 	BoligaPropertySize        int          `json:"property_size"`
@@ -54,6 +60,27 @@ type Address struct {
 	BoligaBuiltYear           int          `json:"built_year"`
 	BoligaMonthlyOwnerExpense int          `json:"monthly_owner_expense_dkk"`
 	BoligaEnergyMarking       string       `json:"energy_marking"`
+
+	BBRCollectedAt    time.Time `json:"bbr_collected_at"`
+	BBRBuildingSize   int       `json:"-"`
+	BBRBasementSize   int       `json:"-"`
+	BBRRooms          int       `json:"-"`
+	BBRBuiltYear      int       `json:"-"`
+	BBRRenovationYear int       `json:"renovation_year"`
+	BBRRoofMaterial   string    `json:"roof_material"`
+	BBRWallMaterial   string    `json:"wall_material"`
+	BBRHeatingType    string    `json:"heating_type"`
+
+	// Sources tells whether each attribute came from Boliga or BBR, see
+	// ApplyBBR. It is only set on the addresses of a lookup response, and
+	// is left out of its CSV and XLSX exports and of the stored sales.
+	Sources map[string]string `json:"sources,omitempty" gorm:"-"`
+}
+
+// LonLat returns the longitude and latitude of the address. DAWA calls
+// them x and y, which are stored as Latitude and Longitude respectively.
+func (addr Address) LonLat() (float64, float64) {
+	return addr.Latitude, addr.Longitude
 }
 
 func (addr Address) Short() string {
@@ -92,6 +119,10 @@ func (a Address) ToSlice() []string {
 		strconv.Itoa(a.BoligaRooms),
 		strconv.Itoa(a.BoligaBuiltYear),
 		strconv.Itoa(a.BoligaMonthlyOwnerExpense),
+		strconv.Itoa(a.BBRRenovationYear),
+		a.BBRRoofMaterial,
+		a.BBRWallMaterial,
+		a.BBRHeatingType,
 	}
 }
 
@@ -109,119 +140,276 @@ func (a Address) Headers() []string {
 		"rooms",
 		"built_year",
 		"monthly_owner_expense_dkk",
+		"renovation_year",
+		"roof_material",
+		"wall_material",
+		"heating_type",
 	}
 }
 
-type dawaCacher struct {
-	db        *gorm.DB
-	maxAmount float64
-}
+const (
+	DawaKindFuzzy  = "fuzzy"
+	DawaKindNearby = "nearby"
+)
 
-type DawaQueryCache struct {
-	Query     string `gorm:"not null,unique"`
-	IDs       string `gorm:"not null"`
+var (
+	// dawaRequestKinds holds a zero value of each DawaRequest type, and is
+	// used to look up the default TTL of a kind.
+	dawaRequestKinds = []DawaRequest{
+		DawaFuzzySearch{},
+		DawaNearbySearch{},
+	}
+)
+
+// DawaQuery is a cached DAWA request, identified by its canonical URL.
+type DawaQuery struct {
+	ID        uint   `gorm:"primaryKey"`
+	Query     string `gorm:"not null;uniqueIndex"`
+	Kind      string `gorm:"not null;index"`
 	CreatedAt time.Time
 }
 
-func NewDawaQueryCacheFromAddrs(req DawaRequest, addrs []*Address) DawaQueryCache {
-	reqStr := fmt.Sprintf("%s", req.Request().URL)
-
-	ids := make([]string, len(addrs))
-	for i := 0; i < len(addrs); i++ {
-		ids[i] = strconv.Itoa(int(addrs[i].ID))
-	}
+// DawaQueryAddress links a cached DAWA query to the addresses it returned,
+// in the order they were returned.
+type DawaQueryAddress struct {
+	QueryID   uint `gorm:"primaryKey"`
+	AddressID uint `gorm:"primaryKey;index"`
+	Position  int  `gorm:"not null"`
+}
 
-	return DawaQueryCache{
-		Query: reqStr,
-		IDs:   strings.Join(ids, ","),
-	}
+type DawaCacheKindStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int64  `json:"entries"`
+	TTL     string `json:"ttl"`
 }
 
-func (dqc DawaQueryCache) Identifiers() []int {
-	ids := strings.Split(dqc.IDs, ",")
+type DawaCacheStats map[string]DawaCacheKindStats
 
-	uids := make([]int, len(ids))
-	for i := 0; i < len(ids); i++ {
-		id, _ := strconv.Atoi(ids[i])
-		uids[i] = id
-	}
+type dawaCacher struct {
+	db   *gorm.DB
+	ttls map[string]time.Duration
 
-	return uids
+	m      sync.Mutex
+	hits   map[string]uint64
+	misses map[string]uint64
 }
 
 type DawaCacher interface {
 	Do(DawaRequest) ([]*Address, error)
+	Prune() (int64, error)
+	Stats() (DawaCacheStats, error)
 }
 
-func NewDawaCacher(db *gorm.DB) *dawaCacher {
-	db.AutoMigrate(&DawaQueryCache{})
-	db.AutoMigrate(&Address{})
+// NewDawaCacher creates a cacher of DAWA requests. The entries of ttls
+// override the MaxAge of the request kind they are keyed by.
+func NewDawaCacher(db *gorm.DB, ttls map[string]time.Duration) *dawaCacher {
+	t := map[string]time.Duration{}
+	for _, req := range dawaRequestKinds {
+		t[req.Kind()] = req.MaxAge()
+	}
+	for kind, ttl := range ttls {
+		t[kind] = ttl
+	}
 
 	return &dawaCacher{
-		maxAmount: 50.0,
-		db:        db,
+		db:     db,
+		ttls:   t,
+		hits:   map[string]uint64{},
+		misses: map[string]uint64{},
+	}
+}
+
+func dawaQueryKey(req DawaRequest) string {
+	u := *req.Request().URL
+	u.RawQuery = u.Query().Encode()
+
+	return u.String()
+}
+
+func (c *dawaCacher) ttl(req DawaRequest) time.Duration {
+	if ttl, ok := c.ttls[req.Kind()]; ok {
+		return ttl
+	}
+
+	return req.MaxAge()
+}
+
+func (c *dawaCacher) count(kind string, hit bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if hit {
+		c.hits[kind] += 1
+		return
 	}
+
+	c.misses[kind] += 1
 }
 
-func (c dawaCacher) Do(req DawaRequest) ([]*Address, error) {
-	reqStr := fmt.Sprintf("%s", req.Request().URL)
+func (c *dawaCacher) Do(req DawaRequest) ([]*Address, error) {
+	key := dawaQueryKey(req)
 
-	var cache DawaQueryCache
-	var performRequest bool
-	if err := c.db.First(&cache, "query = ?", reqStr).Error; errors.Is(err, gorm.ErrRecordNotFound) {
-		performRequest = true
+	var query DawaQuery
+	err := c.db.First(&query, "query = ?", key).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	cached := err == nil
+
+	if cached && time.Now().Sub(query.CreatedAt) < c.ttl(req) {
+		c.count(req.Kind(), true)
+		return c.queryAddrs(query.ID)
+	}
+	c.count(req.Kind(), false)
+
+	addrs, err := req.Fetch()
+	if err != nil {
+		if cached {
+			// serve stale addresses
+			return c.queryAddrs(query.ID)
+		}
+
+		return nil, err
 	}
 
-	if time.Now().Sub(cache.CreatedAt) >= req.MaxAge() {
-		performRequest = true
+	if err := c.safeCreateOrGetAddrs(addrs); err != nil {
+		return nil, err
 	}
 
-	if performRequest {
-		if cache.Query != "" {
-			if err := c.db.Delete(&cache).Error; err != nil {
-				return nil, err
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		if cached {
+			if err := tx.Delete(&DawaQueryAddress{}, "query_id = ?", query.ID).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Delete(&query).Error; err != nil {
+				return err
 			}
 		}
 
-		addrs, err := req.Fetch()
-		if err := c.safeCreateOrGetAddrs(addrs); err != nil {
-			return nil, err
+		// a concurrent miss of the same query may have cached it first, in
+		// which case its addresses are kept
+		query = DawaQuery{
+			Query: key,
+			Kind:  req.Kind(),
+		}
+		res := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "query"}},
+			DoNothing: true,
+		}).Create(&query)
+		if res.Error != nil {
+			return res.Error
 		}
 
-		cache := NewDawaQueryCacheFromAddrs(req, addrs)
-		if err := c.db.Create(&cache).Error; err != nil {
-			return nil, err
+		if res.RowsAffected == 0 {
+			return nil
 		}
 
-		return addrs, err
-	}
+		if len(addrs) == 0 {
+			return nil
+		}
 
-	var addrs []*Address
-	ids := cache.Identifiers()
-	r := int(math.Ceil(float64(len(ids)) / c.maxAmount))
-	for i := 0; i < r; i++ {
-		var tempAddrs []*Address
-		start, end := int(c.maxAmount)*i, int(c.maxAmount)*(i+1)
-		end = int(math.Min(float64(len(ids)), float64(end)))
-		if err := c.db.Find(&tempAddrs, ids[start:end]).Error; err != nil {
-			return nil, err
+		links := make([]DawaQueryAddress, len(addrs))
+		for i, a := range addrs {
+			links[i] = DawaQueryAddress{
+				QueryID:   query.ID,
+				AddressID: a.ID,
+				Position:  i,
+			}
 		}
 
-		addrs = append(addrs, tempAddrs...)
+		return tx.CreateInBatches(&links, insertBatchSize(tx, &DawaQueryAddress{})).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return addrs, nil
 }
 
-func (c dawaCacher) safeCreateOrGetAddrs(addrs []*Address) error {
-	n := float64(len(addrs))
-	r := int(math.Ceil(n / c.maxAmount))
+func (c *dawaCacher) queryAddrs(queryID uint) ([]*Address, error) {
+	var addrs []*Address
+	err := c.db.
+		Joins("JOIN dawa_query_addresses ON dawa_query_addresses.address_id = addresses.id").
+		Where("dawa_query_addresses.query_id = ?", queryID).
+		Order("dawa_query_addresses.position").
+		Find(&addrs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return addrs, nil
+}
 
-	m := map[string]*Address{}
-	for i := 0; i < r; i++ {
-		start, end := int(c.maxAmount)*i, int(c.maxAmount)*(i+1)
-		end = int(math.Min(n, float64(end)))
+// Prune deletes the cached queries which have outlived the TTL of their
+// kind, and returns the number of queries deleted.
+func (c *dawaCacher) Prune() (int64, error) {
+	var n int64
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		for kind, ttl := range c.ttls {
+			expired := tx.Model(&DawaQuery{}).
+				Select("id").
+				Where("kind = ? AND created_at < ?", kind, time.Now().Add(-ttl))
+
+			if err := tx.Where("query_id IN (?)", expired).Delete(&DawaQueryAddress{}).Error; err != nil {
+				return err
+			}
 
+			res := tx.Where("kind = ? AND created_at < ?", kind, time.Now().Add(-ttl)).Delete(&DawaQuery{})
+			if err := res.Error; err != nil {
+				return err
+			}
+			n += res.RowsAffected
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+// Stats returns the number of cache hits and misses since the cacher was
+// created, along with the number of cached queries, per request kind.
+func (c *dawaCacher) Stats() (DawaCacheStats, error) {
+	type row struct {
+		Kind  string
+		Count int64
+	}
+
+	var rows []row
+	err := c.db.Model(&DawaQuery{}).
+		Select("kind, count(*) as count").
+		Group("kind").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]int64{}
+	for _, r := range rows {
+		entries[r.Kind] = r.Count
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	stats := DawaCacheStats{}
+	for kind, ttl := range c.ttls {
+		stats[kind] = DawaCacheKindStats{
+			Hits:    c.hits[kind],
+			Misses:  c.misses[kind],
+			Entries: entries[kind],
+			TTL:     ttl.String(),
+		}
+	}
+
+	return stats, nil
+}
+
+func (c *dawaCacher) safeCreateOrGetAddrs(addrs []*Address) error {
+	m := map[string]*Address{}
+	err := inBatches(len(addrs), maxBatchSize(c.db), func(start, end int) error {
 		var tempAddrs []*Address
 		ids := make([]string, end-start)
 		for j, a := range addrs[start:end] {
@@ -236,6 +424,11 @@ func (c dawaCacher) safeCreateOrGetAddrs(addrs []*Address) error {
 			a := tempAddrs[j]
 			m[a.DawaID] = a
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	var createAddrs []*Address
@@ -247,18 +440,87 @@ func (c dawaCacher) safeCreateOrGetAddrs(addrs []*Address) error {
 			continue
 		}
 
+		exsts.AccessAddressID = a.AccessAddressID
 		addrs[i] = exsts
 	}
 
-	if err := c.db.CreateInBatches(&createAddrs, int(c.maxAmount)).Error; err != nil {
+	if err := linkBuildings(c.db, addrs); err != nil {
+		return err
+	}
+
+	if len(createAddrs) == 0 {
+		return nil
+	}
+
+	// addresses created by a concurrent request are left as they are, so
+	// the ids of every created address are read back
+	err = c.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "dawa_id"}},
+		DoNothing: true,
+	}).CreateInBatches(&createAddrs, insertBatchSize(c.db, &Address{})).Error
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return inBatches(len(createAddrs), maxBatchSize(c.db), func(start, end int) error {
+		ids := make([]string, end-start)
+		for j, a := range createAddrs[start:end] {
+			ids[j] = a.DawaID
+		}
+
+		var stored []Address
+		if err := c.db.Select("id", "dawa_id").Where("dawa_id IN ?", ids).Find(&stored).Error; err != nil {
+			return err
+		}
+
+		created := map[string]uint{}
+		for _, a := range stored {
+			created[a.DawaID] = a.ID
+		}
+
+		for _, a := range createAddrs[start:end] {
+			a.ID = created[a.DawaID]
+		}
+
+		return nil
+	})
+}
+
+// AddressResolver returns a function resolving an address text to a single
+// address, either stored under that DAWA designation or found by a fuzzy
+// search through dc.
+func AddressResolver(db *gorm.DB, dc DawaCacher) func(string) (*Address, error) {
+	return func(text string) (*Address, error) {
+		var addr Address
+		err := db.First(&addr, "dawa_id = ?", text).Error
+		if err == nil {
+			return &addr, nil
+		}
+
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		addrs, err := dc.Do(DawaFuzzySearch{Query: text})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(addrs) > 1 {
+			return nil, ErrNonUniqueAddr
+		}
+
+		if len(addrs) == 0 {
+			return nil, ErrNoAddr
+		}
+
+		return addrs[0], nil
+	}
 }
 
 type DawaRequest interface {
 	Request() *http.Request
+	Kind() string
 	MaxAge() time.Duration
 	Fetch() ([]*Address, error)
 }
@@ -290,7 +552,8 @@ func reqToAddrs(req *http.Request) ([]*Address, error) {
 			PostalCode:       d.PostalCode,
 			MunicipalityCode: d.MunicipalityCode,
 			Latitude:         d.Latitude,
-			Longtitude:       d.Longtitude,
+			Longitude:        d.Longitude,
+			AccessAddressID:  d.AccessAddressID,
 		}
 	}
 
@@ -319,6 +582,10 @@ func (dfs DawaFuzzySearch) Fetch() ([]*Address, error) {
 	return reqToAddrs(req)
 }
 
+func (dfs DawaFuzzySearch) Kind() string {
+	return DawaKindFuzzy
+}
+
 func (dfs DawaFuzzySearch) MaxAge() time.Duration {
 	return 365 * 24 * time.Hour
 }
@@ -330,7 +597,7 @@ type DawaNearbySearch struct {
 
 func (dns DawaNearbySearch) Request() *http.Request {
 	req, _ := http.NewRequest("GET", addrEndpoint, nil)
-	qStr := fmt.Sprintf("%f,%f,%d", dns.Addr.Latitude, dns.Addr.Longtitude, dns.Meters)
+	qStr := fmt.Sprintf("%f,%f,%d", dns.Addr.Latitude, dns.Addr.Longitude, dns.Meters)
 
 	q := req.URL.Query()
 	q.Add("cirkel", qStr)
@@ -344,6 +611,10 @@ func (dns DawaNearbySearch) Fetch() ([]*Address, error) {
 	return reqToAddrs(req)
 }
 
+func (dns DawaNearbySearch) Kind() string {
+	return DawaKindNearby
+}
+
 func (dfs DawaNearbySearch) MaxAge() time.Duration {
 	return 365 * 24 * time.Hour
 }
@@ -0,0 +1,211 @@
+package hjem
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type fakeDawaRequest struct {
+	query   string
+	fetches *int
+	addrs   []*Address
+}
+
+func (r fakeDawaRequest) Request() *http.Request {
+	req, _ := http.NewRequest("GET", addrEndpoint+"?q="+r.query, nil)
+	return req
+}
+
+func (r fakeDawaRequest) Kind() string {
+	return "fake"
+}
+
+func (r fakeDawaRequest) MaxAge() time.Duration {
+	return time.Hour
+}
+
+func (r fakeDawaRequest) Fetch() ([]*Address, error) {
+	*r.fetches += 1
+
+	addrs := make([]*Address, len(r.addrs))
+	for i, a := range r.addrs {
+		cp := *a
+		addrs[i] = &cp
+	}
+
+	return addrs, nil
+}
+
+func TestDawaCacherDo(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			testDawaCacherDo(t, db)
+		})
+	}
+}
+
+func testDawaCacherDo(t *testing.T, db *gorm.DB) {
+	dc := NewDawaCacher(db, map[string]time.Duration{"fake": time.Hour})
+
+	var fetches int
+	req := fakeDawaRequest{
+		query:   "vej",
+		fetches: &fetches,
+		addrs: []*Address{
+			{DawaID: "Vej 2, 1000 By", StreetName: "Vej", StreetNumber: "2"},
+			{DawaID: "Vej 1, 1000 By", StreetName: "Vej", StreetNumber: "1"},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		addrs, err := dc.Do(req)
+		if err != nil {
+			t.Fatalf("received unexpected error: %s", err)
+		}
+
+		if len(addrs) != 2 {
+			t.Fatalf("unexpected amount of addresses: %d (expected: 2)", len(addrs))
+		}
+
+		if addrs[0].DawaID != "Vej 2, 1000 By" {
+			t.Fatalf("unexpected order of addresses: %s first", addrs[0].DawaID)
+		}
+	}
+
+	if fetches != 1 {
+		t.Fatalf("unexpected amount of fetches: %d (expected: 1)", fetches)
+	}
+
+	stats, err := dc.Stats()
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	s := stats["fake"]
+	if s.Hits != 1 || s.Misses != 1 || s.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestDawaCacherPrune(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			testDawaCacherPrune(t, db)
+		})
+	}
+}
+
+func testDawaCacherPrune(t *testing.T, db *gorm.DB) {
+	dc := NewDawaCacher(db, map[string]time.Duration{"fake": time.Hour})
+
+	var fetches int
+	for _, q := range []string{"a", "b"} {
+		req := fakeDawaRequest{
+			query:   q,
+			fetches: &fetches,
+			addrs:   []*Address{{DawaID: q, StreetName: q, StreetNumber: "1"}},
+		}
+		if _, err := dc.Do(req); err != nil {
+			t.Fatalf("received unexpected error: %s", err)
+		}
+	}
+
+	db.Model(&DawaQuery{}).Where("query LIKE ?", "%q=a").Update("created_at", time.Now().Add(-2*time.Hour))
+
+	n, err := dc.Prune()
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	if n != 1 {
+		t.Fatalf("unexpected amount of pruned queries: %d (expected: 1)", n)
+	}
+
+	var links int64
+	db.Model(&DawaQueryAddress{}).Count(&links)
+	if links != 1 {
+		t.Fatalf("unexpected amount of query addresses: %d (expected: 1)", links)
+	}
+}
+
+// barrierDawaRequest is a fakeDawaRequest whose fetches wait for each other,
+// such that concurrent cache misses overlap.
+type barrierDawaRequest struct {
+	fakeDawaRequest
+	barrier *sync.WaitGroup
+}
+
+func (r barrierDawaRequest) Fetch() ([]*Address, error) {
+	r.barrier.Done()
+	r.barrier.Wait()
+
+	addrs := make([]*Address, len(r.addrs))
+	for i, a := range r.addrs {
+		cp := *a
+		addrs[i] = &cp
+	}
+
+	return addrs, nil
+}
+
+func TestDawaCacherConcurrentMiss(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			dc := NewDawaCacher(db, map[string]time.Duration{"fake": time.Hour})
+
+			const n = 4
+			var fetches int
+			var barrier sync.WaitGroup
+			barrier.Add(n)
+			req := barrierDawaRequest{
+				fakeDawaRequest: fakeDawaRequest{
+					query:   "vej",
+					fetches: &fetches,
+					addrs: []*Address{
+						{DawaID: "Vej 1, 1000 By", StreetName: "Vej", StreetNumber: "1"},
+						{DawaID: "Vej 2, 1000 By", StreetName: "Vej", StreetNumber: "2"},
+					},
+				},
+				barrier: &barrier,
+			}
+
+			errs := make([]error, n)
+			results := make([][]*Address, n)
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					results[i], errs[i] = dc.Do(req)
+				}(i)
+			}
+			wg.Wait()
+
+			for i, err := range errs {
+				if err != nil {
+					t.Fatalf("received unexpected error: %s", err)
+				}
+
+				if len(results[i]) != 2 || results[i][0].ID == 0 || results[i][0].ID != results[0][0].ID {
+					t.Fatalf("unexpected addresses: %+v (expected the stored addresses)", results[i])
+				}
+			}
+
+			var count int64
+			db.Model(&DawaQuery{}).Count(&count)
+			if count != 1 {
+				t.Fatalf("unexpected amount of cached queries: %d (expected: 1)", count)
+			}
+
+			// the query is served from the cache afterwards
+			addrs, err := dc.Do(req.fakeDawaRequest)
+			if err != nil || len(addrs) != 2 || fetches != 0 {
+				t.Fatalf("unexpected cached addresses: %v, err: %v", addrs, err)
+			}
+		})
+	}
+}
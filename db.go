@@ -0,0 +1,77 @@
+package hjem
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+var (
+	ErrUnknownDriver = errors.New("unknown database driver")
+)
+
+// OpenDB opens a database using one of the supported drivers. For SQLite
+// the dsn is the path of the database file, while for PostgreSQL it is
+// a connection string, e.g. "host=localhost user=hjem dbname=hjem".
+func OpenDB(driver, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverSQLite:
+		dialector = sqlite.Open(dsn)
+	case DriverPostgres:
+		dialector = postgres.Open(dsn)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDriver, driver)
+	}
+
+	return gorm.Open(dialector, &gorm.Config{})
+}
+
+// maxBatchSize returns how many values can be bound to a single query,
+// leaving room for the remaining parameters of the query. SQLite is
+// commonly compiled to allow no more than 999 variables per statement,
+// while PostgreSQL allows 65535.
+func maxBatchSize(db *gorm.DB) int {
+	switch db.Dialector.Name() {
+	case DriverPostgres:
+		return 10000
+	default:
+		return 500
+	}
+}
+
+// insertBatchSize returns how many rows of model can be inserted by a
+// single statement.
+func insertBatchSize(db *gorm.DB, model interface{}) int {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil || len(stmt.Schema.DBNames) == 0 {
+		return 1
+	}
+
+	return maxBatchSize(db) / len(stmt.Schema.DBNames)
+}
+
+// inBatches calls fn with consecutive [start, end) ranges covering n
+// elements, where no range is larger than size.
+func inBatches(n, size int, fn func(start, end int) error) error {
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+
+		if err := fn(start, end); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package hjem
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// testDBs returns a migrated database for each backend available to the
+// tests.
+func testDBs(t *testing.T) map[string]*gorm.DB {
+	t.Helper()
+
+	dbs := map[string]*gorm.DB{}
+	for name, db := range emptyTestDBs(t) {
+		if err := Migrate(db); err != nil {
+			t.Fatalf("unable to migrate %s database: %s", name, err)
+		}
+
+		dbs[name] = db
+	}
+
+	return dbs
+}
+
+// emptyTestDBs returns an empty database for each backend available to the
+// tests. SQLite is always available, while PostgreSQL is tested when
+// HJEM_TEST_POSTGRES_DSN holds the connection string of a database in
+// which the tests may create schemas.
+func emptyTestDBs(t *testing.T) map[string]*gorm.DB {
+	t.Helper()
+
+	db, err := OpenDB(DriverSQLite, filepath.Join(t.TempDir(), "hjem.db"))
+	if err != nil {
+		t.Fatalf("unable to open sqlite database: %s", err)
+	}
+
+	dbs := map[string]*gorm.DB{
+		DriverSQLite: db,
+	}
+
+	dsn := os.Getenv("HJEM_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		return dbs
+	}
+
+	admin, err := OpenDB(DriverPostgres, dsn)
+	if err != nil {
+		t.Fatalf("unable to open postgres database: %s", err)
+	}
+
+	schema := fmt.Sprintf("hjem_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("unable to create postgres schema: %s", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+	})
+
+	db, err = OpenDB(DriverPostgres, withSearchPath(t, dsn, schema))
+	if err != nil {
+		t.Fatalf("unable to open postgres database: %s", err)
+	}
+	dbs[DriverPostgres] = db
+
+	return dbs
+}
+
+// withSearchPath returns the connection string dsn, using the schema for
+// every connection. Both URLs and keyword/value connection strings are
+// supported.
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	t.Helper()
+
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("unable to parse postgres connection string: %s", err)
+	}
+
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func TestWithSearchPath(t *testing.T) {
+	tt := []struct {
+		name string
+		dsn  string
+		out  string
+	}{
+		{name: "keyword/value", dsn: "host=localhost user=hjem", out: "host=localhost user=hjem search_path=test"},
+		{name: "url", dsn: "postgres://hjem@localhost/hjem", out: "postgres://hjem@localhost/hjem?search_path=test"},
+		{name: "url with parameters", dsn: "postgresql://hjem@localhost/hjem?sslmode=disable", out: "postgresql://hjem@localhost/hjem?search_path=test&sslmode=disable"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if out := withSearchPath(t, tc.dsn, "test"); out != tc.out {
+				t.Fatalf("unexpected connection string: %s (expected: %s)", out, tc.out)
+			}
+		})
+	}
+}
+
+func TestInBatches(t *testing.T) {
+	tt := []struct {
+		name string
+		n    int
+		size int
+		out  [][2]int
+	}{
+		{name: "empty", n: 0, size: 10},
+		{name: "single batch", n: 5, size: 10, out: [][2]int{{0, 5}}},
+		{name: "exact batches", n: 4, size: 2, out: [][2]int{{0, 2}, {2, 4}}},
+		{name: "remainder", n: 5, size: 2, out: [][2]int{{0, 2}, {2, 4}, {4, 5}}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var out [][2]int
+			inBatches(tc.n, tc.size, func(start, end int) error {
+				out = append(out, [2]int{start, end})
+				return nil
+			})
+
+			if fmt.Sprint(out) != fmt.Sprint(tc.out) {
+				t.Fatalf("unexpected batches: %v (expected: %v)", out, tc.out)
+			}
+		})
+	}
+}
+
+func TestSafeCreateOrGetAddrs(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			dc := NewDawaCacher(db, nil)
+
+			// more addresses than sqlite allows variables in a statement
+			n := 1500
+			addrs := make([]*Address, n)
+			for i := range addrs {
+				addrs[i] = &Address{
+					DawaID:       fmt.Sprintf("Vej %d, 1000 By", i),
+					StreetName:   "Vej",
+					StreetNumber: fmt.Sprint(i),
+				}
+			}
+
+			if err := dc.safeCreateOrGetAddrs(addrs); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			again := make([]*Address, n)
+			for i := range again {
+				again[i] = &Address{DawaID: addrs[i].DawaID}
+			}
+
+			if err := dc.safeCreateOrGetAddrs(again); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			for i := range again {
+				if again[i].ID != addrs[i].ID {
+					t.Fatalf("unexpected id of %s: %d (expected: %d)", again[i].DawaID, again[i].ID, addrs[i].ID)
+				}
+			}
+
+			var count int64
+			db.Model(&Address{}).Count(&count)
+			if count != int64(n) {
+				t.Fatalf("unexpected amount of addresses: %d (expected: %d)", count, n)
+			}
+		})
+	}
+}
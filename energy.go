@@ -0,0 +1,133 @@
+package hjem
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EnergyLabels lists the normalized energy labels, from best to worst.
+var EnergyLabels = []string{"A2020", "A2015", "A2010", "B", "C", "D", "E", "F", "G"}
+
+// NormalizeEnergyLabel maps an energy marking as found on Boliga onto one
+// of EnergyLabels. The A1 and A2 labels of the former scheme, along with
+// a plain A, are considered A2010. Unknown markings yield "".
+func NormalizeEnergyLabel(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+
+		return -1
+	}, s)
+
+	switch s {
+	case "A2020", "A2015", "A2010":
+		return s
+	case "A", "A1", "A2":
+		return "A2010"
+	case "B", "C", "D", "E", "F", "G":
+		return s
+	}
+
+	return ""
+}
+
+func energyLabelRank(label string) (int, bool) {
+	for i, l := range EnergyLabels {
+		if l == label {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// EnergyLabelStats is the price per square meter of the sales of addresses
+// with an energy label. Index is the mean ratio between the price per
+// square meter of each sale and the mean of its sale year.
+type EnergyLabelStats struct {
+	Label string  `json:"label"`
+	N     int     `json:"n"`
+	Index float64 `json:"index"`
+}
+
+// EnergyUpgrade is the estimated effect on the price of the primary address
+// of upgrading its energy label.
+type EnergyUpgrade struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Effect    float64 `json:"effect"`
+	EffectDKK int     `json:"effect_dkk,omitempty"`
+}
+
+type EnergyAnalysis struct {
+	Labels   []EnergyLabelStats `json:"labels"`
+	Upgrades []EnergyUpgrade    `json:"upgrades,omitempty"`
+}
+
+// AnalyseEnergyLabels breaks down the price per square meter of the sales by
+// the energy label at the time of each sale. The price effect of upgrading
+// primary to each better label is estimated from the ratio between the
+// indices of the labels, and valued using the latest yearly mean price per
+// square meter and the size of primary.
+func AnalyseEnergyLabels(primary *Address, sales []*JSONSale, yearly map[time.Time]Aggregation) *EnergyAnalysis {
+	indices := yearlyIndices(sales, yearly, func(s *JSONSale) (int, bool) {
+		return energyLabelRank(s.EnergyLabel)
+	})
+	if len(indices) == 0 {
+		return nil
+	}
+
+	var analysis EnergyAnalysis
+	for rank, idx := range indices {
+		analysis.Labels = append(analysis.Labels, EnergyLabelStats{
+			Label: EnergyLabels[rank],
+			N:     idx.N,
+			Index: idx.Mean,
+		})
+	}
+
+	sort.Slice(analysis.Labels, func(i, j int) bool {
+		ri, _ := energyLabelRank(analysis.Labels[i].Label)
+		rj, _ := energyLabelRank(analysis.Labels[j].Label)
+		return ri < rj
+	})
+
+	from := NormalizeEnergyLabel(primary.BoligaEnergyMarking)
+	fromRank, ok := energyLabelRank(from)
+	if !ok {
+		return &analysis
+	}
+
+	current, ok := indices[fromRank]
+	if !ok {
+		return &analysis
+	}
+
+	var latest time.Time
+	for year := range yearly {
+		if year.After(latest) {
+			latest = year
+		}
+	}
+	value := float64(yearly[latest].Mean*primary.BoligaBuildingSize) * current.Mean
+
+	for rank := fromRank - 1; rank >= 0; rank-- {
+		idx, ok := indices[rank]
+		if !ok {
+			continue
+		}
+
+		effect := idx.Mean/current.Mean - 1
+		analysis.Upgrades = append(analysis.Upgrades, EnergyUpgrade{
+			From:      from,
+			To:        EnergyLabels[rank],
+			Effect:    effect,
+			EffectDKK: int(value * effect),
+		})
+	}
+
+	return &analysis
+}
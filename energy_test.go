@@ -0,0 +1,66 @@
+package hjem
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNormalizeEnergyLabel(t *testing.T) {
+	tt := []struct {
+		in  string
+		out string
+	}{
+		{in: "a2020", out: "A2020"},
+		{in: "A 2015", out: "A2015"},
+		{in: "a2", out: "A2010"},
+		{in: "a", out: "A2010"},
+		{in: "c", out: "C"},
+		{in: " g ", out: "G"},
+		{in: "h", out: ""},
+		{in: "", out: ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.in, func(t *testing.T) {
+			if o := NormalizeEnergyLabel(tc.in); o != tc.out {
+				t.Fatalf("unexpected output: %s (expected: %s)", o, tc.out)
+			}
+		})
+	}
+}
+
+func TestAnalyseEnergyLabels(t *testing.T) {
+	y2020 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	yearly := map[time.Time]Aggregation{
+		y2020: {Mean: 50000},
+	}
+	sales := []*JSONSale{
+		{Amount: 4500000, When: y2020, BuildingSize: 100, EnergyLabel: "D"},
+		{Amount: 5500000, When: y2020, BuildingSize: 100, EnergyLabel: "B"},
+		{Amount: 5000000, When: y2020, BuildingSize: 100},
+	}
+	primary := &Address{BoligaEnergyMarking: "d", BoligaBuildingSize: 100}
+
+	analysis := AnalyseEnergyLabels(primary, sales, yearly)
+	if analysis == nil || len(analysis.Labels) != 2 {
+		t.Fatalf("unexpected labels: %+v", analysis)
+	}
+
+	if analysis.Labels[0].Label != "B" {
+		t.Fatalf("unexpected order of labels: %s first", analysis.Labels[0].Label)
+	}
+
+	if len(analysis.Upgrades) != 1 {
+		t.Fatalf("unexpected amount of upgrades: %d (expected: 1)", len(analysis.Upgrades))
+	}
+
+	u := analysis.Upgrades[0]
+	if u.From != "D" || u.To != "B" || math.Abs(u.Effect-(55.0/45.0-1)) > 1e-9 {
+		t.Fatalf("unexpected upgrade: %+v", u)
+	}
+
+	if u.EffectDKK != 1000000 {
+		t.Fatalf("unexpected effect: %d (expected: 1000000)", u.EffectDKK)
+	}
+}
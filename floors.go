@@ -0,0 +1,97 @@
+package hjem
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseFloor parses a DAWA floor designation into a floor level, where
+// "st" (stuen) is the ground floor, "kl" (kælder) the basement and "k1",
+// "k2", ... the basement levels.
+func ParseFloor(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "st":
+		return 0, true
+	case "kl":
+		return -1, true
+	}
+
+	if strings.HasPrefix(s, "k") {
+		n, err := strconv.Atoi(s[1:])
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+
+		return -n, true
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// FloorLevel returns the floor level of the address, if it has a floor.
+func (addr Address) FloorLevel() (int, bool) {
+	if addr.Floor == nil {
+		return 0, false
+	}
+
+	return ParseFloor(*addr.Floor)
+}
+
+// FloorPremium is the price per square meter of the sales on a floor level.
+// Index is the mean ratio between the price per square meter of each sale
+// and the mean of its sale year, while Premium is the index relative to
+// the ground floor, or the lowest floor sold when the ground floor has no
+// sales.
+type FloorPremium struct {
+	Floor   int     `json:"floor"`
+	N       int     `json:"n"`
+	Index   float64 `json:"index"`
+	Premium float64 `json:"premium"`
+}
+
+// FloorPremiums computes the premium of each floor level among the sales,
+// using yearly to normalize the price per square meter of each sale by its
+// sale year.
+func FloorPremiums(addrs []*Address, sales []*JSONSale, yearly map[time.Time]Aggregation) []FloorPremium {
+	indices := yearlyIndices(sales, yearly, func(s *JSONSale) (int, bool) {
+		return addrs[s.AddrIndex].FloorLevel()
+	})
+	if len(indices) == 0 {
+		return nil
+	}
+
+	out := make([]FloorPremium, 0, len(indices))
+	for floor, idx := range indices {
+		out = append(out, FloorPremium{
+			Floor: floor,
+			N:     idx.N,
+			Index: idx.Mean,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Floor < out[j].Floor
+	})
+
+	base := out[0]
+	for _, fp := range out {
+		if fp.Floor == 0 {
+			base = fp
+			break
+		}
+	}
+
+	for i := range out {
+		out[i].Premium = out[i].Index/base.Index - 1
+	}
+
+	return out
+}
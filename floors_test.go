@@ -0,0 +1,72 @@
+package hjem
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseFloor(t *testing.T) {
+	tt := []struct {
+		in    string
+		out   int
+		valid bool
+	}{
+		{in: "st", out: 0, valid: true},
+		{in: "ST", out: 0, valid: true},
+		{in: "kl", out: -1, valid: true},
+		{in: "k2", out: -2, valid: true},
+		{in: "1", out: 1, valid: true},
+		{in: " 12 ", out: 12, valid: true},
+		{in: "tv"},
+		{in: "k"},
+		{in: ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.in, func(t *testing.T) {
+			o, ok := ParseFloor(tc.in)
+			if ok != tc.valid {
+				t.Fatalf("unexpected validity: %t (expected: %t)", ok, tc.valid)
+			}
+
+			if o != tc.out {
+				t.Fatalf("unexpected output: %d (expected: %d)", o, tc.out)
+			}
+		})
+	}
+}
+
+func TestFloorPremiums(t *testing.T) {
+	floor := func(s string) *string { return &s }
+	y2019 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	y2020 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	addrs := []*Address{
+		{Floor: floor("st")},
+		{Floor: floor("3")},
+		{},
+	}
+	yearly := map[time.Time]Aggregation{
+		y2019: {Mean: 40000},
+		y2020: {Mean: 50000},
+	}
+	sales := []*JSONSale{
+		{AddrIndex: 0, Amount: 4000000, When: y2019, BuildingSize: 100},
+		{AddrIndex: 1, Amount: 6000000, When: y2020, BuildingSize: 100},
+		{AddrIndex: 2, Amount: 9000000, When: y2020, BuildingSize: 100},
+	}
+
+	premiums := FloorPremiums(addrs, sales, yearly)
+	if len(premiums) != 2 {
+		t.Fatalf("unexpected amount of floors: %d (expected: 2)", len(premiums))
+	}
+
+	if premiums[0].Floor != 0 || premiums[0].Premium != 0 {
+		t.Fatalf("unexpected ground floor: %+v", premiums[0])
+	}
+
+	if p := premiums[1].Premium; premiums[1].Floor != 3 || math.Abs(p-0.2) > 1e-9 {
+		t.Fatalf("unexpected premium of third floor: %+v (expected: 0.2)", premiums[1])
+	}
+}
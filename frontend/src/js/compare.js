@@ -0,0 +1,55 @@
+import Chart from 'chart.js/auto';
+
+const _tension = 0.3;
+const colors = [
+    'rgb(255, 183, 0)',
+    'rgb(70, 133, 227)',
+    'rgb(220, 60, 60)',
+    'rgb(60, 170, 90)',
+    'rgb(140, 90, 200)',
+];
+
+// CompareChart draws the mean price per square meter around each of the
+// addresses of a comparison, as one line per address, and links to the
+// comparison as CSV in the element linkId.
+function CompareChart(id, linkId) {
+    const ctx = document.getElementById(id);
+    const link = document.getElementById(linkId);
+    var plot = new Chart(ctx, {
+	type: 'line',
+	data: {
+	    labels: [],
+	    datasets: [],
+	},
+	options: {
+	    spanGaps: true,
+	    scales: {
+		y: {
+		    title: {
+			display: true,
+			text: "Kvadratmeterpris",
+		    },
+		}
+	    },
+	}
+    });
+
+    let update = (resp, params) => {
+	plot.data.labels = resp.years.map(y => String(y));
+	plot.data.datasets = resp.addresses.map((a, i) => ({
+	    label: a.address.full_txt,
+	    tension: _tension,
+	    borderColor: colors[i % colors.length],
+	    backgroundColor: colors[i % colors.length],
+	    data: a.sqmeters,
+	}));
+	plot.update();
+
+	link.href = "/download/compare" + params;
+	link.parentElement.style.display = '';
+    }
+
+    return update
+}
+
+export { CompareChart }
@@ -1,63 +1,71 @@
 import { ScatterPricesChart } from './scatter_prices.js';
 import { SquareMeterPricesChart } from './sqmeter_prices.js';
+import { MapPanel } from './map.js';
+import { CompareChart } from './compare.js';
 
 const updates = [
     ScatterPricesChart('prices'),
-    SquareMeterPricesChart('sqmeters')
+    SquareMeterPricesChart('sqmeters'),
+    MapPanel('map', 'history')
 ]
 
 const loader = document.getElementById( "loader-icon" );
 const errorbox = document.getElementById( "error-msg" );
 const datasets = document.getElementById( "datasets" );
 const csvlink = document.getElementById( "csvlink" );
+const geojsonlink = document.getElementById( "geojsonlink" );
+const xlsxlink = document.getElementById( "xlsxlink" );
+const share = document.getElementById( "share" );
+const savelink = document.getElementById( "savelink" );
+const permalink = document.getElementById( "permalink" );
 const errorTrans = {
-    "non-unique address": "Der findes flere addresser med den beskrivelse, vær mere præcis",
-    "no found address": "Kunne ikke finde nogen addresser udfra den søgning"
+    "non_unique_address": "Der findes flere addresser med den beskrivelse, vær mere præcis",
+    "address_not_found": "Kunne ikke finde nogen addresser udfra den søgning"
 }
 
 const endpoint = '';
 
-function performSearch() {
+// shows an error of the API, translated by its code when possible. The
+// endpoints outside of the v1 API reply with the message only.
+function showError(err) {
+    if (typeof err === 'string') {
+	errorbox.innerHTML = err;
+    } else {
+	errorbox.innerHTML = errorTrans[err.code] || err.message;
+    }
+    errorbox.style.display = '';
+}
+
+function render(req, resp) {
+    const params = "?q=" + encodeURIComponent(req.q) + "&range=" +
+	encodeURIComponent(req.ranges[0]);
+    csvlink.href = endpoint + "/download/csv" + params;
+    geojsonlink.href = endpoint + "/download/geojson" + params;
+    xlsxlink.href = endpoint + "/download/xlsx" + params;
+
+    datasets.style.display = '';
+    share.style.display = '';
+
+    for (const f of updates) {
+	f(resp, params);
+    }
+}
+
+function request(method, url, body, onLoad) {
     loader.style.display = '';
     errorbox.style.display = 'none';
-    datasets.style.display = 'none';
 
     const XHR = new XMLHttpRequest();
-    const fd = new FormData(form);
-    const query = fd.get("query");
-    const filter = Number(fd.get("filter"));
-    const range = Number(fd.get("range"));
-
-
     XHR.addEventListener( "load", function(event) {
 	loader.style.display = 'none';
 	const resp = JSON.parse(event.target.responseText);
 
-	if (resp.error !== undefined) {
-	    const err = resp.error;
-	    errorbox.innerHTML = err;
-
-	    for (const s in errorTrans) {
-		if(err.toLowerCase().includes(s)) {
-		    errorbox.innerHTML = errorTrans[s];
-		    break
-		}
-	    }
-
-	    errorbox.style.display = '';
+	if (event.target.status >= 400) {
+	    showError(resp.error);
 	    return
 	}
 
-	var csvUrl = endpoint + "/download/csv?q=" +
-	    encodeURIComponent(query) + "&range=" +
-	    encodeURIComponent(range);
-	csvlink.href = csvUrl;
-
-	datasets.style.display = '';
-
-	for (const f of updates) {
-	    f(resp);
-	}
+	onLoad(resp);
     });
 
     XHR.addEventListener( "error", function( event ) {
@@ -66,13 +74,65 @@ function performSearch() {
 	errorbox.style.display = '';
     } );
 
-    XHR.open( "POST", endpoint + "/api/lookup" );
-    XHR.setRequestHeader("Content-Type", "application/json");
-    XHR.send(JSON.stringify({
-	"q": query,
-	"ranges": [range],
-	"filter_below_std": filter,
-    }));
+    XHR.open( method, endpoint + url );
+    if (body !== undefined) {
+	XHR.setRequestHeader("Content-Type", "application/json");
+	XHR.send(JSON.stringify(body));
+	return
+    }
+
+    XHR.send();
+}
+
+function currentRequest() {
+    const fd = new FormData(form);
+
+    return {
+	"q": fd.get("query"),
+	"ranges": [Number(fd.get("range"))],
+	"filter_below_std": Number(fd.get("filter")),
+    };
+}
+
+function performSearch() {
+    datasets.style.display = 'none';
+    share.style.display = 'none';
+    permalink.innerHTML = '';
+
+    const req = currentRequest();
+    const params = "?q=" + encodeURIComponent(req.q) + "&range=" +
+	encodeURIComponent(req.ranges[0]) + "&filter_below_std=" +
+	encodeURIComponent(req.filter_below_std);
+    request("GET", "/api/v1/lookups" + params, undefined, function(resp) {
+	render(req, resp);
+    });
+}
+
+function showPermalink(saved) {
+    permalink.href = saved.url;
+    permalink.innerHTML = window.location.origin + saved.url;
+}
+
+// saves the current search, and links to its permalink
+function saveSearch() {
+    request("POST", "/api/v1/lookups", currentRequest(), function(saved) {
+	history.pushState(null, "", saved.url);
+	showPermalink(saved);
+    });
+}
+
+// renders the saved lookup of a permalink, e.g. /l/<id>
+function loadPermalink(id) {
+    request("GET", "/api/v1/lookups/" + encodeURIComponent(id), undefined, function(saved) {
+	form.elements["query"].value = saved.q;
+	form.elements["filter"].value = saved.filter_below_std;
+	if (saved.ranges.length > 0) {
+	    form.elements["range"].value = saved.ranges[0];
+	}
+
+	render(saved, saved.result);
+	showPermalink(saved);
+    });
 }
 
 const form = document.getElementById( "search" );
@@ -81,3 +141,36 @@ form.addEventListener( "submit", function ( event ) {
     event.preventDefault();
     performSearch();
 });
+
+savelink.addEventListener( "click", function ( event ) {
+    event.preventDefault();
+    saveSearch();
+});
+
+const compareForm = document.getElementById( "compare" );
+const updateComparison = CompareChart('comparison', 'comparelink');
+
+// compares the addresses of the compare form, using the range and filter
+// of the search form
+function performComparison() {
+    const req = currentRequest();
+    req.q = compareForm.elements["queries"].value.split("\n").
+	map(q => q.trim()).
+	filter(q => q !== "");
+
+    const params = "?" + req.q.map(q => "q=" + encodeURIComponent(q)).join("&") +
+	"&range=" + encodeURIComponent(req.ranges[0]) +
+	"&filter_below_std=" + encodeURIComponent(req.filter_below_std);
+    request("POST", "/api/compare", req, function(resp) {
+	updateComparison(resp, params);
+    });
+}
+
+compareForm.addEventListener( "submit", function ( event ) {
+    event.preventDefault();
+    performComparison();
+});
+
+if (window.location.pathname.startsWith("/l/")) {
+    loadPermalink(window.location.pathname.substring(3));
+}
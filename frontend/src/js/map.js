@@ -0,0 +1,76 @@
+// MapPanel fills the element mapId with the server-rendered map of a
+// lookup, drawn from the result of the lookup, and lists the sales of an address in the element historyId when
+// its marker is clicked.
+export function MapPanel(mapId, historyId) {
+    const mapEl = document.getElementById(mapId);
+    const historyEl = document.getElementById(historyId);
+    var state = null;
+
+    function showHistory() {
+	historyEl.innerHTML = '';
+	const m = window.location.hash.match(/^#addr-(\d+)$/);
+	if (state === null || m === null) {
+	    historyEl.style.display = 'none';
+	    return;
+	}
+
+	const idx = parseInt(m[1]);
+	const addr = state.addresses[idx];
+	if (addr === undefined) {
+	    historyEl.style.display = 'none';
+	    return;
+	}
+
+	const title = document.createElement('h3');
+	title.textContent = addr.full_txt;
+	historyEl.appendChild(title);
+
+	const sales = state.sales.filter(s => s.addr_idx === idx);
+	if (sales.length === 0) {
+	    const p = document.createElement('p');
+	    p.textContent = 'Ingen handler';
+	    historyEl.appendChild(p);
+	}
+
+	const list = document.createElement('ul');
+	for (const s of sales) {
+	    const li = document.createElement('li');
+	    var text = `${s.when.substring(0, 10)}: ${s.amount.toLocaleString('da-DK')} kr.`;
+	    if (s.building_size > 0) {
+		text += ` (${Math.round(s.amount / s.building_size).toLocaleString('da-DK')} kr/m²)`;
+	    }
+	    li.textContent = text;
+	    list.appendChild(li);
+	}
+	historyEl.appendChild(list);
+	historyEl.style.display = '';
+    }
+
+    window.addEventListener('hashchange', showHistory);
+
+    return (resp) => {
+	state = resp;
+	showHistory();
+
+	const XHR = new XMLHttpRequest();
+	XHR.addEventListener('load', function(event) {
+	    if (event.target.status !== 200) {
+		mapEl.style.display = 'none';
+		return;
+	    }
+
+	    mapEl.innerHTML = event.target.responseText;
+	    mapEl.style.display = '';
+	});
+	// the sales are summarized by the lookup, so only the summaries are
+	// sent along
+	XHR.open('POST', '/map');
+	XHR.setRequestHeader('Content-Type', 'application/json');
+	XHR.send(JSON.stringify({
+	    primary_idx: resp.primary_idx,
+	    addresses: resp.addresses.map(a => ({ full_txt: a.full_txt })),
+	    ranges: resp.ranges,
+	    summaries: resp.summaries
+	}));
+    }
+}
@@ -0,0 +1,117 @@
+package hjem
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// circleSegments is the number of segments of the polygons approximating
+// the range circles of a lookup.
+const circleSegments = 64
+
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// LookupGeoJSON returns the addresses of a lookup as points, with their
+// attributes and sales as properties, followed by the circle of each range
+// around the primary address as a polygon.
+func LookupGeoJSON(resp *LookupResponse) (GeoJSONFeatureCollection, error) {
+	fc := GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []GeoJSONFeature{},
+	}
+
+	sales := make([][]*JSONSale, len(resp.Addrs))
+	for _, s := range resp.Sales {
+		sales[s.AddrIndex] = append(sales[s.AddrIndex], s)
+	}
+
+	for i, a := range resp.Addrs {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return fc, err
+		}
+
+		props := map[string]interface{}{}
+		if err := json.Unmarshal(b, &props); err != nil {
+			return fc, err
+		}
+
+		// the coordinates are given by the geometry
+		delete(props, "lat")
+		delete(props, "long")
+
+		props["kind"] = "address"
+		props["primary"] = i == resp.PrimaryIndex
+		props["sales"] = sales[i]
+		if sales[i] == nil {
+			props["sales"] = []*JSONSale{}
+		}
+
+		lon, lat := a.LonLat()
+		fc.Features = append(fc.Features, GeoJSONFeature{
+			Type: "Feature",
+			Geometry: GeoJSONGeometry{
+				Type:        "Point",
+				Coordinates: []float64{lon, lat},
+			},
+			Properties: props,
+		})
+	}
+
+	var ranges []int
+	for meters := range resp.Ranges {
+		ranges = append(ranges, meters)
+	}
+	sort.Ints(ranges)
+
+	lon, lat := resp.Addrs[resp.PrimaryIndex].LonLat()
+	for _, meters := range ranges {
+		fc.Features = append(fc.Features, GeoJSONFeature{
+			Type: "Feature",
+			Geometry: GeoJSONGeometry{
+				Type:        "Polygon",
+				Coordinates: [][][]float64{circle(lon, lat, float64(meters), circleSegments)},
+			},
+			Properties: map[string]interface{}{
+				"kind":   "range",
+				"meters": meters,
+			},
+		})
+	}
+
+	return fc, nil
+}
+
+// circle returns a closed ring of n points, approximating the circle of
+// the given radius in meters around a point.
+func circle(lon, lat, meters float64, n int) [][]float64 {
+	rad := math.Pi / 180
+	d := meters / earthRadius
+	lat1, lon1 := lat*rad, lon*rad
+
+	ring := make([][]float64, n+1)
+	for i := 0; i < n; i++ {
+		bearing := 2 * math.Pi * float64(i) / float64(n)
+		lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(bearing))
+		lon2 := lon1 + math.Atan2(math.Sin(bearing)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+		ring[i] = []float64{lon2 / rad, lat2 / rad}
+	}
+	ring[n] = ring[0]
+
+	return ring
+}
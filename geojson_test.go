@@ -0,0 +1,64 @@
+package hjem
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLookupGeoJSON(t *testing.T) {
+	resp := &LookupResponse{
+		Addrs: []*Address{
+			{DawaID: "Vej 1, 1000 By", Latitude: 12.5, Longitude: 55.7},
+			{DawaID: "Vej 2, 1000 By", Latitude: 12.501, Longitude: 55.7},
+		},
+		Sales:  []*JSONSale{{AddrIndex: 1, Amount: 2000000, When: time.Now()}},
+		Ranges: map[int][]int{500: {1}, 250: {1}},
+	}
+
+	fc, err := LookupGeoJSON(resp)
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	if len(fc.Features) != 4 {
+		t.Fatalf("unexpected amount of features: %d (expected: 4)", len(fc.Features))
+	}
+
+	b, _ := json.Marshal(fc)
+	var out struct {
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	json.Unmarshal(b, &out)
+
+	var point []float64
+	json.Unmarshal(out.Features[1].Geometry.Coordinates, &point)
+	if point[0] != 12.501 || point[1] != 55.7 {
+		t.Fatalf("unexpected coordinates: %v (expected: [12.501 55.7])", point)
+	}
+
+	props := out.Features[1].Properties
+	if props["full_txt"] != "Vej 2, 1000 By" || props["primary"] != false || len(props["sales"].([]interface{})) != 1 {
+		t.Fatalf("unexpected properties: %v", props)
+	}
+
+	var ring [][][]float64
+	json.Unmarshal(out.Features[2].Geometry.Coordinates, &ring)
+	if out.Features[2].Properties["meters"] != 250.0 || len(ring[0]) != circleSegments+1 {
+		t.Fatalf("unexpected range: %v", out.Features[2].Properties)
+	}
+
+	for _, p := range ring[0] {
+		d := distance(12.5, 55.7, p[0], p[1])
+		if math.Abs(d-250) > 1 {
+			t.Fatalf("unexpected distance of range circle: %f (expected: 250)", d)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package hjem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	listingsMaxAge = 24 * time.Hour
+)
+
+// BoligaListingItem is a property currently for sale on Boliga.
+type BoligaListingItem struct {
+	ID           int          `json:"id"`
+	Street       string       `json:"street"`
+	ZipCode      int          `json:"zipCode"`
+	City         string       `json:"city"`
+	Price        int          `json:"price"`
+	Size         int          `json:"size"`
+	Rooms        float64      `json:"rooms"`
+	BuildYear    int          `json:"buildYear"`
+	PropertyType PropertyType `json:"propertyType"`
+	Latitude     float64      `json:"latitude"`
+	Longitude    float64      `json:"longitude"`
+	DaysForSale  int          `json:"daysForSale"`
+	CreatedDate  time.Time    `json:"createdDate"`
+}
+
+type BoligaListingsResponse struct {
+	Meta     BoligaPageCrawl     `json:"meta"`
+	Listings []BoligaListingItem `json:"results"`
+}
+
+// BoligaListingRequest searches for the properties for sale in a postal
+// code.
+type BoligaListingRequest struct {
+	ZipCode int
+}
+
+func (r BoligaListingRequest) Fetch() ([]BoligaListingItem, error) {
+	endpoint := "https://api.boliga.dk/api/v2/search/results"
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	q := req.URL.Query()
+	q.Add("zipCodes", strconv.Itoa(r.ZipCode))
+	q.Add("pageSize", "100")
+
+	var listings []BoligaListingItem
+	for page := 1; ; page++ {
+		q.Set("page", strconv.Itoa(page))
+		req.URL.RawQuery = q.Encode()
+
+		resp, err := DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var lr BoligaListingsResponse
+		err = json.NewDecoder(resp.Body).Decode(&lr)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		listings = append(listings, lr.Listings...)
+		if page >= lr.Meta.TotalPages {
+			break
+		}
+	}
+
+	return listings, nil
+}
+
+// Listing is a property which is, or has been, for sale. Listings which
+// are no longer found on Boliga are kept as inactive, along with their
+// asking price history.
+type Listing struct {
+	ID           uint         `json:"-" gorm:"primaryKey"`
+	BoligaID     int          `json:"boliga_id" gorm:"not null;uniqueIndex"`
+	Address      string       `json:"address" gorm:"not null"`
+	ZipCode      int          `json:"zipcode" gorm:"not null;index"`
+	Kind         PropertyType `json:"-"`
+	BuildingSize int          `json:"building_size"`
+	Rooms        int          `json:"rooms"`
+	BuiltYear    int          `json:"built_year"`
+	Latitude     float64      `json:"lat"`
+	Longitude    float64      `json:"long"`
+	AskingPrice  int          `json:"asking_price"`
+	ListedAt     time.Time    `json:"listed_at"`
+	LastSeenAt   time.Time    `json:"last_seen_at"`
+	Active       bool         `json:"active" gorm:"not null;index"`
+
+	// Prices is the asking price history of the listing, ordered by the
+	// time of observation.
+	Prices []ListingPrice `json:"prices" gorm:"-"`
+}
+
+// ListingPrice is the asking price of a listing, as observed at
+// ObservedAt. A price is only recorded when it changes.
+type ListingPrice struct {
+	ListingID   uint      `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	ObservedAt  time.Time `json:"observed_at" gorm:"primaryKey"`
+	AskingPrice int       `json:"asking_price"`
+}
+
+// ListingArea records when the listings of a postal code were collected.
+type ListingArea struct {
+	ZipCode     int `gorm:"primaryKey;autoIncrement:false"`
+	CollectedAt time.Time
+}
+
+// InitialPrice returns the first observed asking price of the listing.
+func (l Listing) InitialPrice() int {
+	if len(l.Prices) == 0 {
+		return l.AskingPrice
+	}
+
+	return l.Prices[0].AskingPrice
+}
+
+// Reductions returns the number of times the asking price was lowered.
+func (l Listing) Reductions() int {
+	var n int
+	for i := 1; i < len(l.Prices); i++ {
+		if l.Prices[i].AskingPrice < l.Prices[i-1].AskingPrice {
+			n += 1
+		}
+	}
+
+	return n
+}
+
+// DaysOnMarket returns the number of days the listing was for sale as of
+// now, or until it was last seen if it is no longer for sale.
+func (l Listing) DaysOnMarket(now time.Time) int {
+	end := now
+	if !l.Active {
+		end = l.LastSeenAt
+	}
+
+	return int(end.Sub(l.ListedAt).Hours() / 24)
+}
+
+// ZipError is the reason the listings of a postal code could not be
+// fetched.
+type ZipError struct {
+	ZipCode int
+	Err     error
+}
+
+func (e ZipError) Error() string {
+	return fmt.Sprintf("%d: %s", e.ZipCode, e.Err)
+}
+
+type ListingCacher interface {
+	Listings(zips []int) ([]*Listing, []ZipError, error)
+}
+
+type listingCacher struct {
+	db    *gorm.DB
+	fetch func(zip int) ([]BoligaListingItem, error)
+}
+
+func NewListingCacher(db *gorm.DB) *listingCacher {
+	return &listingCacher{db, func(zip int) ([]BoligaListingItem, error) {
+		return BoligaListingRequest{ZipCode: zip}.Fetch()
+	}}
+}
+
+// Listings returns the active listings of the postal codes, along with
+// their asking price history. Postal codes whose listings were collected
+// more than a day ago are refreshed first. If a refresh fails, the stored
+// listings of the postal code are returned and the failure is reported as
+// a ZipError.
+func (lc *listingCacher) Listings(zips []int) ([]*Listing, []ZipError, error) {
+	var areas []ListingArea
+	if err := lc.db.Where("zip_code IN ?", zips).Find(&areas).Error; err != nil {
+		return nil, nil, err
+	}
+
+	collected := map[int]time.Time{}
+	for _, a := range areas {
+		collected[a.ZipCode] = a.CollectedAt
+	}
+
+	var zipErrs []ZipError
+	for _, zip := range zips {
+		if t, ok := collected[zip]; ok && time.Since(t) < listingsMaxAge {
+			continue
+		}
+
+		if err := lc.refresh(zip); err != nil {
+			zipErrs = append(zipErrs, ZipError{zip, err})
+		}
+	}
+
+	listings, err := StoredListings(lc.db, zips)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return listings, zipErrs, nil
+}
+
+// StoredListings returns the stored active listings of the postal codes,
+// along with their asking price history, without fetching them.
+func StoredListings(db *gorm.DB, zips []int) ([]*Listing, error) {
+	var listings []*Listing
+	if err := db.Where("zip_code IN ? AND active = ?", zips, true).Find(&listings).Error; err != nil {
+		return nil, err
+	}
+
+	if err := loadListingPrices(db, listings); err != nil {
+		return nil, err
+	}
+
+	return listings, nil
+}
+
+// refresh fetches the listings of a postal code, records changes of their
+// asking prices, and deactivates the listings which are no longer for sale.
+func (lc *listingCacher) refresh(zip int) error {
+	items, err := lc.fetch(zip)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return lc.db.Transaction(func(tx *gorm.DB) error {
+		for _, item := range items {
+			var l Listing
+			err := tx.Where("boliga_id = ?", item.ID).First(&l).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			changed := l.ID == 0 || l.AskingPrice != item.Price
+			if l.ID == 0 {
+				l.BoligaID = item.ID
+				l.ListedAt = item.CreatedDate
+				if l.ListedAt.IsZero() {
+					l.ListedAt = now.AddDate(0, 0, -item.DaysForSale)
+				}
+			}
+
+			l.Address = item.Street
+			l.ZipCode = zip
+			l.Kind = item.PropertyType
+			l.BuildingSize = item.Size
+			l.Rooms = int(item.Rooms)
+			l.BuiltYear = item.BuildYear
+			l.Latitude = item.Latitude
+			l.Longitude = item.Longitude
+			l.AskingPrice = item.Price
+			l.LastSeenAt = now
+			l.Active = true
+
+			if err := tx.Save(&l).Error; err != nil {
+				return err
+			}
+
+			if changed {
+				p := ListingPrice{ListingID: l.ID, ObservedAt: now, AskingPrice: item.Price}
+				if err := tx.Create(&p).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		err := tx.Model(&Listing{}).
+			Where("zip_code = ? AND active = ? AND last_seen_at < ?", zip, true, now).
+			Update("active", false).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Save(&ListingArea{ZipCode: zip, CollectedAt: now}).Error
+	})
+}
+
+func loadListingPrices(db *gorm.DB, listings []*Listing) error {
+	m := map[uint]*Listing{}
+	ids := make([]uint, len(listings))
+	for i, l := range listings {
+		m[l.ID] = l
+		ids[i] = l.ID
+	}
+
+	return inBatches(len(ids), maxBatchSize(db), func(start, end int) error {
+		var prices []ListingPrice
+		err := db.Where("listing_id IN ?", ids[start:end]).
+			Order("observed_at").
+			Find(&prices).Error
+		if err != nil {
+			return err
+		}
+
+		for _, p := range prices {
+			m[p.ListingID].Prices = append(m[p.ListingID].Prices, p)
+		}
+
+		return nil
+	})
+}
+
+// ListingSummary is an active listing near the address of a lookup.
+// TrendRatio is the ratio of its asking price per square meter to the mean
+// price per square meter of the latest year of the lookup.
+type ListingSummary struct {
+	*Listing
+	Distance         int     `json:"distance"`
+	InitialPrice     int     `json:"initial_price"`
+	Reductions       int     `json:"reductions"`
+	DaysOnMarket     int     `json:"days_on_market"`
+	SquareMeterPrice int     `json:"sqmeter_price"`
+	TrendRatio       float64 `json:"trend_ratio,omitempty"`
+}
+
+// ListingComparison compares the asking prices of the listings near the
+// address of a lookup to the historic price per square meter.
+type ListingComparison struct {
+	TrendYear             int              `json:"trend_year"`
+	TrendSquareMeterPrice int              `json:"trend_sqmeter_price"`
+	MedianRatio           float64          `json:"median_ratio"`
+	Listings              []ListingSummary `json:"listings"`
+}
+
+// CompareListings compares the listings of the same property type as the
+// primary address of resp, within radius meters of it, to the prices per
+// square meter of resp. A radius of 0 includes every listing.
+func CompareListings(resp *LookupResponse, listings []*Listing, radius int) *ListingComparison {
+	primary := resp.Addrs[resp.PrimaryIndex]
+	cmp := ListingComparison{}
+	if len(resp.SquareMeters.Global) > 0 {
+		latest := latestYear(resp.SquareMeters.Global)
+		cmp.TrendYear = latest.Year()
+		cmp.TrendSquareMeterPrice = resp.SquareMeters.Global[latest].Mean
+	}
+
+	now := time.Now()
+	lon, lat := primary.LonLat()
+	var ratios []float64
+	for _, l := range listings {
+		if l.Kind != primary.BoligaPropertyKind {
+			continue
+		}
+
+		d := int(distance(lon, lat, l.Longitude, l.Latitude))
+		if radius > 0 && d > radius {
+			continue
+		}
+
+		ls := ListingSummary{
+			Listing:      l,
+			Distance:     d,
+			InitialPrice: l.InitialPrice(),
+			Reductions:   l.Reductions(),
+			DaysOnMarket: l.DaysOnMarket(now),
+		}
+
+		if l.BuildingSize > 0 {
+			ls.SquareMeterPrice = l.AskingPrice / l.BuildingSize
+			if cmp.TrendSquareMeterPrice > 0 {
+				ls.TrendRatio = float64(ls.SquareMeterPrice) / float64(cmp.TrendSquareMeterPrice)
+				ratios = append(ratios, ls.TrendRatio)
+			}
+		}
+
+		cmp.Listings = append(cmp.Listings, ls)
+	}
+
+	if len(cmp.Listings) == 0 {
+		return nil
+	}
+
+	cmp.MedianRatio = median(ratios)
+
+	return &cmp
+}
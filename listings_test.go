@@ -0,0 +1,107 @@
+package hjem
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListingCacher(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			var fetches int
+			items := []BoligaListingItem{
+				{ID: 1, Street: "Vej 1", Price: 3000000, Size: 100, PropertyType: PropertyHouse, DaysForSale: 10},
+				{ID: 2, Street: "Vej 2", Price: 2000000, Size: 80, PropertyType: PropertyHouse},
+			}
+			var fetchErr error
+
+			lc := &listingCacher{db, func(zip int) ([]BoligaListingItem, error) {
+				fetches += 1
+				return items, fetchErr
+			}}
+
+			listings, zipErrs, err := lc.Listings([]int{1000})
+			if err != nil || len(zipErrs) > 0 {
+				t.Fatalf("received unexpected error: %v %v", err, zipErrs)
+			}
+
+			if len(listings) != 2 {
+				t.Fatalf("unexpected amount of listings: %d (expected: 2)", len(listings))
+			}
+
+			// the price of the first listing is reduced, the second is sold
+			items = []BoligaListingItem{
+				{ID: 1, Street: "Vej 1", Price: 2800000, Size: 100, PropertyType: PropertyHouse},
+			}
+			db.Model(&ListingArea{}).Where("zip_code = ?", 1000).Update("collected_at", time.Now().Add(-2*listingsMaxAge))
+
+			listings, _, err = lc.Listings([]int{1000})
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(listings) != 1 {
+				t.Fatalf("unexpected amount of listings: %d (expected: 1)", len(listings))
+			}
+
+			l := listings[0]
+			if l.AskingPrice != 2800000 || l.InitialPrice() != 3000000 || l.Reductions() != 1 {
+				t.Fatalf("unexpected listing: %+v", l)
+			}
+
+			if days := l.DaysOnMarket(time.Now()); days != 10 {
+				t.Fatalf("unexpected days on market: %d (expected: 10)", days)
+			}
+
+			// fresh listings are not fetched again, and failures serve the stored listings
+			if _, _, err := lc.Listings([]int{1000}); err != nil || fetches != 2 {
+				t.Fatalf("unexpected fetches: %d (expected: 2), err: %v", fetches, err)
+			}
+
+			fetchErr = errors.New("unavailable")
+			db.Model(&ListingArea{}).Where("zip_code = ?", 1000).Update("collected_at", time.Now().Add(-2*listingsMaxAge))
+			listings, zipErrs, err = lc.Listings([]int{1000})
+			if err != nil || len(zipErrs) != 1 || len(listings) != 1 {
+				t.Fatalf("unexpected stale listings: %d, errors: %v %v", len(listings), err, zipErrs)
+			}
+		})
+	}
+}
+
+func TestCompareListings(t *testing.T) {
+	year := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp := &LookupResponse{
+		Addrs: []*Address{{BoligaPropertyKind: PropertyHouse, Latitude: 12.5, Longitude: 55.7}},
+		SquareMeters: SquareMeterPrices{
+			Global: map[time.Time]Aggregation{
+				year.AddDate(-1, 0, 0): {Mean: 20000},
+				year:                   {Mean: 25000},
+			},
+		},
+	}
+
+	listings := []*Listing{
+		{Kind: PropertyHouse, Longitude: 12.5, Latitude: 55.7, AskingPrice: 3000000, BuildingSize: 100, Active: true},
+		{Kind: PropertyHouse, Longitude: 12.501, Latitude: 55.7, AskingPrice: 2000000, BuildingSize: 100, Active: true},
+		{Kind: PropertyApartment, Longitude: 12.5, Latitude: 55.7, AskingPrice: 2000000, BuildingSize: 50, Active: true},
+		{Kind: PropertyHouse, Longitude: 12.6, Latitude: 55.7, AskingPrice: 2000000, BuildingSize: 100, Active: true},
+	}
+
+	cmp := CompareListings(resp, listings, 500)
+	if cmp == nil || len(cmp.Listings) != 2 {
+		t.Fatalf("unexpected comparison: %+v", cmp)
+	}
+
+	if cmp.TrendYear != 2021 || cmp.TrendSquareMeterPrice != 25000 {
+		t.Fatalf("unexpected trend: %d in %d (expected: 25000 in 2021)", cmp.TrendSquareMeterPrice, cmp.TrendYear)
+	}
+
+	if d := cmp.Listings[1].Distance; d < 50 || d > 80 {
+		t.Fatalf("unexpected distance: %d (expected: ~63)", d)
+	}
+
+	if cmp.MedianRatio != 1.0 {
+		t.Fatalf("unexpected median ratio: %f (expected: 1.0)", cmp.MedianRatio)
+	}
+}
@@ -0,0 +1,188 @@
+package hjem
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var (
+	ErrNonUniqueAddr = errors.New("non-unique address, be more specific")
+	ErrNoAddr        = errors.New("no found address")
+)
+
+// LookupRequest asks for the sales of the address matching Query, and of
+// the addresses of the same property type within each of the Ranges (in
+// meters). Sales further than Filter standard deviations from the mean
+// price per square meter of their year are left out, unless Filter is 0.
+// AskingPrice is the listing price of the address, if known.
+type LookupRequest struct {
+	Query       string `json:"q"`
+	Ranges      []int  `json:"ranges"`
+	Filter      int    `json:"filter_below_std"`
+	AskingPrice int    `json:"asking_price,omitempty"`
+}
+
+// lookup resolves the query of req to a single address, and formats the
+// sales of the address and of the addresses of the same property type
+// within the ranges of req.
+func (s *server) lookup(req LookupRequest) (*LookupResponse, error) {
+	addrs, err := s.dc.Do(DawaFuzzySearch{
+		Query: req.Query,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) > 1 {
+		return nil, ErrNonUniqueAddr
+	}
+
+	if len(addrs) == 0 {
+		return nil, ErrNoAddr
+	}
+	addr := addrs[0]
+
+	ranges, err := s.constructRanges(addr, req.Ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addrsInRange := range ranges {
+		addrs = append(addrs, addrsInRange...)
+	}
+
+	sales, addrErrs, err := s.bc.FetchSales(addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []Warning
+	for _, ae := range addrErrs {
+		if ae.Addr == addr && !ae.Stale {
+			return nil, ae
+		}
+
+		msg := ae.Err.Error()
+		if ae.Stale {
+			msg = fmt.Sprintf("could not refresh sales, showing sales collected %s: %s",
+				ae.Addr.BoligaCollectedAt.Format("2006-01-02"), msg)
+		}
+
+		warnings = append(warnings, Warning{
+			Address: ae.Addr.DawaID,
+			Message: msg,
+		})
+	}
+
+	addrs, sales = FilterAddressesByProperty(addr.BoligaPropertyKind, addrs, sales)
+
+	if s.bbr != nil {
+		bbrErrs, err := s.bbr.Enrich(addrs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ae := range bbrErrs {
+			warnings = append(warnings, Warning{
+				Address: ae.Addr.DawaID,
+				Message: ae.Err.Error(),
+			})
+		}
+	}
+
+	for _, a := range addrs {
+		a.ApplyBBR()
+	}
+
+	history, err := s.bc.AttributeHistory(addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := FormatLookupResponse(addrs, ranges, sales, history, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	resp.Warnings = warnings
+
+	vals, err := valuationsOf(s.db, resp.Addrs)
+	if err != nil {
+		return nil, err
+	}
+	resp.Valuation = CompareValuations(resp, vals, req.AskingPrice)
+
+	if err := s.compareListings(resp, req.Ranges); err != nil {
+		return nil, err
+	}
+
+	if err := matchListings(s.db, resp); err != nil {
+		return nil, err
+	}
+	resp.Spread = AnalyseSpread(resp)
+
+	return resp, nil
+}
+
+// compareListings compares the listings for sale within the largest of the
+// ranges to the sales of resp. The listings of every postal code of the
+// addresses in resp are considered. They are only fetched from Boliga if
+// the server has a ListingCacher, and read from the database otherwise.
+func (s *server) compareListings(resp *LookupResponse, ranges []int) error {
+	var radius int
+	for _, r := range ranges {
+		if r > radius {
+			radius = r
+		}
+	}
+
+	seen := map[int]bool{}
+	var zips []int
+	for _, a := range resp.Addrs {
+		zip, err := strconv.Atoi(a.PostalCode)
+		if err != nil || seen[zip] {
+			continue
+		}
+
+		seen[zip] = true
+		zips = append(zips, zip)
+	}
+
+	if s.lc == nil {
+		listings, err := StoredListings(s.db, zips)
+		if err != nil {
+			return err
+		}
+
+		resp.Listings = CompareListings(resp, listings, radius)
+		return nil
+	}
+
+	listings, zipErrs, err := s.lc.Listings(zips)
+	if err != nil {
+		return err
+	}
+
+	for _, ze := range zipErrs {
+		resp.Warnings = append(resp.Warnings, Warning{
+			Address: strconv.Itoa(ze.ZipCode),
+			Message: ze.Err.Error(),
+		})
+	}
+
+	resp.Listings = CompareListings(resp, listings, radius)
+
+	return nil
+}
+
+// lookupErrStatus returns the status code to reply with, when a lookup
+// fails with err.
+func lookupErrStatus(err error) int {
+	var ae AddrError
+	if errors.As(err, &ae) {
+		return http.StatusBadGateway
+	}
+
+	return http.StatusBadRequest
+}
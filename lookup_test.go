@@ -0,0 +1,92 @@
+package hjem
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookupWarnings(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			expired := time.Now().Add(-2 * oneMonth)
+			addrs := []*Address{
+				{DawaID: "Vej 1, 1000 By", StreetName: "Vej", StreetNumber: "1", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100, BoligaCollectedAt: expired},
+				{DawaID: "Gade 1, 1000 By", StreetName: "Gade", StreetNumber: "1", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+				{DawaID: "Sti 1, 1000 By", StreetName: "Sti", StreetNumber: "1", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+			}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+			db.Create(&Sale{AddrID: addrs[0].ID, AmountDKK: 1000000, Date: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)})
+
+			// the listings of 1000 were never collected, which must not be
+			// warned about, since listings are only read from the database
+			db.Create(&Listing{BoligaID: 1, Address: "Vej 2", ZipCode: 1000, Kind: PropertyHouse, BuildingSize: 100, AskingPrice: 3000000, Active: true})
+
+			// the street of Sti is the only one which Boliga replies for
+			items := func(addrs []*Address) ([]*BoligaSaleItem, []error) {
+				items := make([]*BoligaSaleItem, len(addrs))
+				errs := make([]error, len(addrs))
+				for i, a := range addrs {
+					if a.StreetName != "Sti" {
+						errs[i] = errors.New("timeout")
+						continue
+					}
+
+					items[i] = &BoligaSaleItem{Addr: a.Short()}
+				}
+
+				return items, errs
+			}
+			property := func(BoligaSaleItem) (*BoligaProperty, error) {
+				return &BoligaProperty{
+					Kind:         PropertyHouse,
+					BuildingSize: 100,
+					Sales:        []Sale{{AmountDKK: 2000000, Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}},
+				}, nil
+			}
+
+			bc := newBoligaCacher(db, 1, items, property)
+			defer bc.Close()
+
+			s := &server{db: db, dc: fakeDawaCacher{addrs: addrs}, bc: bc}
+			resp, err := s.lookup(LookupRequest{Query: "Vej 1", Ranges: []int{500}})
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(resp.Sales) != 2 {
+				t.Fatalf("unexpected number of sales: %d (expected: 2)", len(resp.Sales))
+			}
+
+			if resp.Listings == nil || len(resp.Listings.Listings) != 1 {
+				t.Fatalf("unexpected listings: %+v (expected: the stored listing)", resp.Listings)
+			}
+
+			warned := map[string]string{}
+			for _, w := range resp.Warnings {
+				warned[w.Address] = w.Message
+			}
+
+			tests := []struct {
+				addr string
+				msg  string
+			}{
+				{addr: "Vej 1, 1000 By", msg: "could not refresh sales, showing sales collected " + expired.Format("2006-01-02") + ": timeout"},
+				{addr: "Gade 1, 1000 By", msg: "timeout"},
+			}
+
+			if len(warned) != len(tests) {
+				t.Fatalf("unexpected warnings: %v (expected: %d)", resp.Warnings, len(tests))
+			}
+
+			for _, tc := range tests {
+				if msg := warned[tc.addr]; !strings.Contains(msg, tc.msg) {
+					t.Fatalf("unexpected warning of %s: %q (expected: %q)", tc.addr, msg, tc.msg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package hjem
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tileSize = 256
+	maxZoom  = 18
+
+	// mapRangeFill is the fraction of the map which the largest range
+	// circle spans.
+	mapRangeFill = 0.9
+)
+
+// AddressSummary places the address at AddrIndex of a lookup on a map,
+// and summarizes its sales.
+type AddressSummary struct {
+	AddrIndex              int        `json:"addr_idx"`
+	Lon                    float64    `json:"lon"`
+	Lat                    float64    `json:"lat"`
+	Sales                  int        `json:"sales"`
+	LatestSale             *time.Time `json:"latest_sale,omitempty"`
+	LatestAmount           int        `json:"latest_amount,omitempty"`
+	LatestSquareMeterPrice int        `json:"latest_sqmeter_price,omitempty"`
+}
+
+// SummarizeAddresses returns a summary of each address of resp, in the
+// order of resp.Addrs.
+func SummarizeAddresses(resp *LookupResponse) []AddressSummary {
+	out := make([]AddressSummary, len(resp.Addrs))
+	for i, a := range resp.Addrs {
+		lon, lat := a.LonLat()
+		out[i] = AddressSummary{AddrIndex: i, Lon: lon, Lat: lat}
+	}
+
+	for _, s := range resp.Sales {
+		sum := &out[s.AddrIndex]
+		sum.Sales += 1
+		if sum.LatestSale != nil && !s.When.After(*sum.LatestSale) {
+			continue
+		}
+
+		when := s.When
+		sum.LatestSale = &when
+		sum.LatestAmount = s.Amount
+		sum.LatestSquareMeterPrice = 0
+		if s.BuildingSize > 0 {
+			sum.LatestSquareMeterPrice = s.Amount / s.BuildingSize
+		}
+	}
+
+	return out
+}
+
+// MapTiles is a tile server, given by a URL template such as
+// "http://localhost:8081/{z}/{x}/{y}.png", along with the attribution
+// required by its tiles.
+type MapTiles struct {
+	URL         string
+	Attribution string
+}
+
+func (t MapTiles) tileURL(z, x, y int) string {
+	return strings.NewReplacer(
+		"{z}", strconv.Itoa(z),
+		"{x}", strconv.Itoa(x),
+		"{y}", strconv.Itoa(y),
+	).Replace(t.URL)
+}
+
+// project returns the position of a point in pixels of the web mercator
+// projection at zoom z.
+func project(lon, lat float64, z int) (float64, float64) {
+	scale := tileSize * math.Pow(2, float64(z))
+	rad := lat * math.Pi / 180
+	x := (lon + 180) / 360 * scale
+	y := (1 - math.Log(math.Tan(rad)+1/math.Cos(rad))/math.Pi) / 2 * scale
+
+	return x, y
+}
+
+// metersPerPixel returns the meters spanned by a pixel at latitude lat and
+// zoom z.
+func metersPerPixel(lat float64, z int) float64 {
+	return 2 * math.Pi * earthRadius * math.Cos(lat*math.Pi/180) / (tileSize * math.Pow(2, float64(z)))
+}
+
+// priceColor returns the color of a price between min and max, from green
+// for the cheapest to red for the most expensive.
+func priceColor(price, min, max int) string {
+	if price == 0 {
+		return "#999999"
+	}
+
+	f := 0.5
+	if max > min {
+		f = float64(price-min) / float64(max-min)
+	}
+
+	return fmt.Sprintf("hsl(%d, 80%%, 45%%)", int(120*(1-f)))
+}
+
+// RenderMap renders an SVG map of width by height pixels, centered on the
+// primary address of resp and zoomed to fit its largest range. The range
+// circles are drawn around the primary address, and every address is
+// marked, colored by the price per square meter of its latest sale. Each
+// marker links to "#addr-<index>", where index is the index of the address
+// in resp.Addrs. The addresses are placed by resp.Summaries, unless resp
+// lacks a summary of each address. Without a tile server, the map has a
+// plain background.
+func RenderMap(resp *LookupResponse, tiles MapTiles, width, height int) string {
+	sums := resp.Summaries
+	if len(sums) != len(resp.Addrs) {
+		sums = SummarizeAddresses(resp)
+	}
+	center := sums[resp.PrimaryIndex]
+
+	var ranges []int
+	for meters := range resp.Ranges {
+		ranges = append(ranges, meters)
+	}
+	sort.Ints(ranges)
+
+	z := 16
+	if len(ranges) > 0 {
+		span := float64(width)
+		if height < width {
+			span = float64(height)
+		}
+
+		largest := float64(ranges[len(ranges)-1])
+		for z = maxZoom; z > 0; z-- {
+			if 2*largest/metersPerPixel(center.Lat, z) <= mapRangeFill*span {
+				break
+			}
+		}
+	}
+
+	cx, cy := project(center.Lon, center.Lat, z)
+	left, top := cx-float64(width)/2, cy-float64(height)/2
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%d" height="%d" viewBox="0 0 %d %d">`, width, height, width, height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#eeeeee"/>`, width, height)
+
+	if tiles.URL != "" {
+		n := int(math.Pow(2, float64(z)))
+		for tx := int(math.Floor(left / tileSize)); float64(tx*tileSize) < left+float64(width); tx++ {
+			for ty := int(math.Floor(top / tileSize)); float64(ty*tileSize) < top+float64(height); ty++ {
+				if ty < 0 || ty >= n {
+					continue
+				}
+
+				fmt.Fprintf(&b, `<image xlink:href="%s" x="%.1f" y="%.1f" width="%d" height="%d"/>`,
+					xmlEscape(tiles.tileURL(z, ((tx%n)+n)%n, ty)), float64(tx*tileSize)-left, float64(ty*tileSize)-top, tileSize, tileSize)
+			}
+		}
+	}
+
+	mpp := metersPerPixel(center.Lat, z)
+	for i := len(ranges) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="#3388ff" fill-opacity="0.08" stroke="#3388ff" stroke-width="2"><title>%d m</title></circle>`,
+			cx-left, cy-top, float64(ranges[i])/mpp, ranges[i])
+	}
+
+	min, max := 0, 0
+	for _, s := range sums {
+		p := s.LatestSquareMeterPrice
+		if p == 0 {
+			continue
+		}
+
+		if min == 0 || p < min {
+			min = p
+		}
+
+		if p > max {
+			max = p
+		}
+	}
+
+	// the primary address is drawn last, to keep it on top
+	order := make([]int, 0, len(sums))
+	for i := range sums {
+		if i != resp.PrimaryIndex {
+			order = append(order, i)
+		}
+	}
+	order = append(order, resp.PrimaryIndex)
+
+	for _, i := range order {
+		s := sums[i]
+		x, y := project(s.Lon, s.Lat, z)
+		r, stroke := 6, "#ffffff"
+		if i == resp.PrimaryIndex {
+			r, stroke = 9, "#000000"
+		}
+
+		title := resp.Addrs[i].DawaID
+		if s.LatestSquareMeterPrice > 0 {
+			title += fmt.Sprintf(": %d kr/m² (%d)", s.LatestSquareMeterPrice, s.LatestSale.Year())
+		}
+
+		fmt.Fprintf(&b, `<a xlink:href="#addr-%d"><circle cx="%.1f" cy="%.1f" r="%d" fill="%s" stroke="%s" stroke-width="2"><title>%s</title></circle></a>`,
+			i, x-left, y-top, r, priceColor(s.LatestSquareMeterPrice, min, max), stroke, xmlEscape(title))
+	}
+
+	if max > 0 {
+		fmt.Fprintf(&b, `<text x="8" y="%d" font-size="12" font-family="sans-serif"><tspan fill="%s">■ %d kr/m²</tspan> <tspan fill="%s">■ %d kr/m²</tspan></text>`,
+			height-8, priceColor(min, min, max), min, priceColor(max, min, max), max)
+	}
+
+	if tiles.Attribution != "" {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="10" font-family="sans-serif" text-anchor="end">%s</text>`,
+			width-4, height-4, xmlEscape(tiles.Attribution))
+	}
+
+	b.WriteString(`</svg>`)
+
+	return b.String()
+}
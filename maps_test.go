@@ -0,0 +1,179 @@
+package hjem
+
+import (
+	"encoding/xml"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarizeAddresses(t *testing.T) {
+	older := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp := &LookupResponse{
+		Addrs: []*Address{
+			{DawaID: "Vej 1, 1000 By", Latitude: 12.5, Longitude: 55.7},
+			{DawaID: "Vej 2, 1000 By", Latitude: 12.501, Longitude: 55.7},
+		},
+		Sales: []*JSONSale{
+			{AddrIndex: 1, Amount: 3000000, BuildingSize: 100, When: newer},
+			{AddrIndex: 1, Amount: 2000000, BuildingSize: 100, When: older},
+		},
+	}
+
+	sums := SummarizeAddresses(resp)
+	if len(sums) != 2 {
+		t.Fatalf("unexpected amount of summaries: %d (expected: 2)", len(sums))
+	}
+
+	if sums[0].Sales != 0 || sums[0].LatestSale != nil {
+		t.Fatalf("unexpected summary of address without sales: %+v", sums[0])
+	}
+
+	s := sums[1]
+	if s.Lon != 12.501 || s.Lat != 55.7 {
+		t.Fatalf("unexpected coordinates: %f, %f (expected: 12.501, 55.7)", s.Lon, s.Lat)
+	}
+
+	if s.Sales != 2 || !s.LatestSale.Equal(newer) || s.LatestAmount != 3000000 || s.LatestSquareMeterPrice != 30000 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+}
+
+func TestRenderMap(t *testing.T) {
+	resp := &LookupResponse{
+		Addrs: []*Address{
+			{DawaID: "Vej 1, 1000 By", Latitude: 12.5, Longitude: 55.7},
+			{DawaID: "Vej 2, 1000 By", Latitude: 12.501, Longitude: 55.7},
+			{DawaID: "Vej 3, 1000 By", Latitude: 12.5, Longitude: 55.701},
+		},
+		Sales: []*JSONSale{
+			{AddrIndex: 1, Amount: 3000000, BuildingSize: 100, When: time.Now()},
+			{AddrIndex: 2, Amount: 2000000, BuildingSize: 100, When: time.Now()},
+		},
+		Ranges: map[int][]int{500: {1, 2}, 250: {1}},
+	}
+
+	tests := []struct {
+		name   string
+		tiles  MapTiles
+		images int
+	}{
+		{name: "without tiles"},
+		{name: "with tiles", tiles: MapTiles{URL: "http://tiles/{z}/{x}/{y}.png", Attribution: "© Tiles & co"}, images: 12},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			svg := RenderMap(resp, tc.tiles, 800, 600)
+
+			var out struct {
+				Images []struct {
+					Href string `xml:"href,attr"`
+				} `xml:"image"`
+				Circles []struct {
+					R     float64 `xml:"r,attr"`
+					Title string  `xml:"title"`
+				} `xml:"circle"`
+				Links []struct {
+					Href   string `xml:"href,attr"`
+					Circle struct {
+						Fill string `xml:"fill,attr"`
+					} `xml:"circle"`
+				} `xml:"a"`
+				Texts []string `xml:"text"`
+			}
+			if err := xml.Unmarshal([]byte(svg), &out); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(out.Images) != tc.images {
+				t.Fatalf("unexpected amount of tiles: %d (expected: %d)", len(out.Images), tc.images)
+			}
+
+			for _, img := range out.Images {
+				if !strings.HasPrefix(img.Href, "http://tiles/") || strings.Contains(img.Href, "{") {
+					t.Fatalf("unexpected tile url: %s", img.Href)
+				}
+			}
+
+			if len(out.Circles) != 2 || out.Circles[0].Title != "500 m" {
+				t.Fatalf("unexpected range circles: %+v", out.Circles)
+			}
+
+			if math.Abs(out.Circles[0].R/out.Circles[1].R-2) > 0.01 {
+				t.Fatalf("unexpected ratio of range radii: %f (expected: 2)", out.Circles[0].R/out.Circles[1].R)
+			}
+
+			if 2*out.Circles[0].R > 600 {
+				t.Fatalf("largest range does not fit the map: %f", 2*out.Circles[0].R)
+			}
+
+			if len(out.Links) != 3 || out.Links[2].Href != "#addr-0" {
+				t.Fatalf("unexpected markers: %+v", out.Links)
+			}
+
+			if out.Links[0].Circle.Fill != priceColor(30000, 20000, 30000) || out.Links[1].Circle.Fill != priceColor(20000, 20000, 30000) || out.Links[2].Circle.Fill != "#999999" {
+				t.Fatalf("unexpected marker colors: %+v", out.Links)
+			}
+
+			if tc.tiles.Attribution != "" && out.Texts[len(out.Texts)-1] != tc.tiles.Attribution {
+				t.Fatalf("unexpected attribution: %v (expected: %s)", out.Texts, tc.tiles.Attribution)
+			}
+		})
+	}
+}
+
+func TestHandleMapOfResult(t *testing.T) {
+	// without a cacher, the handler fails if it performs a lookup
+	s := &server{}
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{
+			name: "result",
+			body: `{"primary_idx": 0, "addresses": [{"full_txt": "Vej 1, 1000 By"}, {"full_txt": "Vej 2, 1000 By"}], "ranges": {"500": [1]}, "summaries": [{"addr_idx": 0, "lon": 12.5, "lat": 55.7}, {"addr_idx": 1, "lon": 12.501, "lat": 55.7, "sales": 1, "latest_sale": "2020-01-01T00:00:00Z", "latest_sqmeter_price": 30000}]}`,
+			code: http.StatusOK,
+		},
+		{
+			name: "missing summaries",
+			body: `{"primary_idx": 0, "addresses": [{"full_txt": "Vej 1, 1000 By"}]}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "invalid primary address",
+			body: `{"primary_idx": 1, "addresses": [{"full_txt": "Vej 1, 1000 By"}], "summaries": [{"addr_idx": 0}]}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "price without sale",
+			body: `{"primary_idx": 0, "addresses": [{"full_txt": "Vej 1, 1000 By"}], "summaries": [{"addr_idx": 0, "latest_sqmeter_price": 30000}]}`,
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.Routes().ServeHTTP(w, httptest.NewRequest("POST", "/map", strings.NewReader(tc.body)))
+			if w.Code != tc.code {
+				t.Fatalf("unexpected status code: %d (expected: %d): %s", w.Code, tc.code, w.Body)
+			}
+
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			svg := w.Body.String()
+			if !strings.Contains(svg, "Vej 2, 1000 By: 30000 kr/m² (2020)") || !strings.Contains(svg, "<title>500 m</title>") {
+				t.Fatalf("unexpected map: %s", svg)
+			}
+		})
+	}
+}
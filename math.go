@@ -3,6 +3,7 @@ package hjem
 import (
 	"fmt"
 	"math"
+	"sort"
 	"time"
 )
 
@@ -54,7 +55,7 @@ func SeperateOutliers(sales []*JSONSale, prices []int, aggr Aggregation, stdf in
 	return normal, outliers
 }
 
-func SalesStatistics(addrs []*Address, sales []*JSONSale, stdf int) ([]*JSONSale, map[time.Time]Aggregation) {
+func SalesStatistics(sales []*JSONSale, stdf int) ([]*JSONSale, map[time.Time]Aggregation) {
 	type G struct {
 		S []*JSONSale
 		P []int
@@ -63,7 +64,7 @@ func SalesStatistics(addrs []*Address, sales []*JSONSale, stdf int) ([]*JSONSale
 	temp := map[int]G{}
 	for _, s := range sales {
 		year, _, _ := s.When.Date()
-		sqMeters := addrs[s.AddrIndex].BoligaBuildingSize
+		sqMeters := s.BuildingSize
 		if sqMeters == 0 {
 			continue
 		}
@@ -101,3 +102,139 @@ func SalesStatistics(addrs []*Address, sales []*JSONSale, stdf int) ([]*JSONSale
 
 	return sales, out
 }
+
+type yearlyIndex struct {
+	Mean float64
+	N    int
+}
+
+// yearlyIndices groups sales by key, and computes the mean ratio between
+// the price per square meter of the sales in each group and the mean price
+// per square meter of their sale year. This makes groups comparable even
+// though their sales are spread over different years.
+func yearlyIndices(sales []*JSONSale, yearly map[time.Time]Aggregation, key func(*JSONSale) (int, bool)) map[int]yearlyIndex {
+	out := map[int]yearlyIndex{}
+	for _, s := range sales {
+		k, ok := key(s)
+		if !ok || s.BuildingSize == 0 {
+			continue
+		}
+
+		year := time.Date(s.When.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		agg, ok := yearly[year]
+		if !ok || agg.Mean == 0 {
+			continue
+		}
+
+		idx := out[k]
+		idx.Mean += float64(s.Amount/s.BuildingSize) / float64(agg.Mean)
+		idx.N += 1
+		out[k] = idx
+	}
+
+	for k, idx := range out {
+		idx.Mean /= float64(idx.N)
+		out[k] = idx
+	}
+
+	return out
+}
+
+// ValueEstimate is an estimate of the current value of an address.
+type ValueEstimate struct {
+	Year             int `json:"year"`
+	SquareMeterPrice int `json:"sqmeter_price"`
+	AmountDKK        int `json:"amount"`
+}
+
+// EstimateValue estimates the value of addr in the latest year of yearly.
+// The projection of the latest sale of addr is used when available, and
+// otherwise the mean price per square meter of the year.
+func EstimateValue(addr *Address, yearly map[time.Time]Aggregation, projection map[time.Time]int) *ValueEstimate {
+	if addr.BoligaBuildingSize == 0 || len(yearly) == 0 {
+		return nil
+	}
+
+	latest := latestYear(yearly)
+	sqMeterPrice := yearly[latest].Mean
+	if p, ok := projection[latest]; ok {
+		sqMeterPrice = p
+	}
+
+	return &ValueEstimate{
+		Year:             latest.Year(),
+		SquareMeterPrice: sqMeterPrice,
+		AmountDKK:        sqMeterPrice * addr.BoligaBuildingSize,
+	}
+}
+
+// latestYear returns the latest year of yearly.
+func latestYear(yearly map[time.Time]Aggregation) time.Time {
+	var latest time.Time
+	for year := range yearly {
+		if year.After(latest) {
+			latest = year
+		}
+	}
+
+	return latest
+}
+
+// median returns the median of xs, which it sorts.
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+
+	sort.Float64s(xs)
+	if len(xs)%2 == 0 {
+		return (xs[len(xs)/2-1] + xs[len(xs)/2]) / 2
+	}
+
+	return xs[len(xs)/2]
+}
+
+const earthRadius = 6371000
+
+// distance returns the distance in meters between two points, given by
+// their longitude and latitude.
+func distance(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := func(deg float64) float64 {
+		return deg * math.Pi / 180
+	}
+
+	dLat, dLon := rad(lat2-lat1), rad(lon2-lon1)
+	a := math.Pow(math.Sin(dLat/2), 2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Pow(math.Sin(dLon/2), 2)
+
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// polygonBBox returns the bounding box of a polygon of longitude and
+// latitude pairs, as min longitude, min latitude, max longitude and max
+// latitude.
+func polygonBBox(poly [][2]float64) []float64 {
+	bbox := []float64{poly[0][0], poly[0][1], poly[0][0], poly[0][1]}
+	for _, p := range poly[1:] {
+		bbox[0] = math.Min(bbox[0], p[0])
+		bbox[1] = math.Min(bbox[1], p[1])
+		bbox[2] = math.Max(bbox[2], p[0])
+		bbox[3] = math.Max(bbox[3], p[1])
+	}
+
+	return bbox
+}
+
+// inPolygon tells whether a point is within a polygon of longitude and
+// latitude pairs, by casting a ray from the point.
+func inPolygon(lon, lat float64, poly [][2]float64) bool {
+	in := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a[1] > lat) != (b[1] > lat) && lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+
+	return in
+}
@@ -0,0 +1,157 @@
+package hjem
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownMigration = errors.New("unknown migration version")
+)
+
+// Migration is a versioned change of the database schema. Up applies the
+// change and Down reverts it, both within a transaction.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records that a migration has been applied.
+type SchemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"not null"`
+	AppliedAt time.Time
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+func (s MigrationStatus) String() string {
+	applied := "pending"
+	if s.AppliedAt != nil {
+		applied = s.AppliedAt.Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("%04d %-32s %s", s.Version, s.Name, applied)
+}
+
+func latestMigration() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+
+	return migrations[len(migrations)-1].Version
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[int]SchemaMigration{}
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+
+	return applied, nil
+}
+
+// Migrate applies every pending migration.
+func Migrate(db *gorm.DB) error {
+	return MigrateTo(db, latestMigration())
+}
+
+// MigrateTo applies or reverts migrations until the schema is at the given
+// version. Version 0 reverts every migration.
+func MigrateTo(db *gorm.DB, version int) error {
+	if version != 0 {
+		var known bool
+		for _, m := range migrations {
+			if m.Version == version {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("%w: %d", ErrUnknownMigration, version)
+		}
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok || m.Version > version {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok || m.Version <= version {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("revert migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrationStatuses lists every known migration, and when it was applied.
+func MigrationStatuses(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		out[i] = MigrationStatus{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			at := a.AppliedAt
+			out[i].AppliedAt = &at
+		}
+	}
+
+	return out, nil
+}
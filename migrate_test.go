@@ -0,0 +1,136 @@
+package hjem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Fatalf("migration %d (%s) is not ordered after %d", migrations[i].Version, migrations[i].Name, migrations[i-1].Version)
+		}
+	}
+}
+
+func TestMigrateEmpty(t *testing.T) {
+	for name, db := range emptyTestDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := Migrate(db); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			statuses, err := MigrationStatuses(db)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			for _, s := range statuses {
+				if s.AppliedAt == nil {
+					t.Fatalf("migration not applied: %s", s)
+				}
+			}
+
+			if !db.Migrator().HasColumn(&Address{}, "Longitude") {
+				t.Fatalf("expected addresses to have a longitude column")
+			}
+
+			if err := MigrateTo(db, 0); err != nil {
+				t.Fatalf("received unexpected error when reverting: %s", err)
+			}
+
+			if db.Migrator().HasTable(&Address{}) {
+				t.Fatalf("expected addresses to be dropped")
+			}
+
+			if err := Migrate(db); err != nil {
+				t.Fatalf("received unexpected error when reapplying: %s", err)
+			}
+		})
+	}
+}
+
+func TestMigrateSnapshot(t *testing.T) {
+	// the schema as created by AutoMigrate, before migrations were introduced
+	type Address struct {
+		ID                        uint   `gorm:"primaryKey"`
+		DawaID                    string `gorm:"not null;unique"`
+		StreetName                string `gorm:"not null"`
+		StreetNumber              string `gorm:"not null"`
+		Floor                     *string
+		Door                      *string
+		PostalCode                string  `gorm:"not null"`
+		MunicipalityCode          string  `gorm:"not null"`
+		Latitude                  float64 `gorm:"not null"`
+		Longtitude                float64 `gorm:"not null"`
+		BoligaCollectedAt         time.Time
+		BoligaPropertyKind        int
+		BoligaBuildingSize        int
+		BoligaPropertySize        int
+		BoligaBasementSize        int
+		BoligaRooms               int
+		BoligaBuiltYear           int
+		BoligaMonthlyOwnerExpense int
+		BoligaEnergyMarking       string
+	}
+
+	type Sale struct {
+		AddrID    uint
+		AmountDKK int
+		Date      time.Time
+	}
+
+	type DawaQueryCache struct {
+		Query     string `gorm:"not null,unique"`
+		IDs       string `gorm:"not null"`
+		CreatedAt time.Time
+	}
+
+	for name, db := range emptyTestDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := db.AutoMigrate(&Address{}, &Sale{}, &DawaQueryCache{}); err != nil {
+				t.Fatalf("unable to create snapshot: %s", err)
+			}
+
+			addr := Address{
+				DawaID:           "Vej 1, 1000 By",
+				StreetName:       "Vej",
+				StreetNumber:     "1",
+				PostalCode:       "1000",
+				MunicipalityCode: "0101",
+				Latitude:         12.5,
+				Longtitude:       55.6,
+			}
+			db.Create(&addr)
+			db.Create(&Sale{AddrID: addr.ID, AmountDKK: 1000000, Date: time.Now()})
+			db.Create(&DawaQueryCache{Query: "q", IDs: "1"})
+
+			if err := Migrate(db); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if db.Migrator().HasTable("dawa_query_caches") {
+				t.Fatalf("expected the legacy query cache to be dropped")
+			}
+
+			var migrated []struct {
+				ID        uint
+				Longitude float64
+			}
+			db.Table("addresses").Select("id, longitude").Scan(&migrated)
+			if len(migrated) != 1 {
+				t.Fatalf("unexpected amount of addresses: %d (expected: 1)", len(migrated))
+			}
+
+			if migrated[0].Longitude != addr.Longtitude {
+				t.Fatalf("unexpected longitude: %f (expected: %f)", migrated[0].Longitude, addr.Longtitude)
+			}
+
+			var sales int64
+			db.Table("sales").Count(&sales)
+			if sales != 1 {
+				t.Fatalf("unexpected amount of sales: %d (expected: 1)", sales)
+			}
+		})
+	}
+}
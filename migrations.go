@@ -0,0 +1,486 @@
+package hjem
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations is the ordered history of the database schema. Each migration
+// declares the models it touches as they were at that version, so that
+// later changes to the models of the package do not alter old migrations.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial",
+		Up: func(tx *gorm.DB) error {
+			type Address struct {
+				ID                        uint   `gorm:"primaryKey"`
+				DawaID                    string `gorm:"not null;unique"`
+				StreetName                string `gorm:"not null"`
+				StreetNumber              string `gorm:"not null"`
+				Floor                     *string
+				Door                      *string
+				PostalCode                string  `gorm:"not null"`
+				MunicipalityCode          string  `gorm:"not null"`
+				Latitude                  float64 `gorm:"not null"`
+				Longtitude                float64 `gorm:"not null"`
+				BoligaCollectedAt         time.Time
+				BoligaPropertyKind        int
+				BoligaBuildingSize        int
+				BoligaPropertySize        int
+				BoligaBasementSize        int
+				BoligaRooms               int
+				BoligaBuiltYear           int
+				BoligaMonthlyOwnerExpense int
+				BoligaEnergyMarking       string
+			}
+
+			type Sale struct {
+				AddrID    uint
+				AmountDKK int
+				Date      time.Time
+			}
+
+			type DawaQuery struct {
+				ID        uint   `gorm:"primaryKey"`
+				Query     string `gorm:"not null;uniqueIndex"`
+				Kind      string `gorm:"not null;index"`
+				CreatedAt time.Time
+			}
+
+			type DawaQueryAddress struct {
+				QueryID   uint `gorm:"primaryKey"`
+				AddressID uint `gorm:"primaryKey;index"`
+				Position  int  `gorm:"not null"`
+			}
+
+			// the comma separated query cache preceding dawa_queries
+			if tx.Migrator().HasTable("dawa_query_caches") {
+				if err := tx.Migrator().DropTable("dawa_query_caches"); err != nil {
+					return err
+				}
+			}
+
+			return tx.AutoMigrate(&Address{}, &Sale{}, &DawaQuery{}, &DawaQueryAddress{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("dawa_query_addresses", "dawa_queries", "sales", "addresses")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "rename_longitude",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE addresses RENAME COLUMN longtitude TO longitude").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE addresses RENAME COLUMN longitude TO longtitude").Error
+		},
+	},
+	{
+		Version: 3,
+		Name:    "index_sales_addr_id",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE INDEX idx_sales_addr_id ON sales (addr_id)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP INDEX IF EXISTS idx_sales_addr_id").Error
+		},
+	},
+	{
+		Version: 4,
+		Name:    "unique_sales",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("ALTER TABLE sales ADD COLUMN sale_type text NOT NULL DEFAULT ''").Error; err != nil {
+				return err
+			}
+
+			// identical sales are removed before the index is created. The
+			// statements are kept here, rather than shared with the
+			// dedupe-sales command, so the migration stays as released
+			stmts := []string{
+				"CREATE TABLE sales_dedupe AS SELECT DISTINCT * FROM sales",
+				"DELETE FROM sales",
+				"INSERT INTO sales SELECT * FROM sales_dedupe",
+				"DROP TABLE sales_dedupe",
+			}
+			for _, stmt := range stmts {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+
+			return tx.Exec("CREATE UNIQUE INDEX idx_sales_unique ON sales (addr_id, date, amount_dkk, sale_type)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			type Sale struct {
+				AddrID    uint
+				AmountDKK int
+				Date      time.Time
+				SaleType  string
+			}
+
+			if err := tx.Exec("DROP INDEX IF EXISTS idx_sales_unique").Error; err != nil {
+				return err
+			}
+
+			if err := tx.Migrator().DropColumn(&Sale{}, "SaleType"); err != nil {
+				return err
+			}
+
+			// sqlite drops columns by recreating the table, losing its indices
+			return tx.Exec("CREATE INDEX IF NOT EXISTS idx_sales_addr_id ON sales (addr_id)").Error
+		},
+	},
+	{
+		Version: 5,
+		Name:    "address_attributes",
+		Up: func(tx *gorm.DB) error {
+			type AddressAttributes struct {
+				AddrID              uint      `gorm:"primaryKey;autoIncrement:false"`
+				ObservedAt          time.Time `gorm:"primaryKey"`
+				Kind                int
+				BuildingSize        int
+				PropertySize        int
+				BasementSize        int
+				Rooms               int
+				BuiltYear           int
+				MonthlyOwnerExpense int
+				EnergyMarking       string
+			}
+
+			if err := tx.AutoMigrate(&AddressAttributes{}); err != nil {
+				return err
+			}
+
+			// the attributes collected so far are the first observations
+			return tx.Exec(`INSERT INTO address_attributes
+(addr_id, observed_at, kind, building_size, property_size, basement_size, rooms, built_year, monthly_owner_expense, energy_marking)
+SELECT id, boliga_collected_at, boliga_property_kind, boliga_building_size, boliga_property_size, boliga_basement_size, boliga_rooms, boliga_built_year, boliga_monthly_owner_expense, boliga_energy_marking
+FROM addresses
+WHERE boliga_property_kind <> 0`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("address_attributes")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "buildings",
+		Up: func(tx *gorm.DB) error {
+			type Building struct {
+				ID               uint   `gorm:"primaryKey"`
+				DawaID           string `gorm:"not null;uniqueIndex"`
+				StreetName       string `gorm:"not null"`
+				StreetNumber     string `gorm:"not null"`
+				PostalCode       string `gorm:"not null"`
+				MunicipalityCode string `gorm:"not null"`
+				Latitude         float64
+				Longitude        float64
+			}
+
+			type Address struct {
+				BuildingID *uint
+			}
+
+			if err := tx.AutoMigrate(&Building{}); err != nil {
+				return err
+			}
+
+			if err := tx.Migrator().AddColumn(&Address{}, "BuildingID"); err != nil {
+				return err
+			}
+
+			return tx.Exec("CREATE INDEX idx_addresses_building_id ON addresses (building_id)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			type Address struct {
+				BuildingID *uint
+			}
+
+			if err := tx.Exec("DROP INDEX IF EXISTS idx_addresses_building_id").Error; err != nil {
+				return err
+			}
+
+			if err := tx.Migrator().DropColumn(&Address{}, "BuildingID"); err != nil {
+				return err
+			}
+
+			return tx.Migrator().DropTable("buildings")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "property_valuations",
+		Up: func(tx *gorm.DB) error {
+			type PropertyValuation struct {
+				ID            uint `gorm:"primaryKey"`
+				AddrID        uint `gorm:"not null;uniqueIndex:idx_valuations_addr_year"`
+				BFE           int  `gorm:"index"`
+				Year          int  `gorm:"not null;uniqueIndex:idx_valuations_addr_year"`
+				PropertyValue int
+				LandValue     int
+			}
+
+			return tx.AutoMigrate(&PropertyValuation{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("property_valuations")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "bbr_enrichment",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range bbrAddressFields {
+				if err := tx.Migrator().AddColumn(&bbrAddress{}, field); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, field := range bbrAddressFields {
+				if err := tx.Migrator().DropColumn(&bbrAddress{}, field); err != nil {
+					return err
+				}
+			}
+
+			// sqlite drops columns by recreating the table, losing its indices
+			return tx.Exec("CREATE INDEX IF NOT EXISTS idx_addresses_building_id ON addresses (building_id)").Error
+		},
+	},
+	{
+		Version: 9,
+		Name:    "listings",
+		Up: func(tx *gorm.DB) error {
+			type Listing struct {
+				ID           uint   `gorm:"primaryKey"`
+				BoligaID     int    `gorm:"not null;uniqueIndex"`
+				Address      string `gorm:"not null"`
+				ZipCode      int    `gorm:"not null;index"`
+				Kind         int
+				BuildingSize int
+				Rooms        int
+				BuiltYear    int
+				Latitude     float64
+				Longitude    float64
+				AskingPrice  int
+				ListedAt     time.Time
+				LastSeenAt   time.Time
+				Active       bool `gorm:"not null;index"`
+			}
+
+			type ListingPrice struct {
+				ListingID   uint      `gorm:"primaryKey;autoIncrement:false"`
+				ObservedAt  time.Time `gorm:"primaryKey"`
+				AskingPrice int
+			}
+
+			type ListingArea struct {
+				ZipCode     int `gorm:"primaryKey;autoIncrement:false"`
+				CollectedAt time.Time
+			}
+
+			return tx.AutoMigrate(&Listing{}, &ListingPrice{}, &ListingArea{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("listing_areas", "listing_prices", "listings")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "sales_asking_price",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE sales ADD COLUMN asking_price bigint NOT NULL DEFAULT 0").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			type Sale struct {
+				AddrID      uint
+				AmountDKK   int
+				Date        time.Time
+				SaleType    string
+				AskingPrice int
+			}
+
+			if err := tx.Migrator().DropColumn(&Sale{}, "AskingPrice"); err != nil {
+				return err
+			}
+
+			// sqlite drops columns by recreating the table, losing its indices
+			if err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_sales_addr_id ON sales (addr_id)").Error; err != nil {
+				return err
+			}
+
+			return tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_sales_unique ON sales (addr_id, date, amount_dkk, sale_type)").Error
+		},
+	},
+	{
+		Version: 11,
+		Name:    "watches",
+		Up: func(tx *gorm.DB) error {
+			type Watch struct {
+				ID        uint   `gorm:"primaryKey"`
+				AddrID    uint   `gorm:"not null;index"`
+				Address   string `gorm:"not null"`
+				Radius    int    `gorm:"not null"`
+				Kind      int
+				Webhook   string
+				Email     string
+				CreatedAt time.Time
+				CheckedAt time.Time
+			}
+
+			type WatchSale struct {
+				ID        uint      `gorm:"primaryKey"`
+				WatchID   uint      `gorm:"not null;uniqueIndex:idx_watch_sales_unique"`
+				AddrID    uint      `gorm:"not null;uniqueIndex:idx_watch_sales_unique"`
+				Address   string    `gorm:"not null"`
+				AmountDKK int       `gorm:"not null;uniqueIndex:idx_watch_sales_unique"`
+				Date      time.Time `gorm:"not null;uniqueIndex:idx_watch_sales_unique"`
+				FoundAt   time.Time `gorm:"index"`
+			}
+
+			return tx.AutoMigrate(&Watch{}, &WatchSale{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("watch_sales", "watches")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "saved_lookups",
+		Up: func(tx *gorm.DB) error {
+			type SavedLookup struct {
+				ID        string `gorm:"primaryKey"`
+				Query     string `gorm:"not null"`
+				DawaID    string `gorm:"not null;index"`
+				Ranges    string
+				Filter    int
+				CreatedAt time.Time
+				Result    string `gorm:"not null"`
+			}
+
+			return tx.AutoMigrate(&SavedLookup{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("saved_lookups")
+		},
+	},
+	{
+		Version: 13,
+		Name:    "watch_tokens",
+		Up: func(tx *gorm.DB) error {
+			type Watch struct {
+				ID    uint   `gorm:"primaryKey"`
+				Token string `gorm:"not null;default:''"`
+			}
+
+			if err := tx.Migrator().AddColumn(&Watch{}, "Token"); err != nil {
+				return err
+			}
+
+			// existing watches are given a token, which their owners can
+			// get from the database
+			var watches []Watch
+			if err := tx.Find(&watches).Error; err != nil {
+				return err
+			}
+
+			for _, w := range watches {
+				token, err := newWatchToken()
+				if err != nil {
+					return err
+				}
+
+				if err := tx.Model(&w).Update("token", token).Error; err != nil {
+					return err
+				}
+			}
+
+			return tx.Exec("CREATE UNIQUE INDEX idx_watches_token ON watches (token)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			type Watch struct {
+				Token string
+			}
+
+			if err := tx.Exec("DROP INDEX IF EXISTS idx_watches_token").Error; err != nil {
+				return err
+			}
+
+			return tx.Migrator().DropColumn(&Watch{}, "Token")
+		},
+	},
+	{
+		Version: 14,
+		Name:    "saved_lookup_tokens",
+		Up: func(tx *gorm.DB) error {
+			type SavedLookup struct {
+				ID    string `gorm:"primaryKey"`
+				Token string `gorm:"not null;default:''"`
+			}
+
+			if err := tx.Migrator().AddColumn(&SavedLookup{}, "Token"); err != nil {
+				return err
+			}
+
+			var saved []SavedLookup
+			if err := tx.Find(&saved).Error; err != nil {
+				return err
+			}
+
+			for _, sl := range saved {
+				token, err := newSavedLookupToken()
+				if err != nil {
+					return err
+				}
+
+				if err := tx.Model(&sl).Update("token", token).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			type SavedLookup struct {
+				Token string
+			}
+
+			return tx.Migrator().DropColumn(&SavedLookup{}, "Token")
+		},
+	},
+}
+
+// bbrAddress holds the columns added to addresses by the bbr_enrichment
+// migration.
+type bbrAddress struct {
+	BBRCollectedAt    time.Time
+	BBRBuildingSize   int
+	BBRBasementSize   int
+	BBRRooms          int
+	BBRBuiltYear      int
+	BBRRenovationYear int
+	BBRRoofMaterial   string
+	BBRWallMaterial   string
+	BBRHeatingType    string
+}
+
+func (bbrAddress) TableName() string {
+	return "addresses"
+}
+
+var bbrAddressFields = []string{
+	"BBRCollectedAt",
+	"BBRBuildingSize",
+	"BBRBasementSize",
+	"BBRRooms",
+	"BBRBuiltYear",
+	"BBRRenovationYear",
+	"BBRRoofMaterial",
+	"BBRWallMaterial",
+	"BBRHeatingType",
+}
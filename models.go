@@ -111,9 +111,6 @@ type Store struct {
 }
 
 func NewStore(db *gorm.DB) (*Store, error) {
-	db.AutoMigrate(&Address{})
-	// db.AutoMigrate(&Sale{})
-
 	return &Store{
 		db: db,
 	}, nil
@@ -0,0 +1,220 @@
+package hjem
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+const (
+	// MaxMortgageRatio is the share of the price a realkredit loan may
+	// cover, while the remainder beyond the down payment is a bank loan.
+	MaxMortgageRatio = 0.8
+
+	// MinDownPaymentRatio is the least share of the price to pay upfront.
+	MinDownPaymentRatio = 0.05
+
+	// MaxInterestOnlyYears is the longest interest-only period
+	// (afdragsfrihed) of a realkredit loan.
+	MaxInterestOnlyYears = 10
+
+	// MaxTermYears and MaxBankTermYears are the longest terms of the
+	// realkredit loan and the bank loan.
+	MaxTermYears     = 30
+	MaxBankTermYears = 40
+)
+
+var (
+	ErrInvalidPrice        = errors.New("price must be positive")
+	ErrInvalidDownPayment  = errors.New("down payment must be at least 5% of the price, and no more than the price")
+	ErrInvalidLoanTerm     = errors.New("loan terms must be positive, and at most 30 years for the realkredit loan and 40 years for the bank loan")
+	ErrInvalidInterestOnly = errors.New("interest-only period must be between 0 and 10 years, and shorter than the loan term")
+	ErrInvalidRate         = errors.New("rates must not be negative")
+)
+
+// LoanParameters describes how the purchase of a property is financed.
+// Rates are annual and in percent.
+type LoanParameters struct {
+	Price             int     `json:"price"`
+	DownPayment       int     `json:"down_payment"`
+	BondRate          float64 `json:"bond_rate"`
+	BankRate          float64 `json:"bank_rate"`
+	TermYears         int     `json:"term_years"`
+	BankTermYears     int     `json:"bank_term_years"`
+	InterestOnlyYears int     `json:"interest_only_years"`
+	OwnerExpense      int     `json:"owner_expense"`
+	TaxDeductionRate  float64 `json:"tax_deduction_rate"`
+}
+
+// DefaultLoanParameters returns the parameters used for those left out of
+// a request: a 30 year realkredit loan, a 20 year bank loan and the
+// approximate value of the Danish interest deduction.
+func DefaultLoanParameters() LoanParameters {
+	return LoanParameters{
+		TermYears:        30,
+		BankTermYears:    20,
+		TaxDeductionRate: 33,
+	}
+}
+
+func (p LoanParameters) Validate() error {
+	if p.Price <= 0 {
+		return ErrInvalidPrice
+	}
+
+	if p.DownPayment > p.Price || float64(p.DownPayment) < MinDownPaymentRatio*float64(p.Price) {
+		return ErrInvalidDownPayment
+	}
+
+	if p.TermYears <= 0 || p.TermYears > MaxTermYears || p.BankTermYears <= 0 || p.BankTermYears > MaxBankTermYears {
+		return ErrInvalidLoanTerm
+	}
+
+	if p.InterestOnlyYears < 0 || p.InterestOnlyYears > MaxInterestOnlyYears || p.InterestOnlyYears >= p.TermYears {
+		return ErrInvalidInterestOnly
+	}
+
+	if p.BondRate < 0 || p.BankRate < 0 || p.TaxDeductionRate < 0 {
+		return ErrInvalidRate
+	}
+
+	return nil
+}
+
+// AmortizationRow is a monthly payment of the loans. Gross is the total
+// monthly cost including the owner expense, while Net is the cost after
+// the tax deduction of the interest.
+type AmortizationRow struct {
+	Month         int     `json:"month"`
+	BondInterest  float64 `json:"bond_interest"`
+	BondPrincipal float64 `json:"bond_principal"`
+	BondBalance   float64 `json:"bond_balance"`
+	BankInterest  float64 `json:"bank_interest"`
+	BankPrincipal float64 `json:"bank_principal"`
+	BankBalance   float64 `json:"bank_balance"`
+	Gross         float64 `json:"gross"`
+	Net           float64 `json:"net"`
+}
+
+func (r AmortizationRow) ToSlice() []string {
+	f := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+
+	return []string{
+		strconv.Itoa(r.Month),
+		f(r.BondInterest),
+		f(r.BondPrincipal),
+		f(r.BondBalance),
+		f(r.BankInterest),
+		f(r.BankPrincipal),
+		f(r.BankBalance),
+		f(r.Gross),
+		f(r.Net),
+	}
+}
+
+func (r AmortizationRow) Headers() []string {
+	return []string{
+		"month",
+		"bond_interest_dkk",
+		"bond_principal_dkk",
+		"bond_balance_dkk",
+		"bank_interest_dkk",
+		"bank_principal_dkk",
+		"bank_balance_dkk",
+		"gross_dkk",
+		"net_dkk",
+	}
+}
+
+type Affordability struct {
+	LoanParameters
+	BondLoan      int               `json:"bond_loan"`
+	BankLoan      int               `json:"bank_loan"`
+	MonthlyGross  float64           `json:"monthly_gross"`
+	MonthlyNet    float64           `json:"monthly_net"`
+	TotalInterest float64           `json:"total_interest"`
+	Schedule      []AmortizationRow `json:"schedule"`
+}
+
+// annuity returns the monthly payment which repays principal over months
+// at the monthly rate.
+func annuity(principal, rate float64, months int) float64 {
+	if months <= 0 {
+		return principal
+	}
+
+	if rate == 0 {
+		return principal / float64(months)
+	}
+
+	return principal * rate / (1 - math.Pow(1+rate, -float64(months)))
+}
+
+// CalculateAffordability computes the monthly payments of financing a
+// purchase with a realkredit loan of up to MaxMortgageRatio of the price,
+// and a bank loan for the remainder beyond the down payment. MonthlyGross
+// and MonthlyNet are the costs of the first month.
+func CalculateAffordability(p LoanParameters) (*Affordability, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	loan := p.Price - p.DownPayment
+	bond := int(math.Min(float64(loan), MaxMortgageRatio*float64(p.Price)))
+	bank := loan - bond
+
+	out := Affordability{
+		LoanParameters: p,
+		BondLoan:       bond,
+		BankLoan:       bank,
+	}
+
+	bondRate, bankRate := p.BondRate/100/12, p.BankRate/100/12
+	bondMonths, bankMonths := p.TermYears*12, p.BankTermYears*12
+	ioMonths := p.InterestOnlyYears * 12
+
+	bondBalance, bankBalance := float64(bond), float64(bank)
+	bondPayment := annuity(bondBalance, bondRate, bondMonths-ioMonths)
+	bankPayment := annuity(bankBalance, bankRate, bankMonths)
+
+	months := bondMonths
+	if bankMonths > months {
+		months = bankMonths
+	}
+
+	for m := 1; m <= months; m++ {
+		row := AmortizationRow{Month: m}
+
+		if bondBalance > 0 {
+			row.BondInterest = bondBalance * bondRate
+			if m > ioMonths {
+				row.BondPrincipal = math.Min(bondPayment-row.BondInterest, bondBalance)
+			}
+			bondBalance -= row.BondPrincipal
+		}
+
+		if bankBalance > 0 {
+			row.BankInterest = bankBalance * bankRate
+			row.BankPrincipal = math.Min(bankPayment-row.BankInterest, bankBalance)
+			bankBalance -= row.BankPrincipal
+		}
+
+		row.BondBalance, row.BankBalance = bondBalance, bankBalance
+
+		interest := row.BondInterest + row.BankInterest
+		row.Gross = interest + row.BondPrincipal + row.BankPrincipal + float64(p.OwnerExpense)
+		row.Net = row.Gross - interest*p.TaxDeductionRate/100
+
+		out.TotalInterest += interest
+		out.Schedule = append(out.Schedule, row)
+	}
+
+	if len(out.Schedule) > 0 {
+		out.MonthlyGross = out.Schedule[0].Gross
+		out.MonthlyNet = out.Schedule[0].Net
+	}
+
+	return &out, nil
+}
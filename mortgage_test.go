@@ -0,0 +1,106 @@
+package hjem
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalculateAffordability(t *testing.T) {
+	p := DefaultLoanParameters()
+	p.Price = 3000000
+	p.DownPayment = 150000
+	p.BondRate = 4
+	p.BankRate = 6
+	p.OwnerExpense = 2000
+
+	aff, err := CalculateAffordability(p)
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	if aff.BondLoan != 2400000 || aff.BankLoan != 450000 {
+		t.Fatalf("unexpected loans: %d and %d (expected: 2400000 and 450000)", aff.BondLoan, aff.BankLoan)
+	}
+
+	if len(aff.Schedule) != 360 {
+		t.Fatalf("unexpected length of schedule: %d (expected: 360)", len(aff.Schedule))
+	}
+
+	last := aff.Schedule[len(aff.Schedule)-1]
+	if math.Abs(last.BondBalance) > 0.01 || math.Abs(last.BankBalance) > 0.01 {
+		t.Fatalf("loans not repaid: %f and %f", last.BondBalance, last.BankBalance)
+	}
+
+	// annuity of 2.4m over 30 years at 4%, and 450k over 20 years at 6%
+	expected := 11457.97 + 3223.94 + 2000
+	if math.Abs(aff.MonthlyGross-expected) > 0.01 {
+		t.Fatalf("unexpected monthly gross: %.2f (expected: %.2f)", aff.MonthlyGross, expected)
+	}
+
+	first := aff.Schedule[0]
+	interest := first.BondInterest + first.BankInterest
+	if math.Abs(aff.MonthlyNet-(aff.MonthlyGross-interest*0.33)) > 0.01 {
+		t.Fatalf("unexpected monthly net: %.2f", aff.MonthlyNet)
+	}
+}
+
+func TestCalculateAffordabilityInterestOnly(t *testing.T) {
+	p := DefaultLoanParameters()
+	p.Price = 2000000
+	p.DownPayment = 400000
+	p.BondRate = 3
+	p.InterestOnlyYears = 10
+
+	aff, err := CalculateAffordability(p)
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	if aff.BankLoan != 0 {
+		t.Fatalf("unexpected bank loan: %d (expected: 0)", aff.BankLoan)
+	}
+
+	if aff.Schedule[119].BondPrincipal != 0 || aff.Schedule[119].BondBalance != 1600000 {
+		t.Fatalf("expected no repayment during interest-only period: %+v", aff.Schedule[119])
+	}
+
+	if aff.Schedule[120].BondPrincipal <= 0 {
+		t.Fatalf("expected repayment after interest-only period: %+v", aff.Schedule[120])
+	}
+
+	if b := aff.Schedule[359].BondBalance; math.Abs(b) > 0.01 {
+		t.Fatalf("loan not repaid: %f", b)
+	}
+}
+
+func TestLoanParametersValidate(t *testing.T) {
+	valid := DefaultLoanParameters()
+	valid.Price = 1000000
+	valid.DownPayment = 50000
+
+	tt := []struct {
+		name   string
+		modify func(p *LoanParameters)
+		err    error
+	}{
+		{name: "valid", modify: func(p *LoanParameters) {}},
+		{name: "no price", modify: func(p *LoanParameters) { p.Price = 0 }, err: ErrInvalidPrice},
+		{name: "small down payment", modify: func(p *LoanParameters) { p.DownPayment = 49999 }, err: ErrInvalidDownPayment},
+		{name: "no term", modify: func(p *LoanParameters) { p.TermYears = 0 }, err: ErrInvalidLoanTerm},
+		{name: "long term", modify: func(p *LoanParameters) { p.TermYears = 31 }, err: ErrInvalidLoanTerm},
+		{name: "long bank term", modify: func(p *LoanParameters) { p.BankTermYears = 100000000 }, err: ErrInvalidLoanTerm},
+		{name: "long interest-only", modify: func(p *LoanParameters) { p.InterestOnlyYears = 11 }, err: ErrInvalidInterestOnly},
+		{name: "negative rate", modify: func(p *LoanParameters) { p.BondRate = -1 }, err: ErrInvalidRate},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p := valid
+			tc.modify(&p)
+
+			if err := p.Validate(); err != tc.err {
+				t.Fatalf("unexpected error: %v (expected: %v)", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package hjem
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const openAPIVersion = "3.1.0"
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	rawJSONType   = reflect.TypeOf(json.RawMessage{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// openAPISchemas holds the named schemas of an OpenAPI document, i.e. its
+// "components/schemas", by the name of their Go type.
+type openAPISchemas map[string]interface{}
+
+// nullable allows a schema to be null, as nil pointers, slices and maps are
+// encoded as null.
+func nullable(schema map[string]interface{}) map[string]interface{} {
+	if t, ok := schema["type"].(string); ok {
+		schema["type"] = []string{t, "null"}
+		return schema
+	}
+
+	return map[string]interface{}{
+		"oneOf": []interface{}{schema, map[string]interface{}{"type": "null"}},
+	}
+}
+
+// schemaOf returns the JSON schema of the values of type t, as encoded by
+// encoding/json. Named structs are added to sc, and referenced.
+func (sc openAPISchemas) schemaOf(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawJSONType:
+		return map[string]interface{}{}
+	case t.Kind() != reflect.Ptr && t.Implements(marshalerType):
+		// the encoding is up to the type
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(sc.schemaOf(t.Elem()))
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return nullable(map[string]interface{}{
+			"type":  "array",
+			"items": sc.schemaOf(t.Elem()),
+		})
+	case reflect.Map:
+		return nullable(map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": sc.schemaOf(t.Elem()),
+		})
+	case reflect.Struct:
+		if t.Name() == "" {
+			return sc.structSchema(t)
+		}
+
+		if _, ok := sc[t.Name()]; !ok {
+			// reserve the name first, in case the struct refers to itself
+			sc[t.Name()] = nil
+			sc[t.Name()] = sc.structSchema(t)
+		}
+
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	return map[string]interface{}{}
+}
+
+func (sc openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	sc.addFields(t, props, &required)
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
+
+// addFields adds the encoded fields of the struct t to props, including the
+// fields of its embedded structs.
+func (sc openAPISchemas) addFields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			sc.addFields(f.Type, props, required)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = sc.schemaOf(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// OpenAPI returns the OpenAPI document of the v1 API, generated from its
+// routes and the types of their requests and responses.
+func OpenAPI() map[string]interface{} {
+	sc := openAPISchemas{}
+	errSchema := sc.schemaOf(reflect.TypeOf(APIErrorResponse{}))
+
+	paths := map[string]interface{}{}
+	for _, route := range apiV1Routes() {
+		var params []interface{}
+		for _, p := range route.Params {
+			schema := map[string]interface{}{"type": p.Type}
+			if p.Multi {
+				schema = map[string]interface{}{"type": "array", "items": schema}
+			}
+
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required || p.In == "path",
+				"schema":      schema,
+			})
+		}
+
+		response := map[string]interface{}{"type": "object"}
+		if route.Response != nil {
+			response = sc.schemaOf(reflect.TypeOf(route.Response))
+		}
+
+		content := map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": response},
+		}
+		for _, ct := range route.Streams {
+			content[ct] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+
+		op := map[string]interface{}{
+			"operationId": route.Operation,
+			"summary":     route.Summary,
+			"responses": map[string]interface{}{
+				strconv.Itoa(route.Status): map[string]interface{}{
+					"description": http.StatusText(route.Status),
+					"content":     content,
+				},
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": errSchema},
+					},
+				},
+			},
+		}
+
+		if params != nil {
+			op["parameters"] = params
+		}
+
+		if route.Body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": sc.schemaOf(reflect.TypeOf(route.Body)),
+					},
+				},
+			}
+		}
+
+		item, ok := paths[route.Path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "hjem",
+			"version": "1",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": apiV1Prefix},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": sc,
+		},
+	}
+}
@@ -0,0 +1,266 @@
+package hjem
+
+import (
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// replaceSales replaces the stored sales of an address. Sales are upserted,
+// so concurrent refreshes of the same address cannot store a sale twice.
+func replaceSales(tx *gorm.DB, addrID uint, sales []Sale) error {
+	if err := tx.Where("addr_id = ?", addrID).Delete(&Sale{}).Error; err != nil {
+		return err
+	}
+
+	if len(sales) == 0 {
+		return nil
+	}
+
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(&sales, insertBatchSize(tx, &Sale{})).Error
+}
+
+// DedupeSales removes duplicated sales, and returns the number of sales
+// removed. Besides identical sales, this covers sales stored before their
+// sale type was recorded, which have since been stored again along with
+// their sale type.
+func DedupeSales(db *gorm.DB) (int64, error) {
+	var n int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		removed, err := dedupeIdenticalSales(tx)
+		if err != nil {
+			return err
+		}
+		n += removed
+
+		res := tx.Exec(`DELETE FROM sales
+WHERE sale_type = ''
+AND EXISTS (
+	SELECT 1 FROM sales typed
+	WHERE typed.addr_id = sales.addr_id
+	AND typed.date = sales.date
+	AND typed.amount_dkk = sales.amount_dkk
+	AND typed.sale_type <> ''
+)`)
+		if err := res.Error; err != nil {
+			return err
+		}
+		n += res.RowsAffected
+
+		return nil
+	})
+
+	return n, err
+}
+
+// dedupeIdenticalSales removes sales which are identical in every column.
+// As sales have no primary key, the distinct sales are copied aside and
+// written back.
+func dedupeIdenticalSales(tx *gorm.DB) (int64, error) {
+	var total, distinct int64
+	if err := tx.Table("sales").Count(&total).Error; err != nil {
+		return 0, err
+	}
+
+	if err := tx.Raw("SELECT count(*) FROM (SELECT DISTINCT * FROM sales) d").Scan(&distinct).Error; err != nil {
+		return 0, err
+	}
+
+	if total == distinct {
+		return 0, nil
+	}
+
+	stmts := []string{
+		"CREATE TABLE sales_dedupe AS SELECT DISTINCT * FROM sales",
+		"DELETE FROM sales",
+		"INSERT INTO sales SELECT * FROM sales_dedupe",
+		"DROP TABLE sales_dedupe",
+	}
+	for _, stmt := range stmts {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	return total - distinct, nil
+}
+
+const (
+	defaultSalesLimit = 100
+	maxSalesLimit     = 1000
+)
+
+// SalesQuery filters the stored sales. Filters left at their zero value
+// are not applied. BBox is given as min longitude, min latitude, max
+// longitude and max latitude, and Polygon as a ring of longitude and
+// latitude pairs. From and To are inclusive. A Limit of 0 returns every
+// sale after Offset.
+type SalesQuery struct {
+	ZipFrom        int
+	ZipTo          int
+	Municipalities []string
+	Kinds          []PropertyType
+	From           time.Time
+	To             time.Time
+	MinPrice       int
+	MaxPrice       int
+	MinSize        int
+	MaxSize        int
+	BBox           []float64
+	Polygon        [][2]float64
+	Offset         int
+	Limit          int
+}
+
+// StoredSale is a stored sale, along with the address it was a sale of.
+type StoredSale struct {
+	Address     *Address  `json:"address"`
+	Amount      int       `json:"amount"`
+	When        time.Time `json:"when"`
+	SaleType    string    `json:"sale_type"`
+	AskingPrice int       `json:"asking_price,omitempty"`
+}
+
+func (s StoredSale) Headers() []string {
+	return append(s.Address.Headers(), "amount", "when", "sale_type", "asking_price_dkk")
+}
+
+func (s StoredSale) ToSlice() []string {
+	var asking string
+	if s.AskingPrice > 0 {
+		asking = strconv.Itoa(s.AskingPrice)
+	}
+
+	return append(s.Address.ToSlice(), strconv.Itoa(s.Amount), s.When.Format(time.RFC3339), s.SaleType, asking)
+}
+
+// storedSaleRow is a row of the join of sales and addresses.
+type storedSaleRow struct {
+	Address     `gorm:"embedded"`
+	AmountDKK   int
+	Date        time.Time
+	SaleType    string
+	AskingPrice int
+}
+
+// QuerySales calls fn with each stored sale matching q, newest first.
+func QuerySales(db *gorm.DB, q SalesQuery, fn func(StoredSale) error) error {
+	tx := db.Table("sales").
+		Select("addresses.*, sales.amount_dkk, sales.date, sales.sale_type, sales.asking_price").
+		Joins("JOIN addresses ON addresses.id = sales.addr_id").
+		Order("sales.date DESC, sales.addr_id, sales.amount_dkk, sales.sale_type")
+
+	if q.ZipFrom > 0 {
+		tx = tx.Where("CAST(addresses.postal_code AS INTEGER) >= ?", q.ZipFrom)
+	}
+
+	if q.ZipTo > 0 {
+		tx = tx.Where("CAST(addresses.postal_code AS INTEGER) <= ?", q.ZipTo)
+	}
+
+	if len(q.Municipalities) > 0 {
+		tx = tx.Where("addresses.municipality_code IN ?", q.Municipalities)
+	}
+
+	if len(q.Kinds) > 0 {
+		tx = tx.Where("addresses.boliga_property_kind IN ?", q.Kinds)
+	}
+
+	if !q.From.IsZero() {
+		tx = tx.Where("sales.date >= ?", q.From)
+	}
+
+	if !q.To.IsZero() {
+		tx = tx.Where("sales.date < ?", q.To.AddDate(0, 0, 1))
+	}
+
+	if q.MinPrice > 0 {
+		tx = tx.Where("sales.amount_dkk >= ?", q.MinPrice)
+	}
+
+	if q.MaxPrice > 0 {
+		tx = tx.Where("sales.amount_dkk <= ?", q.MaxPrice)
+	}
+
+	if q.MinSize > 0 {
+		tx = tx.Where("addresses.boliga_building_size >= ?", q.MinSize)
+	}
+
+	if q.MaxSize > 0 {
+		tx = tx.Where("addresses.boliga_building_size <= ?", q.MaxSize)
+	}
+
+	bbox := q.BBox
+	if len(q.Polygon) > 0 {
+		bbox = polygonBBox(q.Polygon)
+	}
+
+	// the longitude of an address is stored as its Latitude, see LonLat
+	if len(bbox) == 4 {
+		tx = tx.Where("addresses.latitude BETWEEN ? AND ?", bbox[0], bbox[2]).
+			Where("addresses.longitude BETWEEN ? AND ?", bbox[1], bbox[3])
+	}
+
+	// without a polygon, every row matches, so the page is left to the
+	// database
+	skip, remaining := q.Offset, q.Limit
+	if len(q.Polygon) == 0 {
+		if q.Offset > 0 {
+			tx = tx.Offset(q.Offset)
+		}
+
+		if q.Limit > 0 {
+			tx = tx.Limit(q.Limit)
+		}
+		skip = 0
+	}
+
+	rows, err := tx.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row storedSaleRow
+		if err := db.ScanRows(rows, &row); err != nil {
+			return err
+		}
+
+		if len(q.Polygon) > 0 {
+			lon, lat := row.Address.LonLat()
+			if !inPolygon(lon, lat, q.Polygon) {
+				continue
+			}
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		addr := row.Address
+		err := fn(StoredSale{
+			Address:     &addr,
+			Amount:      row.AmountDKK,
+			When:        row.Date,
+			SaleType:    row.SaleType,
+			AskingPrice: row.AskingPrice,
+		})
+		if err != nil {
+			return err
+		}
+
+		if q.Limit > 0 {
+			remaining--
+			if remaining == 0 {
+				break
+			}
+		}
+	}
+
+	return rows.Err()
+}
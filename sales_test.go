@@ -0,0 +1,239 @@
+package hjem
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestReplaceSales(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			date := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+			sale := Sale{AddrID: 1, AmountDKK: 2000000, Date: date, SaleType: "alm. frit salg"}
+
+			for i := 0; i < 2; i++ {
+				if err := replaceSales(db, 1, []Sale{sale, sale}); err != nil {
+					t.Fatalf("received unexpected error: %s", err)
+				}
+			}
+
+			var count int64
+			db.Model(&Sale{}).Count(&count)
+			if count != 1 {
+				t.Fatalf("unexpected amount of sales: %d (expected: 1)", count)
+			}
+		})
+	}
+}
+
+func TestDedupeSales(t *testing.T) {
+	for name, db := range emptyTestDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := MigrateTo(db, 3); err != nil {
+				t.Fatalf("unable to migrate: %s", err)
+			}
+
+			date := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+			for i := 0; i < 3; i++ {
+				db.Exec("INSERT INTO sales (addr_id, amount_dkk, date) VALUES (?, ?, ?)", 1, 2000000, date)
+			}
+			db.Exec("INSERT INTO sales (addr_id, amount_dkk, date) VALUES (?, ?, ?)", 1, 1500000, date.AddDate(-5, 0, 0))
+
+			if err := Migrate(db); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			var count int64
+			db.Model(&Sale{}).Count(&count)
+			if count != 2 {
+				t.Fatalf("unexpected amount of sales after migrating: %d (expected: 2)", count)
+			}
+
+			// the same sale, stored again along with its sale type
+			db.Create(&Sale{AddrID: 1, AmountDKK: 2000000, Date: date, SaleType: "alm. frit salg"})
+
+			n, err := DedupeSales(db)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if n != 1 {
+				t.Fatalf("unexpected amount of removed sales: %d (expected: 1)", n)
+			}
+
+			db.Model(&Sale{}).Count(&count)
+			if count != 2 {
+				t.Fatalf("unexpected amount of sales after deduping: %d (expected: 2)", count)
+			}
+		})
+	}
+}
+
+// seedSales stores three addresses, in two postal codes and municipalities,
+// each with a sale per year from 2018 to 2020.
+func seedSales(t *testing.T, db *gorm.DB) []*Address {
+	t.Helper()
+
+	addrs := []*Address{
+		{DawaID: "Vej 1, 2000 By", StreetName: "Vej", StreetNumber: "1", PostalCode: "2000", MunicipalityCode: "0147", Latitude: 12.50, Longitude: 55.70, BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+		{DawaID: "Vej 2, 2000 By", StreetName: "Vej", StreetNumber: "2", PostalCode: "2000", MunicipalityCode: "0147", Latitude: 12.52, Longitude: 55.70, BoligaPropertyKind: PropertyApartment, BoligaBuildingSize: 60},
+		{DawaID: "Vej 3, 2500 By", StreetName: "Vej", StreetNumber: "3", PostalCode: "2500", MunicipalityCode: "0101", Latitude: 12.60, Longitude: 55.80, BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 150},
+	}
+
+	for i, a := range addrs {
+		if err := db.Create(a).Error; err != nil {
+			t.Fatalf("unable to create address: %s", err)
+		}
+
+		for year := 2018; year <= 2020; year++ {
+			sale := Sale{
+				AddrID:    a.ID,
+				AmountDKK: (i + 1) * year * 1000,
+				Date:      time.Date(year, 6, 1, 0, 0, 0, 0, time.UTC),
+			}
+			if err := db.Create(&sale).Error; err != nil {
+				t.Fatalf("unable to create sale: %s", err)
+			}
+		}
+	}
+
+	return addrs
+}
+
+func TestQuerySales(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			seedSales(t, db)
+
+			tests := []struct {
+				name  string
+				query SalesQuery
+				n     int
+			}{
+				{name: "all", n: 9},
+				{name: "zip", query: SalesQuery{ZipFrom: 2000, ZipTo: 2000}, n: 6},
+				{name: "zip range", query: SalesQuery{ZipFrom: 2100, ZipTo: 2900}, n: 3},
+				{name: "municipality", query: SalesQuery{Municipalities: []string{"0101"}}, n: 3},
+				{name: "kind", query: SalesQuery{Kinds: []PropertyType{PropertyApartment}}, n: 3},
+				{name: "dates", query: SalesQuery{From: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)}, n: 3},
+				{name: "price", query: SalesQuery{MinPrice: 4000000, MaxPrice: 5000000}, n: 3},
+				{name: "size", query: SalesQuery{MinSize: 100}, n: 6},
+				{name: "bbox", query: SalesQuery{BBox: []float64{12.49, 55.69, 12.51, 55.71}}, n: 3},
+				{name: "polygon", query: SalesQuery{Polygon: [][2]float64{{12.49, 55.69}, {12.53, 55.69}, {12.49, 55.71}}}, n: 3},
+				{name: "limit", query: SalesQuery{Limit: 4}, n: 4},
+				{name: "offset", query: SalesQuery{Offset: 7, Limit: 4}, n: 2},
+				{name: "polygon offset", query: SalesQuery{Polygon: [][2]float64{{12.4, 55.6}, {12.7, 55.6}, {12.7, 55.9}, {12.4, 55.9}}, Offset: 7, Limit: 4}, n: 2},
+			}
+
+			for _, tc := range tests {
+				t.Run(tc.name, func(t *testing.T) {
+					var sales []StoredSale
+					err := QuerySales(db, tc.query, func(s StoredSale) error {
+						sales = append(sales, s)
+						return nil
+					})
+					if err != nil {
+						t.Fatalf("received unexpected error: %s", err)
+					}
+
+					if len(sales) != tc.n {
+						t.Fatalf("unexpected amount of sales: %d (expected: %d)", len(sales), tc.n)
+					}
+
+					for i := 1; i < len(sales); i++ {
+						if sales[i].When.After(sales[i-1].When) {
+							t.Fatalf("unexpected order of sales: %s after %s", sales[i].When, sales[i-1].When)
+						}
+					}
+				})
+			}
+
+			var sale StoredSale
+			QuerySales(db, SalesQuery{Kinds: []PropertyType{PropertyApartment}, Limit: 1}, func(s StoredSale) error {
+				sale = s
+				return nil
+			})
+
+			if sale.Address.DawaID != "Vej 2, 2000 By" || sale.Amount != 2*2020*1000 {
+				t.Fatalf("unexpected sale: %+v", sale)
+			}
+		})
+	}
+}
+
+func TestHandleSales(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			seedSales(t, db)
+			routes := (&server{db: db}).Routes()
+
+			do := func(url string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				routes.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+				return w
+			}
+
+			var pages int
+			var n int
+			for url := "/api/v1/sales/search?zip=2000-2500&limit=4"; url != ""; pages++ {
+				w := do(url)
+				if w.Code != http.StatusOK {
+					t.Fatalf("unexpected status code: %d (expected: %d): %s", w.Code, http.StatusOK, w.Body)
+				}
+
+				var page SalesPage
+				json.NewDecoder(w.Body).Decode(&page)
+				n += len(page.Sales)
+				url = page.Next
+			}
+
+			if n != 9 || pages != 3 {
+				t.Fatalf("unexpected pages: %d sales in %d pages (expected: 9 sales in 3 pages)", n, pages)
+			}
+
+			w := do("/api/v1/sales/search?type=house&format=csv")
+			rows, err := csv.NewReader(w.Body).ReadAll()
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(rows) != 7 || rows[0][0] != "id" {
+				t.Fatalf("unexpected csv rows: %d (expected: 7)", len(rows))
+			}
+
+			w = do("/api/v1/sales/search?municipality=0101&format=ndjson")
+			if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 3 {
+				t.Fatalf("unexpected ndjson lines: %d (expected: 3)", len(lines))
+			}
+
+			tests := []struct {
+				url  string
+				code string
+			}{
+				{"/api/v1/sales/search?zip=abc", CodeInvalidParameter},
+				{"/api/v1/sales/search?type=castle", CodeInvalidParameter},
+				{"/api/v1/sales/search?from=2020", CodeInvalidParameter},
+				{"/api/v1/sales/search?bbox=1,2,3", CodeInvalidParameter},
+				{"/api/v1/sales/search?polygon=1,2,3,4", CodeInvalidParameter},
+				{"/api/v1/sales/search?limit=100000", CodeInvalidParameter},
+				{"/api/v1/sales/search?format=xml", CodeInvalidParameter},
+			}
+
+			for _, tc := range tests {
+				w := do(tc.url)
+				var out APIErrorResponse
+				json.NewDecoder(w.Body).Decode(&out)
+				if w.Code != http.StatusBadRequest || out.Error.Code != tc.code {
+					t.Fatalf("unexpected reply of %s: %d %+v (expected: %d %s)", tc.url, w.Code, out.Error, http.StatusBadRequest, tc.code)
+				}
+			}
+		})
+	}
+}
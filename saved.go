@@ -0,0 +1,180 @@
+package hjem
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNoSavedLookup = errors.New("no saved lookup with that id")
+)
+
+// SavedLookup is a lookup persisted under a stable id, along with a
+// snapshot of its result, so it can be shared and revisited as it was.
+type SavedLookup struct {
+	ID        string `gorm:"primaryKey"`
+	Query     string `gorm:"not null"`
+	DawaID    string `gorm:"not null;index"`
+	Ranges    string
+	Filter    int
+	CreatedAt time.Time
+	Result    string `gorm:"not null"`
+
+	// Token is given to the creator of the saved lookup, and is required
+	// to delete it.
+	Token string `gorm:"not null;default:''"`
+}
+
+// randomIDEncoding encodes ids in lowercase letters and digits, to keep
+// permalinks readable.
+var randomIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// randomID returns an id of n random bytes.
+func randomID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return randomIDEncoding.EncodeToString(b), nil
+}
+
+func newSavedLookupID() (string, error) {
+	return randomID(10)
+}
+
+func newSavedLookupToken() (string, error) {
+	return randomID(20)
+}
+
+// SaveLookup stores req along with its result resp, and returns the saved
+// lookup.
+func SaveLookup(db *gorm.DB, req LookupRequest, resp *LookupResponse) (*SavedLookup, error) {
+	id, err := newSavedLookupID()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newSavedLookupToken()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := make([]string, len(req.Ranges))
+	for i, r := range req.Ranges {
+		ranges[i] = strconv.Itoa(r)
+	}
+
+	sl := SavedLookup{
+		ID:     id,
+		Query:  req.Query,
+		DawaID: resp.Addrs[resp.PrimaryIndex].DawaID,
+		Ranges: strings.Join(ranges, ","),
+		Filter: req.Filter,
+		Result: string(result),
+		Token:  token,
+	}
+	if err := db.Create(&sl).Error; err != nil {
+		return nil, err
+	}
+
+	return &sl, nil
+}
+
+// SavedLookupByID returns the saved lookup with the given id, or
+// ErrNoSavedLookup if there is none.
+func SavedLookupByID(db *gorm.DB, id string) (*SavedLookup, error) {
+	var sl SavedLookup
+	err := db.Where("id = ?", id).First(&sl).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoSavedLookup
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sl, nil
+}
+
+// DeleteSavedLookup deletes the saved lookup with the given id, provided
+// token is the token of its creator. It returns ErrNoSavedLookup if there
+// is no saved lookup with both the id and the token.
+func DeleteSavedLookup(db *gorm.DB, id, token string) error {
+	if token == "" {
+		return ParamError{Name: "token"}
+	}
+
+	res := db.Where("id = ? AND token = ?", id, token).Delete(&SavedLookup{})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return ErrNoSavedLookup
+	}
+
+	return nil
+}
+
+// Request returns the request of the saved lookup.
+func (sl SavedLookup) Request() LookupRequest {
+	req := LookupRequest{
+		Query:  sl.Query,
+		Filter: sl.Filter,
+	}
+
+	for _, r := range strings.Split(sl.Ranges, ",") {
+		if n, err := strconv.Atoi(r); err == nil {
+			req.Ranges = append(req.Ranges, n)
+		}
+	}
+
+	return req
+}
+
+// SavedLookupJSON is a saved lookup as returned by the API. Result is only
+// included when a single saved lookup is requested, and Token only when the
+// lookup is saved.
+type SavedLookupJSON struct {
+	ID        string          `json:"id"`
+	URL       string          `json:"url"`
+	Query     string          `json:"q"`
+	DawaID    string          `json:"dawa_id"`
+	Ranges    []int           `json:"ranges"`
+	Filter    int             `json:"filter_below_std"`
+	CreatedAt time.Time       `json:"created_at"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Token     string          `json:"token,omitempty"`
+}
+
+func (sl SavedLookup) JSON(withResult bool) SavedLookupJSON {
+	req := sl.Request()
+	out := SavedLookupJSON{
+		ID:        sl.ID,
+		URL:       "/l/" + sl.ID,
+		Query:     sl.Query,
+		DawaID:    sl.DawaID,
+		Ranges:    req.Ranges,
+		Filter:    sl.Filter,
+		CreatedAt: sl.CreatedAt,
+	}
+
+	if withResult {
+		out.Result = json.RawMessage(sl.Result)
+	}
+
+	return out
+}
@@ -0,0 +1,97 @@
+package hjem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSavedLookups(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := []*Address{
+				{DawaID: "Vej 1, 1000 By", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+				{DawaID: "Vej 2, 1000 By", PostalCode: "1000", BoligaPropertyKind: PropertyHouse, BoligaBuildingSize: 100},
+			}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+
+			bc := fakeBoligaCacher{sales: map[uint][]Sale{
+				addrs[1].ID: {{AddrID: addrs[1].ID, AmountDKK: 2000000, Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}},
+			}}
+			s := &server{db: db, dc: fakeDawaCacher{addrs: addrs}, bc: bc}
+			routes := s.Routes()
+
+			do := func(method, url, body string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				routes.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+				return w
+			}
+
+			w := do("POST", "/api/lookups", `{"q": "Vej 1", "ranges": [500], "filter_below_std": 1}`)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("unexpected status code: %d (expected: %d): %s", w.Code, http.StatusCreated, w.Body)
+			}
+
+			var created SavedLookupJSON
+			json.NewDecoder(w.Body).Decode(&created)
+			if created.ID == "" || created.URL != "/l/"+created.ID || created.DawaID != addrs[0].DawaID {
+				t.Fatalf("unexpected saved lookup: %+v", created)
+			}
+
+			w = do("GET", "/api/lookups/"+created.ID, "")
+			var saved SavedLookupJSON
+			json.NewDecoder(w.Body).Decode(&saved)
+			if len(saved.Ranges) != 1 || saved.Ranges[0] != 500 || saved.Filter != 1 {
+				t.Fatalf("unexpected saved request: %+v", saved)
+			}
+
+			var result LookupResponse
+			if err := json.Unmarshal(saved.Result, &result); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(result.Sales) != 1 || result.Sales[0].Amount != 2000000 {
+				t.Fatalf("unexpected saved result: %+v", result.Sales)
+			}
+
+			w = do("GET", "/api/lookups", "")
+			var list []SavedLookupJSON
+			json.NewDecoder(w.Body).Decode(&list)
+			if len(list) != 1 || list[0].Result != nil {
+				t.Fatalf("unexpected saved lookups: %+v", list)
+			}
+
+			if w = do("GET", "/l/"+created.ID, ""); w.Code != http.StatusOK {
+				t.Fatalf("unexpected status code of permalink: %d (expected: %d)", w.Code, http.StatusOK)
+			}
+
+			if created.Token == "" || list[0].Token != "" {
+				t.Fatalf("expected the token only when saving: %+v, %+v", created, list[0])
+			}
+
+			// only the creator of a saved lookup may delete it
+			if w = do("DELETE", "/api/lookups/"+created.ID, ""); w.Code != http.StatusBadRequest {
+				t.Fatalf("unexpected status code of delete without token: %d (expected: %d)", w.Code, http.StatusBadRequest)
+			}
+
+			if w = do("DELETE", "/api/lookups/"+created.ID+"?token=wrong", ""); w.Code != http.StatusNotFound {
+				t.Fatalf("unexpected status code of delete with wrong token: %d (expected: %d)", w.Code, http.StatusNotFound)
+			}
+
+			if w = do("DELETE", "/api/lookups/"+created.ID+"?token="+created.Token, ""); w.Code != http.StatusNoContent {
+				t.Fatalf("unexpected status code of delete: %d (expected: %d)", w.Code, http.StatusNoContent)
+			}
+
+			for _, url := range []string{"/api/lookups/" + created.ID, "/l/" + created.ID} {
+				if w = do("GET", url, ""); w.Code != http.StatusNotFound {
+					t.Fatalf("unexpected status code of %s: %d (expected: %d)", url, w.Code, http.StatusNotFound)
+				}
+			}
+		})
+	}
+}
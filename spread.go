@@ -0,0 +1,160 @@
+package hjem
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// listingSaleWindow is how long after a listing was last seen, a sale
+	// of its address is still considered the sale of the listing.
+	listingSaleWindow = 90 * 24 * time.Hour
+)
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// AskingPriceFromChange returns the asking price of a sale of amount, given
+// the change in percent from the asking price to the amount, as reported
+// by Boliga.
+func AskingPriceFromChange(amount int, change float64) int {
+	return int(math.Round(float64(amount) / (1 + change/100)))
+}
+
+// matchListings sets the asking price of the sales in resp which have none,
+// from the listings of their address which were taken down shortly before
+// the sale.
+func matchListings(db *gorm.DB, resp *LookupResponse) error {
+	texts := map[string]int{}
+	seen := map[int]bool{}
+	var zips []int
+	for i, a := range resp.Addrs {
+		texts[a.Short()+"/"+a.PostalCode] = i
+
+		zip, err := strconv.Atoi(a.PostalCode)
+		if err != nil || seen[zip] {
+			continue
+		}
+
+		seen[zip] = true
+		zips = append(zips, zip)
+	}
+
+	byAddr := map[int][]Listing{}
+	err := inBatches(len(zips), maxBatchSize(db), func(start, end int) error {
+		var listings []Listing
+		err := db.Where("zip_code IN ? AND active = ?", zips[start:end], false).
+			Find(&listings).Error
+		if err != nil {
+			return err
+		}
+
+		for _, l := range listings {
+			i, ok := texts[l.Address+"/"+strconv.Itoa(l.ZipCode)]
+			if ok {
+				byAddr[i] = append(byAddr[i], l)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range resp.Sales {
+		if s.AskingPrice != 0 {
+			continue
+		}
+
+		for _, l := range byAddr[s.AddrIndex] {
+			if s.When.Before(l.ListedAt) || s.When.After(l.LastSeenAt.Add(listingSaleWindow)) {
+				continue
+			}
+
+			s.AskingPrice = l.AskingPrice
+		}
+	}
+
+	return nil
+}
+
+// SpreadStats is the discount from the asking price to the sale price of
+// the sales of a postal code and year. Discount is the median fraction of
+// the asking price which buyers negotiated off, e.g. 0.03 for sales 3%
+// below the asking price.
+type SpreadStats struct {
+	ZipCode  string  `json:"zipcode,omitempty"`
+	Year     int     `json:"year,omitempty"`
+	N        int     `json:"n"`
+	Discount float64 `json:"discount"`
+}
+
+// AskingSpread is the discount from the asking price to the sale price of
+// the sales of a lookup: overall, by year, and by postal code and year.
+type AskingSpread struct {
+	SpreadStats
+	Years []SpreadStats `json:"years"`
+	Areas []SpreadStats `json:"areas"`
+}
+
+// AnalyseSpread computes the discount from the asking price to the sale
+// price of the sales in resp with a known asking price. It returns nil if
+// none of the sales have one.
+func AnalyseSpread(resp *LookupResponse) *AskingSpread {
+	type K struct {
+		Zip  string
+		Year int
+	}
+
+	var all []float64
+	years := map[int][]float64{}
+	areas := map[K][]float64{}
+	for _, s := range resp.Sales {
+		if s.AskingPrice <= 0 {
+			continue
+		}
+
+		d := 1 - float64(s.Amount)/float64(s.AskingPrice)
+		zip := resp.Addrs[s.AddrIndex].PostalCode
+		all = append(all, d)
+		years[s.When.Year()] = append(years[s.When.Year()], d)
+		areas[K{zip, s.When.Year()}] = append(areas[K{zip, s.When.Year()}], d)
+	}
+
+	if len(all) == 0 {
+		return nil
+	}
+
+	spread := AskingSpread{
+		SpreadStats: SpreadStats{N: len(all), Discount: median(all)},
+	}
+
+	for year, ds := range years {
+		spread.Years = append(spread.Years, SpreadStats{Year: year, N: len(ds), Discount: median(ds)})
+	}
+	sort.Slice(spread.Years, func(i, j int) bool {
+		return spread.Years[i].Year < spread.Years[j].Year
+	})
+
+	for k, ds := range areas {
+		spread.Areas = append(spread.Areas, SpreadStats{ZipCode: k.Zip, Year: k.Year, N: len(ds), Discount: median(ds)})
+	}
+	sort.Slice(spread.Areas, func(i, j int) bool {
+		a, b := spread.Areas[i], spread.Areas[j]
+		if a.ZipCode != b.ZipCode {
+			return a.ZipCode < b.ZipCode
+		}
+
+		return a.Year < b.Year
+	})
+
+	return &spread
+}
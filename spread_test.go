@@ -0,0 +1,98 @@
+package hjem
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAskingPriceFromChange(t *testing.T) {
+	tt := []struct {
+		amount   int
+		change   float64
+		expected int
+	}{
+		{amount: 1900000, change: -5, expected: 2000000},
+		{amount: 2100000, change: 5, expected: 2000000},
+		{amount: 2000000, change: 0, expected: 2000000},
+	}
+
+	for _, tc := range tt {
+		if p := AskingPriceFromChange(tc.amount, tc.change); p != tc.expected {
+			t.Fatalf("unexpected asking price: %d (expected: %d)", p, tc.expected)
+		}
+	}
+}
+
+func TestAnalyseSpread(t *testing.T) {
+	when := func(y int) time.Time {
+		return time.Date(y, 6, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	resp := &LookupResponse{
+		Addrs: []*Address{{PostalCode: "1000"}, {PostalCode: "2000"}},
+		Sales: []*JSONSale{
+			{AddrIndex: 0, Amount: 950000, AskingPrice: 1000000, When: when(2020)},
+			{AddrIndex: 1, Amount: 990000, AskingPrice: 1000000, When: when(2020)},
+			{AddrIndex: 1, Amount: 980000, AskingPrice: 1000000, When: when(2021)},
+			{AddrIndex: 1, Amount: 1000000, When: when(2021)},
+		},
+	}
+
+	spread := AnalyseSpread(resp)
+	if spread == nil {
+		t.Fatalf("expected a spread")
+	}
+
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-9
+	}
+
+	if spread.N != 3 || !near(spread.Discount, 0.02) {
+		t.Fatalf("unexpected overall spread: %+v", spread.SpreadStats)
+	}
+
+	if len(spread.Years) != 2 || spread.Years[0].Year != 2020 || !near(spread.Years[0].Discount, 0.03) {
+		t.Fatalf("unexpected yearly spread: %+v", spread.Years)
+	}
+
+	if len(spread.Areas) != 3 || spread.Areas[0].ZipCode != "1000" || !near(spread.Areas[0].Discount, 0.05) {
+		t.Fatalf("unexpected area spread: %+v", spread.Areas)
+	}
+
+	if AnalyseSpread(&LookupResponse{Sales: resp.Sales[3:]}) != nil {
+		t.Fatalf("expected no spread without asking prices")
+	}
+}
+
+func TestMatchListings(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			listed := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+			db.Create(&Listing{BoligaID: 1, Address: "Vej 1", ZipCode: 1000, AskingPrice: 2000000,
+				ListedAt: listed, LastSeenAt: listed.AddDate(0, 2, 0)})
+			db.Create(&Listing{BoligaID: 2, Address: "Vej 1", ZipCode: 1000, AskingPrice: 3000000,
+				ListedAt: listed, LastSeenAt: listed.AddDate(0, 2, 0), Active: true})
+
+			resp := &LookupResponse{
+				Addrs: []*Address{{StreetName: "Vej", StreetNumber: "1", PostalCode: "1000"}},
+				Sales: []*JSONSale{
+					{Amount: 1900000, When: listed.AddDate(0, 3, 0)},
+					{Amount: 1000000, When: listed.AddDate(-5, 0, 0)},
+				},
+			}
+
+			if err := matchListings(db, resp); err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if p := resp.Sales[0].AskingPrice; p != 2000000 {
+				t.Fatalf("unexpected asking price: %d (expected: 2000000)", p)
+			}
+
+			if p := resp.Sales[1].AskingPrice; p != 0 {
+				t.Fatalf("unexpected asking price of earlier sale: %d", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package hjem
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrValuationColumns = errors.New("valuation file must have the columns: bfe, address, year, property_value, land_value")
+	ErrUnknownBFE       = errors.New("no address is known for the BFE")
+)
+
+// PropertyValuation is a public property valuation (ejendomsvurdering) of
+// an address.
+type PropertyValuation struct {
+	ID            uint `json:"-" gorm:"primaryKey"`
+	AddrID        uint `json:"-" gorm:"not null;uniqueIndex:idx_valuations_addr_year"`
+	BFE           int  `json:"bfe" gorm:"index"`
+	Year          int  `json:"year" gorm:"not null;uniqueIndex:idx_valuations_addr_year"`
+	PropertyValue int  `json:"property_value"`
+	LandValue     int  `json:"land_value"`
+}
+
+// ValuationRecord is a row of a valuation file, before it is linked to an
+// address.
+type ValuationRecord struct {
+	BFE           int
+	Address       string
+	Year          int
+	PropertyValue int
+	LandValue     int
+}
+
+// ReadValuations reads a valuation file: a CSV file, separated by either
+// commas or semicolons, with a header naming the columns bfe, address,
+// year, property_value and land_value. Values may use dots as thousands
+// separators, e.g. "2.150.000". The address may be left empty for records
+// whose BFE is known from another record, see ImportValuations.
+func ReadValuations(r io.Reader) ([]ValuationRecord, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(strings.NewReader(string(content)))
+	header := strings.SplitN(string(content), "\n", 2)[0]
+	if strings.Count(header, ";") > strings.Count(header, ",") {
+		reader.Comma = ';'
+	}
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrValuationColumns
+	}
+
+	cols := map[string]int{}
+	for i, name := range rows[0] {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"bfe", "address", "year", "property_value", "land_value"} {
+		if _, ok := cols[name]; !ok {
+			return nil, ErrValuationColumns
+		}
+	}
+
+	records := make([]ValuationRecord, len(rows)-1)
+	for i, row := range rows[1:] {
+		line := i + 2
+		ints := map[string]*int{
+			"bfe":            &records[i].BFE,
+			"year":           &records[i].Year,
+			"property_value": &records[i].PropertyValue,
+			"land_value":     &records[i].LandValue,
+		}
+
+		for name, v := range ints {
+			n, err := DirtyStringToInt(row[cols[name]])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %s", line, name, row[cols[name]])
+			}
+			*v = n
+		}
+
+		records[i].Address = strings.TrimSpace(row[cols["address"]])
+	}
+
+	return records, nil
+}
+
+// ImportValuations stores the valuations of records, using resolve to find
+// the address of each record. Records without an address are linked by
+// their BFE to the address of another valuation of the same BFE, either
+// stored or among records. Valuations already imported for an address and
+// year are replaced. Records which cannot be resolved are skipped, and
+// returned along with the number of valuations stored.
+func ImportValuations(db *gorm.DB, records []ValuationRecord, resolve func(string) (*Address, error)) (int, []error) {
+	var stored int
+	var errs []error
+
+	// records with an address come first, such that they can resolve the
+	// BFEs of those without
+	sorted := make([]ValuationRecord, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Address != "" && sorted[j].Address == ""
+	})
+
+	for _, rec := range sorted {
+		name := rec.Address
+		if name == "" {
+			name = fmt.Sprintf("BFE %d", rec.BFE)
+		}
+
+		addrID, err := valuationAddr(db, rec, resolve)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		v := PropertyValuation{
+			AddrID:        addrID,
+			BFE:           rec.BFE,
+			Year:          rec.Year,
+			PropertyValue: rec.PropertyValue,
+			LandValue:     rec.LandValue,
+		}
+
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "addr_id"}, {Name: "year"}},
+			DoUpdates: clause.AssignmentColumns([]string{"bfe", "property_value", "land_value"}),
+		}).Create(&v).Error
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		stored += 1
+	}
+
+	return stored, errs
+}
+
+// valuationAddr returns the id of the address of a valuation record, which
+// is resolved by its address, or by its BFE when it has no address.
+func valuationAddr(db *gorm.DB, rec ValuationRecord, resolve func(string) (*Address, error)) (uint, error) {
+	if rec.Address != "" {
+		addr, err := resolve(rec.Address)
+		if err != nil {
+			return 0, err
+		}
+
+		return addr.ID, nil
+	}
+
+	if rec.BFE == 0 {
+		return 0, ErrUnknownBFE
+	}
+
+	var v PropertyValuation
+	err := db.Where("bfe = ?", rec.BFE).Order("year DESC").First(&v).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrUnknownBFE
+	}
+
+	return v.AddrID, err
+}
+
+// Valuations holds the valuations of each address, by address id, ordered
+// by year.
+type Valuations map[uint][]PropertyValuation
+
+// At returns the valuation of an address in effect in year, i.e. the
+// latest valuation no later than year, or nil if year precedes them all.
+func (v Valuations) At(addrID uint, year int) *PropertyValuation {
+	vals := v[addrID]
+	if len(vals) == 0 {
+		return nil
+	}
+
+	i := sort.Search(len(vals), func(i int) bool {
+		return vals[i].Year > year
+	})
+	if i == 0 {
+		return nil
+	}
+
+	return &vals[i-1]
+}
+
+func valuationsOf(db *gorm.DB, addrs []*Address) (Valuations, error) {
+	ids := make([]uint, len(addrs))
+	for i, a := range addrs {
+		ids[i] = a.ID
+	}
+
+	v := Valuations{}
+	err := inBatches(len(ids), maxBatchSize(db), func(start, end int) error {
+		var vals []PropertyValuation
+		err := db.Where("addr_id IN ?", ids[start:end]).
+			Order("year").
+			Find(&vals).Error
+		if err != nil {
+			return err
+		}
+
+		for _, val := range vals {
+			v[val.AddrID] = append(v[val.AddrID], val)
+		}
+
+		return nil
+	})
+
+	return v, err
+}
+
+// ValuationComparison compares sale prices to public valuations. AreaRatio
+// is the median ratio between the price of each sale in the lookup and the
+// valuation in effect at the time of the sale. ImpliedPrice values the
+// primary address at the area ratio, and ListingRatio is the ratio of the
+// asking price of the primary address to its valuation.
+type ValuationComparison struct {
+	AreaRatio        float64            `json:"area_ratio"`
+	N                int                `json:"n"`
+	PrimaryValuation *PropertyValuation `json:"primary_valuation,omitempty"`
+	ImpliedPrice     int                `json:"implied_price,omitempty"`
+	ListingPrice     int                `json:"listing_price,omitempty"`
+	ListingRatio     float64            `json:"listing_ratio,omitempty"`
+}
+
+// CompareValuations sets the valuation ratio of each sale in resp, and
+// compares the sales and the listing price of the primary address to the
+// valuations. It returns nil if none of the addresses have valuations.
+func CompareValuations(resp *LookupResponse, vals Valuations, listingPrice int) *ValuationComparison {
+	if len(vals) == 0 {
+		return nil
+	}
+
+	var ratios []float64
+	for _, s := range resp.Sales {
+		v := vals.At(resp.Addrs[s.AddrIndex].ID, s.When.Year())
+		if v == nil || v.PropertyValue == 0 {
+			continue
+		}
+
+		s.ValuationRatio = float64(s.Amount) / float64(v.PropertyValue)
+		ratios = append(ratios, s.ValuationRatio)
+	}
+
+	var cmp ValuationComparison
+	cmp.N = len(ratios)
+	cmp.AreaRatio = median(ratios)
+
+	primary := resp.Addrs[resp.PrimaryIndex]
+	if pv := vals[primary.ID]; len(pv) > 0 {
+		latest := pv[len(pv)-1]
+		cmp.PrimaryValuation = &latest
+		cmp.ImpliedPrice = int(cmp.AreaRatio * float64(latest.PropertyValue))
+
+		if listingPrice > 0 && latest.PropertyValue > 0 {
+			cmp.ListingPrice = listingPrice
+			cmp.ListingRatio = float64(listingPrice) / float64(latest.PropertyValue)
+		}
+	}
+
+	return &cmp
+}
@@ -0,0 +1,176 @@
+package hjem
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadValuations(t *testing.T) {
+	tt := []struct {
+		name  string
+		in    string
+		value int
+		err   string
+	}{
+		{name: "commas", in: "bfe,address,year,property_value,land_value\n100,\"Vej 1, 1000 By\",2020,2150000,500000\n", value: 2150000},
+		{name: "semicolons", in: "BFE;Address;Year;Property_Value;Land_Value\n100;Vej 1, 1000 By;2020;2.150.000;500.000\n", value: 2150000},
+		{name: "missing column", in: "bfe,address,year\n100,Vej 1,2020\n", err: "must have the columns"},
+		{name: "invalid value", in: "bfe,address,year,property_value,land_value\n100,Vej 1,2020,abc,1\n", err: "line 2: invalid property_value"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			records, err := ReadValuations(strings.NewReader(tc.in))
+			if err != nil {
+				if tc.err != "" && strings.Contains(err.Error(), tc.err) {
+					return
+				}
+
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if tc.err != "" {
+				t.Fatalf("expected error: %s", tc.err)
+			}
+
+			if len(records) != 1 {
+				t.Fatalf("unexpected amount of records: %d (expected: 1)", len(records))
+			}
+
+			r := records[0]
+			if r.Address != "Vej 1, 1000 By" || r.Year != 2020 || r.PropertyValue != tc.value {
+				t.Fatalf("unexpected record: %+v", r)
+			}
+		})
+	}
+}
+
+func TestImportValuations(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addr := Address{DawaID: "Vej 1, 1000 By", StreetName: "Vej", StreetNumber: "1"}
+			db.Create(&addr)
+
+			resolve := func(text string) (*Address, error) {
+				if text != addr.DawaID {
+					return nil, ErrNoAddr
+				}
+
+				return &addr, nil
+			}
+
+			records := []ValuationRecord{
+				{Address: addr.DawaID, Year: 2020, PropertyValue: 2000000},
+				{Address: addr.DawaID, Year: 2020, PropertyValue: 2100000},
+				{Address: "Vej 2, 1000 By", Year: 2020, PropertyValue: 1000000},
+				{BFE: 100, Year: 2018, PropertyValue: 1800000},
+				{BFE: 100, Address: addr.DawaID, Year: 2022, PropertyValue: 2200000},
+				{BFE: 200, Year: 2018, PropertyValue: 1000000},
+			}
+
+			n, errs := ImportValuations(db, records, resolve)
+			if n != 4 || len(errs) != 2 {
+				t.Fatalf("unexpected import: %d stored, errors: %v", n, errs)
+			}
+
+			vals, err := valuationsOf(db, []*Address{&addr})
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			years := map[int]int{}
+			for _, v := range vals[addr.ID] {
+				years[v.Year] = v.PropertyValue
+			}
+
+			if len(years) != 3 || years[2018] != 1800000 || years[2020] != 2100000 || years[2022] != 2200000 {
+				t.Fatalf("unexpected valuations: %+v", vals[addr.ID])
+			}
+		})
+	}
+}
+
+func TestValuationsAt(t *testing.T) {
+	vals := Valuations{
+		1: {
+			{AddrID: 1, Year: 2018, PropertyValue: 2000000},
+			{AddrID: 1, Year: 2020, PropertyValue: 2500000},
+		},
+	}
+
+	tt := []struct {
+		name  string
+		addr  uint
+		year  int
+		value int
+	}{
+		{name: "before every valuation", addr: 1, year: 2010},
+		{name: "first year", addr: 1, year: 2018, value: 2000000},
+		{name: "between valuations", addr: 1, year: 2019, value: 2000000},
+		{name: "after every valuation", addr: 1, year: 2022, value: 2500000},
+		{name: "unknown address", addr: 2, year: 2020},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var value int
+			if v := vals.At(tc.addr, tc.year); v != nil {
+				value = v.PropertyValue
+			}
+
+			if value != tc.value {
+				t.Fatalf("unexpected valuation: %d (expected: %d)", value, tc.value)
+			}
+		})
+	}
+}
+
+func TestCompareValuations(t *testing.T) {
+	when := func(y int) time.Time {
+		return time.Date(y, 6, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	resp := &LookupResponse{
+		Addrs: []*Address{{ID: 1}, {ID: 2}, {ID: 3}},
+		Sales: []*JSONSale{
+			{AddrIndex: 1, Amount: 2400000, When: when(2019)},
+			{AddrIndex: 1, Amount: 3000000, When: when(2021)},
+			{AddrIndex: 2, Amount: 1000000, When: when(2021)},
+			{AddrIndex: 0, Amount: 1000000, When: when(2010)},
+		},
+	}
+	vals := Valuations{
+		1: {{AddrID: 1, Year: 2020, PropertyValue: 2000000}},
+		2: {
+			{AddrID: 2, Year: 2018, PropertyValue: 2000000},
+			{AddrID: 2, Year: 2020, PropertyValue: 2500000},
+		},
+	}
+
+	cmp := CompareValuations(resp, vals, 2600000)
+	if cmp == nil {
+		t.Fatalf("expected a comparison")
+	}
+
+	// both sales of address 2 have a ratio of 1.2 to the valuation in effect
+	if cmp.N != 2 || math.Abs(cmp.AreaRatio-1.2) > 1e-9 {
+		t.Fatalf("unexpected area ratio: %f of %d sales", cmp.AreaRatio, cmp.N)
+	}
+
+	if cmp.ImpliedPrice != 2400000 {
+		t.Fatalf("unexpected implied price: %d (expected: 2400000)", cmp.ImpliedPrice)
+	}
+
+	if fmt.Sprintf("%.2f", cmp.ListingRatio) != "1.30" {
+		t.Fatalf("unexpected listing ratio: %f (expected: 1.3)", cmp.ListingRatio)
+	}
+
+	for _, s := range resp.Sales[2:] {
+		if s.ValuationRatio != 0 {
+			t.Fatalf("unexpected ratio of sale without valuation in effect: %f", s.ValuationRatio)
+		}
+	}
+}
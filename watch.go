@@ -0,0 +1,423 @@
+package hjem
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRadius  = fmt.Errorf("radius must be between 1 and %d meters", maxWatchRadius)
+	ErrUnknownKind    = errors.New("unknown property type")
+	ErrInvalidWebhook = errors.New("webhook must be an http or https URL of a public host")
+	ErrInvalidEmail   = errors.New("email must be a single address, e.g. name@example.com")
+	ErrNoWatch        = errors.New("no watch with the token")
+)
+
+const maxWatchRadius = 5000
+
+// Watch is a saved watchlist entry: sales of properties of Kind within
+// Radius meters of the address are reported to the webhook and email of
+// the watch, when set, and to its Atom feed. A Kind of 0 watches the
+// property type of the address itself. The watch is only accessible by
+// its Token, which is given to its creator.
+type Watch struct {
+	ID        uint         `json:"id" gorm:"primaryKey"`
+	Token     string       `json:"-" gorm:"not null;uniqueIndex:idx_watches_token"`
+	AddrID    uint         `json:"-" gorm:"not null;index"`
+	Address   string       `json:"address" gorm:"not null"`
+	Radius    int          `json:"radius" gorm:"not null"`
+	Kind      PropertyType `json:"-"`
+	Webhook   string       `json:"webhook,omitempty"`
+	Email     string       `json:"email,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	CheckedAt time.Time    `json:"checked_at"`
+}
+
+// WatchSale is a sale found by a watch. FoundAt is when the watch first
+// saw the sale.
+type WatchSale struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	WatchID   uint      `json:"-" gorm:"not null;uniqueIndex:idx_watch_sales_unique"`
+	AddrID    uint      `json:"-" gorm:"not null;uniqueIndex:idx_watch_sales_unique"`
+	Address   string    `json:"address" gorm:"not null"`
+	AmountDKK int       `json:"amount" gorm:"not null;uniqueIndex:idx_watch_sales_unique"`
+	Date      time.Time `json:"when" gorm:"not null;uniqueIndex:idx_watch_sales_unique"`
+	FoundAt   time.Time `json:"found_at" gorm:"index"`
+}
+
+func newWatchToken() (string, error) {
+	return randomID(20)
+}
+
+// ValidateWebhook checks that raw is an http or https URL, whose host
+// resolves to public addresses only, such that watches cannot make the
+// server post to internal services.
+func ValidateWebhook(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidWebhook
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidWebhook, err)
+	}
+
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return ErrInvalidWebhook
+		}
+	}
+
+	return nil
+}
+
+// ValidateEmail checks that raw is a single bare email address, such that
+// the notifications of a watch are sent to exactly one recipient.
+func ValidateEmail(raw string) error {
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Address != raw {
+		return ErrInvalidEmail
+	}
+
+	return nil
+}
+
+var privateNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+
+	return nets
+}()
+
+// publicIP tells whether ip is neither a loopback, link-local, private nor
+// unspecified address.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// webhookClient refuses to connect to addresses which are not public, as a
+// webhook may resolve differently when notified than when validated.
+var webhookClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return ErrInvalidWebhook
+				}
+
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+// PropertyKindFromName returns the property type of a name of
+// PropertyToName, or 0 for an empty name.
+func PropertyKindFromName(name string) (PropertyType, error) {
+	if name == "" {
+		return 0, nil
+	}
+
+	for kind, n := range PropertyToName {
+		if n == name {
+			return kind, nil
+		}
+	}
+
+	return 0, ErrUnknownKind
+}
+
+// MarshalJSON adds the name of the watched property type.
+func (w Watch) MarshalJSON() ([]byte, error) {
+	type watch Watch
+	return json.Marshal(struct {
+		watch
+		Kind string `json:"kind,omitempty"`
+	}{watch(w), PropertyToName[w.Kind]})
+}
+
+// Notifier delivers the new sales found by a watch.
+type Notifier interface {
+	Notify(w *Watch, sales []WatchSale) error
+}
+
+// WebhookNotifier posts the new sales of a watch as JSON to its webhook.
+// Webhooks on addresses which are not public are refused, unless
+// AllowPrivate is set.
+type WebhookNotifier struct {
+	AllowPrivate bool
+}
+
+func (n WebhookNotifier) Notify(w *Watch, sales []WatchSale) error {
+	if w.Webhook == "" {
+		return nil
+	}
+
+	client := webhookClient
+	if n.AllowPrivate {
+		client = &DefaultClient
+	}
+
+	body, err := json.Marshal(struct {
+		Watch *Watch      `json:"watch"`
+		Sales []WatchSale `json:"sales"`
+	}{w, sales})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(w.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook replied with status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// SMTPNotifier mails the new sales of a watch to its email, through the
+// SMTP server at Addr.
+type SMTPNotifier struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (n SMTPNotifier) Notify(w *Watch, sales []WatchSale) error {
+	if w.Email == "" {
+		return nil
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", n.From)
+	fmt.Fprintf(&body, "To: %s\r\n", w.Email)
+	fmt.Fprintf(&body, "Subject: %d new sales near %s\r\n", len(sales), w.Address)
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, s := range sales {
+		fmt.Fprintf(&body, "%s: %d DKK (%s)\r\n", s.Address, s.AmountDKK, s.Date.Format("2006-01-02"))
+	}
+
+	return smtp.SendMail(n.Addr, n.Auth, n.From, []string{w.Email}, []byte(body.String()))
+}
+
+// WatchChecker looks for new sales near the addresses of the watches.
+type WatchChecker struct {
+	db        *gorm.DB
+	dc        DawaCacher
+	bc        BoligaCacher
+	notifiers []Notifier
+}
+
+func NewWatchChecker(db *gorm.DB, dc DawaCacher, bc BoligaCacher, notifiers ...Notifier) *WatchChecker {
+	return &WatchChecker{db, dc, bc, notifiers}
+}
+
+// WatchChecker returns a checker of the watches of the server, sharing
+// the caches and workers of the server.
+func (s *server) WatchChecker(notifiers ...Notifier) *WatchChecker {
+	return NewWatchChecker(s.db, s.dc, s.bc, notifiers...)
+}
+
+// Run checks the watches every interval, until stop is closed.
+func (wc *WatchChecker) Run(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := wc.CheckAll(); err != nil {
+			log.Println("checking watches:", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// CheckAll checks every watch. A failing watch does not keep the remaining
+// watches from being checked, and the first error is returned.
+func (wc *WatchChecker) CheckAll() error {
+	var watches []Watch
+	if err := wc.db.Find(&watches).Error; err != nil {
+		return err
+	}
+
+	var first error
+	for i := range watches {
+		if _, err := wc.Check(&watches[i]); err != nil && first == nil {
+			first = fmt.Errorf("watch %d: %w", watches[i].ID, err)
+		}
+	}
+
+	return first
+}
+
+// Check stores the sales within the radius of a watch which it has not
+// seen before, and notifies about them. Sales found by the first check of
+// a watch are stored without notifying, as they are not new. Sales are
+// stored before notifying, so failed notifications are not retried, but
+// the sales remain in the feed of the watch. It returns the new sales.
+func (wc *WatchChecker) Check(w *Watch) ([]WatchSale, error) {
+	var addr Address
+	if err := wc.db.First(&addr, w.AddrID).Error; err != nil {
+		return nil, err
+	}
+
+	addrs, err := wc.dc.Do(DawaNearbySearch{Addr: addr, Meters: w.Radius})
+	if err != nil {
+		return nil, err
+	}
+
+	sales, _, err := wc.bc.FetchSales(addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := w.Kind
+	if kind == 0 {
+		for _, a := range addrs {
+			if a.ID == addr.ID {
+				kind = a.BoligaPropertyKind
+			}
+		}
+	}
+	addrs, sales = FilterAddressesByProperty(kind, addrs, sales)
+
+	now := time.Now()
+	var found []WatchSale
+	err = wc.db.Transaction(func(tx *gorm.DB) error {
+		for i, a := range addrs {
+			for _, s := range sales[i] {
+				ws := WatchSale{
+					WatchID:   w.ID,
+					AddrID:    a.ID,
+					Address:   a.DawaID,
+					AmountDKK: s.AmountDKK,
+					Date:      s.Date,
+					FoundAt:   now,
+				}
+
+				res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&ws)
+				if res.Error != nil {
+					return res.Error
+				}
+
+				if res.RowsAffected > 0 {
+					found = append(found, ws)
+				}
+			}
+		}
+
+		first := w.CheckedAt.IsZero()
+		w.CheckedAt = now
+		if err := tx.Model(w).Update("checked_at", now).Error; err != nil {
+			return err
+		}
+
+		if first {
+			found = nil
+		}
+
+		return nil
+	})
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Date.After(found[j].Date)
+	})
+
+	var first error
+	for _, n := range wc.notifiers {
+		if err := n.Notify(w, found); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return found, first
+}
+
+// AtomFeed is an Atom feed of the sales found by a watch.
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomEntry struct {
+	ID      string `xml:"id"`
+	Title   string `xml:"title"`
+	Updated string `xml:"updated"`
+	Summary string `xml:"summary"`
+}
+
+// WatchFeed returns the feed of a watch, with its sales ordered by when
+// they were found, newest first.
+func WatchFeed(w *Watch, sales []WatchSale) AtomFeed {
+	feed := AtomFeed{
+		ID:      fmt.Sprintf("urn:hjem:watch:%d", w.ID),
+		Title:   fmt.Sprintf("Sales within %d meters of %s", w.Radius, w.Address),
+		Updated: w.CreatedAt.Format(time.RFC3339),
+	}
+
+	if !w.CheckedAt.IsZero() {
+		feed.Updated = w.CheckedAt.Format(time.RFC3339)
+	}
+
+	for _, s := range sales {
+		feed.Entries = append(feed.Entries, AtomEntry{
+			ID:      fmt.Sprintf("urn:hjem:watch:%d:sale:%d", w.ID, s.ID),
+			Title:   fmt.Sprintf("%s sold for %d DKK", s.Address, s.AmountDKK),
+			Updated: s.FoundAt.Format(time.RFC3339),
+			Summary: fmt.Sprintf("%s was sold on %s for %d DKK.", s.Address, s.Date.Format("2006-01-02"), s.AmountDKK),
+		})
+	}
+
+	return feed
+}
@@ -0,0 +1,302 @@
+package hjem
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeDawaCacher struct {
+	DawaCacher
+	addrs []*Address
+}
+
+// Do returns the first address for searches, and every address for
+// nearby searches.
+func (dc fakeDawaCacher) Do(req DawaRequest) ([]*Address, error) {
+	if _, ok := req.(DawaFuzzySearch); ok {
+		return []*Address{dc.addrs[0]}, nil
+	}
+
+	return append([]*Address{}, dc.addrs...), nil
+}
+
+type fakeBoligaCacher struct {
+	BoligaCacher
+	sales map[uint][]Sale
+}
+
+func (bc fakeBoligaCacher) FetchSales(addrs []*Address) ([][]Sale, []AddrError, error) {
+	sales := make([][]Sale, len(addrs))
+	for i, a := range addrs {
+		sales[i] = bc.sales[a.ID]
+	}
+
+	return sales, nil, nil
+}
+
+func (bc fakeBoligaCacher) AttributeHistory([]*Address) (AttributeHistory, error) {
+	return AttributeHistory{}, nil
+}
+
+// smtpStub accepts a single SMTP session, and sends the received mail on
+// the returned channel.
+func smtpStub(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	mails := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 stub")
+
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					mails <- data.String()
+					reply("250 ok")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "DATA":
+				inData = true
+				reply("354 go ahead")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return l.Addr().String(), mails
+}
+
+func TestWatchChecker(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := []*Address{
+				{DawaID: "Vej 1, 1000 By", BoligaPropertyKind: PropertyHouse},
+				{DawaID: "Vej 2, 1000 By", BoligaPropertyKind: PropertyHouse},
+				{DawaID: "Vej 3, 1000 By", BoligaPropertyKind: PropertyApartment},
+			}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+
+			old := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+			bc := fakeBoligaCacher{sales: map[uint][]Sale{
+				addrs[1].ID: {{AddrID: addrs[1].ID, AmountDKK: 1000000, Date: old}},
+			}}
+
+			var hooked []WatchSale
+			hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Sales []WatchSale `json:"sales"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				hooked = append(hooked, body.Sales...)
+			}))
+			defer hook.Close()
+
+			smtpAddr, mails := smtpStub(t)
+
+			watch := Watch{AddrID: addrs[0].ID, Address: addrs[0].DawaID, Radius: 200, Webhook: hook.URL, Email: "buyer@example.com"}
+			db.Create(&watch)
+
+			wc := NewWatchChecker(db, fakeDawaCacher{addrs: addrs}, bc,
+				WebhookNotifier{AllowPrivate: true}, SMTPNotifier{Addr: smtpAddr, From: "hjem@example.com"})
+
+			found, err := wc.Check(&watch)
+			if err != nil || len(found) != 0 {
+				t.Fatalf("unexpected first check: %v, err: %v", found, err)
+			}
+
+			bc.sales[addrs[1].ID] = append(bc.sales[addrs[1].ID], Sale{AddrID: addrs[1].ID, AmountDKK: 2000000, Date: old.AddDate(2, 0, 0)})
+			bc.sales[addrs[2].ID] = []Sale{{AddrID: addrs[2].ID, AmountDKK: 500000, Date: old.AddDate(2, 0, 0)}}
+
+			found, err = wc.Check(&watch)
+			if err != nil {
+				t.Fatalf("received unexpected error: %s", err)
+			}
+
+			if len(found) != 1 || found[0].AmountDKK != 2000000 {
+				t.Fatalf("unexpected new sales: %+v", found)
+			}
+
+			if len(hooked) != 1 || hooked[0].Address != addrs[1].DawaID {
+				t.Fatalf("unexpected webhook sales: %+v", hooked)
+			}
+
+			select {
+			case mail := <-mails:
+				if !strings.Contains(mail, "To: buyer@example.com") || !strings.Contains(mail, "Vej 2, 1000 By: 2000000 DKK") {
+					t.Fatalf("unexpected mail: %s", mail)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected a mail")
+			}
+
+			found, err = wc.Check(&watch)
+			if err != nil || len(found) != 0 {
+				t.Fatalf("unexpected repeated check: %v, err: %v", found, err)
+			}
+		})
+	}
+}
+
+func TestWatchFeed(t *testing.T) {
+	w := &Watch{ID: 1, Address: "Vej 1, 1000 By", Radius: 200}
+	sales := []WatchSale{{ID: 2, Address: "Vej 2, 1000 By", AmountDKK: 2000000, Date: time.Now()}}
+
+	out, err := xml.Marshal(WatchFeed(w, sales))
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	feed := string(out)
+	for _, expected := range []string{`<feed xmlns="http://www.w3.org/2005/Atom">`, "<id>urn:hjem:watch:1:sale:2</id>", "Vej 2, 1000 By sold for 2000000 DKK"} {
+		if !strings.Contains(feed, expected) {
+			t.Fatalf("unexpected feed: %s (expected to contain: %s)", feed, expected)
+		}
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	tt := []struct {
+		name    string
+		webhook string
+		valid   bool
+	}{
+		{name: "public", webhook: "https://93.184.216.34/hook", valid: true},
+		{name: "other scheme", webhook: "ftp://93.184.216.34/hook"},
+		{name: "relative", webhook: "/hook"},
+		{name: "loopback", webhook: "http://127.0.0.1:8080/hook"},
+		{name: "localhost", webhook: "http://localhost/hook"},
+		{name: "ipv6 loopback", webhook: "http://[::1]/hook"},
+		{name: "link-local", webhook: "http://169.254.169.254/latest/meta-data"},
+		{name: "private", webhook: "http://10.0.0.1/hook"},
+		{name: "unspecified", webhook: "http://0.0.0.0/hook"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateWebhook(tc.webhook)
+			if valid := err == nil; valid != tc.valid {
+				t.Fatalf("unexpected validity: %t (expected: %t), error: %v", valid, tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestWebhookNotifierRefusesPrivate(t *testing.T) {
+	var called bool
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer hook.Close()
+
+	err := WebhookNotifier{}.Notify(&Watch{Webhook: hook.URL}, nil)
+	if !errors.Is(err, ErrInvalidWebhook) || called {
+		t.Fatalf("unexpected notification of private webhook: %v (expected: %s)", err, ErrInvalidWebhook)
+	}
+}
+
+func TestHandleWatches(t *testing.T) {
+	for name, db := range testDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			addrs := []*Address{{DawaID: "Vej 1, 1000 By", BoligaPropertyKind: PropertyHouse}}
+			for _, a := range addrs {
+				db.Create(a)
+			}
+
+			s := &server{db: db, dc: fakeDawaCacher{addrs: addrs}, bc: fakeBoligaCacher{}}
+			routes := s.Routes()
+
+			do := func(method, url, body string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				routes.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+				return w
+			}
+
+			w := do("POST", "/api/watches", `{"q": "Vej 1", "radius": 500, "email": "buyer@example.com"}`)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("unexpected status code: %d (expected: %d): %s", w.Code, http.StatusCreated, w.Body)
+			}
+
+			var created struct {
+				Watch map[string]interface{} `json:"watch"`
+				Token string                 `json:"token"`
+				Feed  string                 `json:"feed"`
+			}
+			json.NewDecoder(w.Body).Decode(&created)
+			if created.Token == "" || created.Feed != "/feed/watch?token="+created.Token {
+				t.Fatalf("unexpected created watch: %+v", created)
+			}
+
+			tests := []struct {
+				name   string
+				method string
+				url    string
+				body   string
+				sc     int
+			}{
+				{name: "listing every watch", method: "GET", url: "/api/watches", sc: http.StatusBadRequest},
+				{name: "unknown token", method: "GET", url: "/api/watches?token=unknown", sc: http.StatusNotFound},
+				{name: "guessed feed", method: "GET", url: "/feed/watch?id=1", sc: http.StatusBadRequest},
+				{name: "private webhook", method: "POST", url: "/api/watches", body: `{"q": "Vej 1", "radius": 500, "webhook": "http://127.0.0.1/hook"}`, sc: http.StatusBadRequest},
+				{name: "invalid email", method: "POST", url: "/api/watches", body: `{"q": "Vej 1", "radius": 500, "email": "not an address"}`, sc: http.StatusBadRequest},
+				{name: "several emails", method: "POST", url: "/api/watches", body: `{"q": "Vej 1", "radius": 500, "email": "a@example.com, b@example.com"}`, sc: http.StatusBadRequest},
+				{name: "too large radius", method: "POST", url: "/api/watches", body: `{"q": "Vej 1", "radius": 100000}`, sc: http.StatusBadRequest},
+				{name: "deleting without token", method: "DELETE", url: "/api/watches?id=1", sc: http.StatusBadRequest},
+				{name: "watch", method: "GET", url: "/api/watches?token=" + created.Token, sc: http.StatusOK},
+				{name: "feed", method: "GET", url: created.Feed, sc: http.StatusOK},
+				{name: "delete", method: "DELETE", url: "/api/watches?token=" + created.Token, sc: http.StatusNoContent},
+				{name: "deleted watch", method: "GET", url: "/api/watches?token=" + created.Token, sc: http.StatusNotFound},
+			}
+
+			for _, tc := range tests {
+				t.Run(tc.name, func(t *testing.T) {
+					w := do(tc.method, tc.url, tc.body)
+					if w.Code != tc.sc {
+						t.Fatalf("unexpected status code: %d (expected: %d): %s", w.Code, tc.sc, w.Body)
+					}
+
+					if strings.Contains(w.Body.String(), created.Token) && tc.method != "POST" {
+						t.Fatalf("unexpected token in reply: %s", w.Body)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package hjem
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XLSXSheet is a sheet of a workbook. Cells may be strings, ints, float64s
+// or time.Times, which are written as text, numbers and dates.
+type XLSXSheet struct {
+	Name string
+	Rows [][]interface{}
+}
+
+// cell styles of xlsxStyles
+const (
+	xlsxStyleDefault = iota
+	xlsxStyleInt
+	xlsxStyleFloat
+	xlsxStyleDate
+)
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border/></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="3" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+</styleSheet>`
+
+// xlsxEpoch is day 0 of the dates of a spreadsheet.
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxColumn returns the name of the i'th column, e.g. "A" or "AB".
+func xlsxColumn(i int) string {
+	var name string
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+
+	return name
+}
+
+// xmlEscape escapes s for use as XML text or attribute values, as written
+// by the workbooks and the SVG maps.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeXLSXSheet(w io.Writer, sheet XLSXSheet) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range sheet.Rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, v := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			switch v := v.(type) {
+			case nil:
+				continue
+			case int:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%d</v></c>`, ref, xlsxStyleInt, v)
+			case float64:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleFloat, strconv.FormatFloat(v, 'f', -1, 64))
+			case time.Time:
+				// spreadsheets have no time zones, so the wall clock is kept
+				wall := time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), 0, time.UTC)
+				days := wall.Sub(xlsxEpoch).Hours() / 24
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleDate, strconv.FormatFloat(days, 'f', -1, 64))
+			default:
+				fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, xmlEscape(fmt.Sprint(v)))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteXLSX writes a workbook of the sheets to w.
+func WriteXLSX(w io.Writer, sheets []XLSXSheet) error {
+	var contentTypes, workbook, rels strings.Builder
+	contentTypes.WriteString(xml.Header)
+	contentTypes.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	contentTypes.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	contentTypes.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	contentTypes.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	contentTypes.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+
+	workbook.WriteString(xml.Header)
+	workbook.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+
+	rels.WriteString(xml.Header)
+	rels.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	for i, sheet := range sheets {
+		n := i + 1
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheet.Name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(sheets)+1)
+	rels.WriteString(`</Relationships>`)
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(fw, f.content); err != nil {
+			return err
+		}
+	}
+
+	for i, sheet := range sheets {
+		fw, err := zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+		if err != nil {
+			return err
+		}
+
+		if err := writeXLSXSheet(fw, sheet); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// addressCells returns the columns of Address.ToSlice as cells, keeping
+// the sizes and years as numbers.
+func addressCells(a *Address) []interface{} {
+	var door, floor string
+	if a.Door != nil {
+		door = *a.Door
+	}
+	if a.Floor != nil {
+		floor = *a.Floor
+	}
+
+	return []interface{}{
+		a.DawaID,
+		a.StreetName,
+		a.StreetNumber,
+		door,
+		floor,
+		a.PostalCode,
+		a.BoligaBuildingSize,
+		a.BoligaPropertySize,
+		a.BoligaBasementSize,
+		a.BoligaRooms,
+		a.BoligaBuiltYear,
+		a.BoligaMonthlyOwnerExpense,
+		a.BBRRenovationYear,
+		a.BBRRoofMaterial,
+		a.BBRWallMaterial,
+		a.BBRHeatingType,
+	}
+}
+
+// saleCells returns the columns of JSONSale.ToSlice as cells, keeping the
+// amounts as numbers and the date of the sale as a date.
+func saleCells(s *JSONSale) []interface{} {
+	return []interface{}{
+		s.Amount,
+		s.When,
+		s.BuildingSize,
+		s.EnergyLabel,
+		s.AskingPrice,
+	}
+}
+
+func stringsToCells(ss []string) []interface{} {
+	row := make([]interface{}, len(ss))
+	for i, s := range ss {
+		row[i] = s
+	}
+
+	return row
+}
+
+// LookupWorkbook returns the sheets of a lookup: its sales, the yearly
+// aggregations of the price per square meter, the projections of the sales
+// of the primary address, and the addresses within each range.
+func LookupWorkbook(resp *LookupResponse) []XLSXSheet {
+	sales := XLSXSheet{Name: "Sales"}
+	headers := append(Address{}.Headers(), JSONSale{}.Headers()...)
+	sales.Rows = append(sales.Rows, stringsToCells(headers))
+	for _, s := range resp.Sales {
+		a := resp.Addrs[s.AddrIndex]
+		sales.Rows = append(sales.Rows, append(addressCells(a), saleCells(s)...))
+	}
+
+	var years []time.Time
+	for t := range resp.SquareMeters.Global {
+		years = append(years, t)
+	}
+	sort.Slice(years, func(i, j int) bool {
+		return years[i].Before(years[j])
+	})
+
+	yearly := XLSXSheet{Name: "Years"}
+	yearly.Rows = append(yearly.Rows, []interface{}{"year", "sqmeter_price_mean", "sqmeter_price_std", "n"})
+	for _, t := range years {
+		agg := resp.SquareMeters.Global[t]
+		yearly.Rows = append(yearly.Rows, []interface{}{t.Year(), agg.Mean, agg.Std, agg.N})
+	}
+
+	projections := XLSXSheet{Name: "Projections"}
+	header := []interface{}{"year"}
+	for i := range resp.SquareMeters.Projections {
+		header = append(header, fmt.Sprintf("projection_%d", i+1))
+	}
+	projections.Rows = append(projections.Rows, header)
+	for _, t := range years {
+		row := []interface{}{t.Year()}
+		for _, p := range resp.SquareMeters.Projections {
+			var v interface{}
+			if price, ok := p[t]; ok {
+				v = price
+			}
+			row = append(row, v)
+		}
+		projections.Rows = append(projections.Rows, row)
+	}
+
+	var meters []int
+	for m := range resp.Ranges {
+		meters = append(meters, m)
+	}
+	sort.Ints(meters)
+
+	ranges := XLSXSheet{Name: "Ranges"}
+	ranges.Rows = append(ranges.Rows, []interface{}{"range_meters", "address"})
+	for _, m := range meters {
+		for _, idx := range resp.Ranges[m] {
+			ranges.Rows = append(ranges.Rows, []interface{}{m, resp.Addrs[idx].DawaID})
+		}
+	}
+
+	return []XLSXSheet{sales, yearly, projections, ranges}
+}
@@ -0,0 +1,94 @@
+package hjem
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXLSXColumn(t *testing.T) {
+	tt := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"}
+	for i, expected := range tt {
+		if c := xlsxColumn(i); c != expected {
+			t.Fatalf("unexpected column name of %d: %s (expected: %s)", i, c, expected)
+		}
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	year := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	door, floor := "tv", "1"
+	resp := &LookupResponse{
+		Addrs: []*Address{
+			{DawaID: "Vej 1, 1000 By", StreetNumber: "1", PostalCode: "1000"},
+			{DawaID: "Vej 2 & 3, 1000 By", StreetNumber: "2", PostalCode: "1000", Door: &door, Floor: &floor, BoligaBuildingSize: 100},
+		},
+		Sales:  []*JSONSale{{AddrIndex: 1, Amount: 2000000, When: year, BuildingSize: 100}},
+		Ranges: map[int][]int{250: {1}},
+		SquareMeters: SquareMeterPrices{
+			Global:      map[time.Time]Aggregation{year: {Mean: 20000, Std: 1000, N: 1}},
+			Projections: []map[time.Time]int{{year: 21000}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteXLSX(&buf, LookupWorkbook(resp)); err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("received unexpected error: %s", err)
+	}
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+
+		// every part must be well-formed
+		dec := xml.NewDecoder(bytes.NewReader(b))
+		for {
+			_, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				t.Fatalf("malformed part %s: %s", f.Name, err)
+			}
+		}
+
+		files[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "xl/workbook.xml", "xl/styles.xml", "xl/worksheets/sheet4.xml"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("missing part: %s", name)
+		}
+	}
+
+	sales := files["xl/worksheets/sheet1.xml"]
+	expected := []string{
+		`<c r="A2" t="inlineStr"><is><t>Vej 2 &amp; 3, 1000 By</t></is></c>`,
+		`<c r="C2" t="inlineStr"><is><t>2</t></is></c>`,
+		`<c r="E2" t="inlineStr"><is><t>1</t></is></c>`,
+		`<c r="G2" s="1"><v>100</v></c>`,
+		`<v>2000000</v>`,
+		`s="3"><v>43831</v>`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(sales, e) {
+			t.Fatalf("expected sales sheet to contain: %s\n%s", e, sales)
+		}
+	}
+
+	if !strings.Contains(files["xl/worksheets/sheet3.xml"], `<c r="B2" s="1"><v>21000</v></c>`) {
+		t.Fatalf("unexpected projections sheet: %s", files["xl/worksheets/sheet3.xml"])
+	}
+}